@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"smack-server/handlers"
+	"smack-server/store"
+	"time"
+)
+
+// runCommand handles administrative subcommands such as
+// `smack-server reset-password alice`. It reports whether args named a command.
+func runCommand(s *store.Store, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "reset-password":
+		resetPasswordCommand(s, args[1:])
+	default:
+		return false
+	}
+	return true
+}
+
+// resetPasswordCommand issues a one-time password reset token for a user and
+// prints it, or sends it to them from Smackbot with -dm
+func resetPasswordCommand(s *store.Store, args []string) {
+	fs := flag.NewFlagSet("reset-password", flag.ExitOnError)
+	dm := fs.Bool("dm", false, "send the reset code to the user as a Smackbot DM instead of printing it")
+	expires := fs.Duration("expires", 24*time.Hour, "how long the reset code is valid")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: smack-server reset-password [-dm] [-expires 24h] <username>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *expires <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	user, err := s.GetUserByUsername(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No user named %q\n", fs.Arg(0))
+		os.Exit(1)
+	}
+
+	token, expiresAt, err := s.CreatePasswordReset(user.ID, "", *expires)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to create reset token:", err)
+		os.Exit(1)
+	}
+
+	if *dm {
+		if _, err := s.SendSmackbotDM(user.ID, handlers.PasswordResetMessage(token, expiresAt)); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to send reset DM:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Sent a password reset code to %s by Smackbot DM (expires %s)\n", user.Username, expiresAt.Format(time.RFC3339))
+		return
+	}
+
+	fmt.Printf("Password reset code for %s (expires %s):\n\n  %s\n\n", user.Username, expiresAt.Format(time.RFC3339), token)
+	fmt.Println("Redeem it with POST /api/auth/password/reset {\"token\": \"...\", \"new_password\": \"...\"}")
+}
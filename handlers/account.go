@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"smack-server/middleware"
+	"smack-server/models"
+	"smack-server/store"
+	"time"
+)
+
+// AccountHandler handles personal data exports and account deletion
+type AccountHandler struct {
+	store     *store.Store
+	hub       *Hub
+	auth      *AuthHandler // for the login lockout when confirming deletion
+	uploadDir string
+	exportDir string
+}
+
+func NewAccountHandler(s *store.Store, hub *Hub, auth *AuthHandler, uploadDir, exportDir string) (*AccountHandler, error) {
+	if err := os.MkdirAll(exportDir, 0700); err != nil {
+		return nil, err
+	}
+	if err := s.FailInterruptedDataExports(); err != nil {
+		log.Printf("Failed to fail interrupted data exports: %v", err)
+	}
+	return &AccountHandler{store: s, hub: hub, auth: auth, uploadDir: uploadDir, exportDir: exportDir}, nil
+}
+
+// uploadURLPattern finds files uploaded through /api/files/upload
+var uploadURLPattern = regexp.MustCompile(`/api/files/([0-9a-f]+\.[A-Za-z0-9]+)`)
+
+// RequestExport starts building a zip of the current user's data. They're sent a
+// Smackbot DM and a data_export_ready event when it's ready to download.
+func (h *AccountHandler) RequestExport(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	exports, err := h.store.GetDataExports(userID)
+	if err != nil {
+		http.Error(w, "Failed to check exports", http.StatusInternalServerError)
+		return
+	}
+	for _, export := range exports {
+		if export.Status == models.DataExportPending {
+			http.Error(w, "An export is already being prepared", http.StatusConflict)
+			return
+		}
+	}
+
+	export, err := h.store.CreateDataExport(userID)
+	if err != nil {
+		http.Error(w, "Failed to start export", http.StatusInternalServerError)
+		return
+	}
+	go h.buildExport(export)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(export)
+}
+
+// ListExports returns the current user's exports, newest first
+func (h *AccountHandler) ListExports(w http.ResponseWriter, r *http.Request) {
+	exports, err := h.store.GetDataExports(middleware.GetUserID(r))
+	if err != nil {
+		http.Error(w, "Failed to fetch exports", http.StatusInternalServerError)
+		return
+	}
+	if exports == nil {
+		exports = []models.DataExport{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(exports)
+}
+
+// DownloadExport sends a finished export's zip
+func (h *AccountHandler) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	export, err := h.store.GetDataExport(r.PathValue("id"))
+	if err != nil || export.UserID != middleware.GetUserID(r) {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	if export.Status != models.DataExportReady || (export.ExpiresAt != nil && time.Now().After(*export.ExpiresAt)) {
+		http.Error(w, "Export isn't available to download", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="smack-export-%s.zip"`, export.CreatedAt.Format("2006-01-02")))
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeFile(w, r, h.exportPath(export.ID))
+}
+
+// DeleteAccount deletes the current user's account once they confirm it with their
+// password, a two-factor code or by having just signed in. What they wrote stays,
+// shown as from a deleted user; everything else is removed.
+func (h *AccountHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req models.DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.store.GetUserByID(userID)
+	if err != nil || user.IsBot() || user.IsDeleted() {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if (req.Password != "" || req.Code != "") && h.auth.rejectThrottledLogin(w, r, user, user.Username) {
+		return
+	}
+	switch {
+	case req.Password != "":
+		if !h.store.ValidatePassword(user, req.Password) {
+			h.auth.recordAuthFailure(r, user, "account deletion: wrong password")
+			http.Error(w, "Password is incorrect", http.StatusForbidden)
+			return
+		}
+	case req.Code != "":
+		if !h.store.IsTwoFactorEnabled(userID) || !h.store.VerifyTwoFactorCode(userID, req.Code) {
+			h.auth.recordAuthFailure(r, user, "account deletion: wrong two-factor code")
+			http.Error(w, "Invalid code", http.StatusForbidden)
+			return
+		}
+	default:
+		session, err := h.store.GetSession(middleware.GetSessionID(r))
+		if err != nil || time.Since(session.CreatedAt) > models.RecentSignInWindow {
+			http.Error(w, "Confirm with your password or a two-factor code, or sign in again first", http.StatusForbidden)
+			return
+		}
+	}
+	if user.Role == models.ServerRoleOwner {
+		owners, err := h.store.CountActiveOwners()
+		if err != nil {
+			http.Error(w, "Failed to check owners", http.StatusInternalServerError)
+			return
+		}
+		if owners <= 1 {
+			http.Error(w, "Make someone else an owner before deleting your account", http.StatusBadRequest)
+			return
+		}
+	}
+
+	revoked, err := h.store.RevokeUserSessions(userID, "")
+	for _, sessionID := range revoked {
+		h.hub.DisconnectSession(sessionID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	exportIDs, err := h.store.DeleteDataExports(userID)
+	if err != nil {
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+	h.removeExportFiles(exportIDs)
+
+	if err := h.store.DeleteUserAccount(userID); err != nil {
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.store.LogSecurityEvent(models.SecurityEvent{
+		Type:      models.SecurityEventAccountDeleted,
+		UserID:    userID,
+		ActorID:   userID,
+		IPAddress: clientIP(r),
+	}); err != nil {
+		log.Printf("Failed to log security event: %v", err)
+	}
+	h.hub.BroadcastUserStatus(userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartExportCleanup starts a goroutine that removes exports once they expire
+func (h *AccountHandler) StartExportCleanup() {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ids, err := h.store.DeleteExpiredDataExports()
+			if err != nil {
+				log.Printf("Failed to delete expired data exports: %v", err)
+				continue
+			}
+			h.removeExportFiles(ids)
+		}
+	}()
+}
+
+// buildExport writes the export's zip and lets the user know how it went
+func (h *AccountHandler) buildExport(export *models.DataExport) {
+	id, userID := export.ID, export.UserID
+
+	size, err := h.writeExport(userID, h.exportPath(id))
+	if err != nil {
+		log.Printf("Failed to build data export %s: %v", id, err)
+		h.removeExportFiles([]string{id})
+		h.store.FailDataExport(id, "Something went wrong while building the export")
+		h.hub.SendSmackbotDM(userID, "Sorry, something went wrong while preparing your data export. Please try again.")
+		return
+	}
+
+	if err := h.store.CompleteDataExport(id, size); err != nil {
+		log.Printf("Failed to complete data export %s: %v", id, err)
+		return
+	}
+	export, err = h.store.GetDataExport(id)
+	if err != nil {
+		// The account was deleted while the export was being built
+		h.removeExportFiles([]string{id})
+		return
+	}
+
+	h.hub.SendSmackbotDM(userID, fmt.Sprintf("📦 Your data export is ready. It can be downloaded until %s UTC.",
+		export.ExpiresAt.UTC().Format("Jan 2, 2006 at 15:04")))
+	h.hub.SendToUser(userID, models.WSMessage{
+		Type:    models.WSTypeDataExportReady,
+		Payload: export,
+	})
+}
+
+// writeExport writes a zip of everything stored about a user to path, returning its size
+func (h *AccountHandler) writeExport(userID, path string) (int64, error) {
+	user, err := h.store.GetUserByID(userID)
+	if err != nil {
+		return 0, err
+	}
+	profileFields, err := h.store.GetUserProfileValues(userID)
+	if err != nil {
+		return 0, err
+	}
+	preferences, err := h.store.GetAllUserPreferences(userID)
+	if err != nil {
+		return 0, err
+	}
+	dnd, err := h.store.GetDNDSettings(userID)
+	if err != nil {
+		return 0, err
+	}
+	messages, err := h.store.GetMessagesByUser(userID)
+	if err != nil {
+		return 0, err
+	}
+	reactions, err := h.store.GetReactionsByUser(userID)
+	if err != nil {
+		return 0, err
+	}
+	reminders, err := h.store.GetAllRemindersForUser(userID)
+	if err != nil {
+		return 0, err
+	}
+	kanban, err := h.store.GetKanbanActivity(userID)
+	if err != nil {
+		return 0, err
+	}
+	commands, err := h.store.GetCommandsCreatedBy(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	user.ProfileFields = profileFields
+	if preferences == nil {
+		preferences = []models.UserPreference{}
+	}
+	if messages == nil {
+		messages = []models.ExportedMessage{}
+	}
+	if reactions == nil {
+		reactions = []models.Reaction{}
+	}
+	if reminders == nil {
+		reminders = []models.Reminder{}
+	}
+	if commands == nil {
+		commands = []models.CustomCommand{}
+	}
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", struct {
+			models.UserResponse
+			Email string `json:"email,omitempty"`
+		}{user.ToPrivateResponse(), user.Email}},
+		{"preferences.json", struct {
+			Preferences  []models.UserPreference `json:"preferences"`
+			DoNotDisturb *models.DNDSettings     `json:"do_not_disturb"`
+		}{preferences, dnd}},
+		{"messages.json", messages},
+		{"reactions.json", reactions},
+		{"reminders.json", reminders},
+		{"kanban.json", kanban},
+		{"commands.json", commands},
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	now := time.Now()
+	zw := zip.NewWriter(f)
+	for _, file := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return 0, err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return 0, err
+		}
+	}
+
+	// Files the user uploaded, found from their messages and avatar
+	uploads := map[string]bool{}
+	for _, match := range uploadURLPattern.FindAllStringSubmatch(user.AvatarURL, -1) {
+		uploads[match[1]] = true
+	}
+	for _, msg := range messages {
+		for _, match := range uploadURLPattern.FindAllStringSubmatch(msg.Content, -1) {
+			uploads[match[1]] = true
+		}
+	}
+	for name := range uploads {
+		if err := addFileToZip(zw, "files/"+name, filepath.Join(h.uploadDir, name)); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// addFileToZip copies a file into the zip, skipping it if it no longer exists
+func addFileToZip(zw *zip.Writer, name, path string) error {
+	src, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+func (h *AccountHandler) exportPath(exportID string) string {
+	return filepath.Join(h.exportDir, exportID+".zip")
+}
+
+func (h *AccountHandler) removeExportFiles(exportIDs []string) {
+	for _, id := range exportIDs {
+		if err := os.Remove(h.exportPath(id)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove data export %s: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"smack-server/middleware"
+	"smack-server/models"
+	"smack-server/store"
+	"time"
+)
+
+// AdminHandler serves the /api/admin user management endpoints. Routes are wrapped
+// with withAdmin, so the caller is always a server owner or admin.
+type AdminHandler struct {
+	store *store.Store
+	hub   *Hub
+}
+
+func NewAdminHandler(s *store.Store, hub *Hub) *AdminHandler {
+	return &AdminHandler{store: s, hub: hub}
+}
+
+// ListUsers returns every account, including deactivated ones
+func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.store.GetAllUsers()
+	if err != nil {
+		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+
+	responses := []models.UserResponse{}
+	for _, u := range users {
+		// Bots and Smackbot have no password and aren't managed here
+		if u.PasswordHash == "" {
+			continue
+		}
+		responses = append(responses, u.ToPrivateResponse())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responses)
+}
+
+func (h *AdminHandler) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateUserRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !models.IsValidServerRole(req.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	actor, target, ok := h.loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	// Only owners can grant the owner role
+	if req.Role == models.ServerRoleOwner && actor.Role != models.ServerRoleOwner {
+		http.Error(w, "Only owners can make someone an owner", http.StatusForbidden)
+		return
+	}
+	if target.Role == models.ServerRoleOwner && !target.IsDeactivated() && req.Role != models.ServerRoleOwner && !h.canRemoveOwner(w) {
+		return
+	}
+
+	if err := h.store.SetUserRole(target.ID, req.Role); err != nil {
+		http.Error(w, "Failed to update role", http.StatusInternalServerError)
+		return
+	}
+	target.Role = req.Role
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(target.ToPrivateResponse())
+}
+
+// DeactivateUser blocks sign-in and signs the user out everywhere
+func (h *AdminHandler) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if target.ID == actor.ID {
+		http.Error(w, "You can't deactivate yourself", http.StatusBadRequest)
+		return
+	}
+	if target.Role == models.ServerRoleOwner && !target.IsDeactivated() && !h.canRemoveOwner(w) {
+		return
+	}
+
+	revoked, err := h.store.DeactivateUser(target.ID)
+	for _, sessionID := range revoked {
+		h.hub.DisconnectSession(sessionID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to deactivate user", http.StatusInternalServerError)
+		return
+	}
+
+	user, _ := h.store.GetUserByID(target.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user.ToPrivateResponse())
+}
+
+func (h *AdminHandler) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	_, target, ok := h.loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.ReactivateUser(target.ID); err != nil {
+		http.Error(w, "Failed to reactivate user", http.StatusInternalServerError)
+		return
+	}
+	target.DeactivatedAt = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(target.ToPrivateResponse())
+}
+
+// ResetUserPassword issues a one-time password reset code for a user, returning it
+// or sending it to them from Smackbot
+func (h *AdminHandler) ResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.AdminPasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	actor, target, ok := h.loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	ttl := 24 * time.Hour
+	if req.ExpiresInMinutes > 0 {
+		ttl = time.Duration(req.ExpiresInMinutes) * time.Minute
+	}
+
+	token, expiresAt, err := h.store.CreatePasswordReset(target.ID, actor.ID, ttl)
+	if err != nil {
+		http.Error(w, "Failed to create reset code", http.StatusInternalServerError)
+		return
+	}
+
+	resp := models.AdminPasswordResetResponse{ExpiresAt: expiresAt}
+	if req.DeliverByDM {
+		if _, err := h.hub.SendSmackbotDM(target.ID, PasswordResetMessage(token, expiresAt)); err != nil {
+			http.Error(w, "Failed to send reset code", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		resp.Token = token
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// loadTarget loads the acting admin and the user named in the path. Admins can only
+// manage members and guests; owners can manage anyone. Bots, system users and
+// deleted accounts can't be managed.
+func (h *AdminHandler) loadTarget(w http.ResponseWriter, r *http.Request) (actor, target *models.User, ok bool) {
+	actor, err := h.store.GetUserByID(middleware.GetUserID(r))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	target, err = h.store.GetUserByID(r.PathValue("id"))
+	if err != nil || target.PasswordHash == "" {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if target.IsDeleted() {
+		http.Error(w, "Deleted accounts can't be managed", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	if target.IsAdmin() && actor.Role != models.ServerRoleOwner && target.ID != actor.ID {
+		http.Error(w, "Only owners can manage admins and owners", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return actor, target, true
+}
+
+// canRemoveOwner makes sure the server keeps at least one active owner
+func (h *AdminHandler) canRemoveOwner(w http.ResponseWriter) bool {
+	owners, err := h.store.CountActiveOwners()
+	if err != nil {
+		http.Error(w, "Failed to check owners", http.StatusInternalServerError)
+		return false
+	}
+	if owners <= 1 {
+		http.Error(w, "The server must have at least one owner", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
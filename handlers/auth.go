@@ -2,18 +2,27 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"smack-server/mailer"
 	"smack-server/middleware"
 	"smack-server/models"
 	"smack-server/store"
+	"strings"
+	"time"
 )
 
 type AuthHandler struct {
-	store *store.Store
+	store  *store.Store
+	hub    *Hub
+	mailer *mailer.Mailer
 }
 
-func NewAuthHandler(s *store.Store) *AuthHandler {
-	return &AuthHandler{store: s}
+func NewAuthHandler(s *store.Store, hub *Hub, m *mailer.Mailer) *AuthHandler {
+	return &AuthHandler{store: s, hub: hub, mailer: m}
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -23,30 +32,321 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Username == "" || req.Password == "" || req.DisplayName == "" {
-		http.Error(w, "Username, display name, and password are required", http.StatusBadRequest)
+	user, ok := h.createAccount(w, req, false)
+	if !ok {
+		return
+	}
+
+	h.signIn(w, r, user, req.DeviceName)
+}
+
+// SendRegistrationEmailCode emails a code proving the address belongs to the person
+// registering. It's needed to register when registration is limited to email domains.
+func (h *AuthHandler) SendRegistrationEmailCode(w http.ResponseWriter, r *http.Request) {
+	var req models.EmailVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if !strings.Contains(req.Email, "@") {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
 		return
 	}
 
-	if len(req.Password) < 6 {
-		http.Error(w, "Password must be at least 6 characters", http.StatusBadRequest)
+	settings, err := h.store.GetRegistrationSettings()
+	if err != nil {
+		http.Error(w, "Failed to load registration settings", http.StatusInternalServerError)
+		return
+	}
+	if settings.Mode != models.RegistrationModeDomain {
+		http.Error(w, "Registration doesn't need a verified email address", http.StatusBadRequest)
+		return
+	}
+	if !settings.AllowsEmail(req.Email) {
+		http.Error(w, "Registration requires an email address at "+strings.Join(settings.AllowedDomains, ", "), http.StatusForbidden)
+		return
+	}
+	if existing, _ := h.store.GetUserByEmail(req.Email); existing != nil {
+		http.Error(w, "Email already in use", http.StatusConflict)
 		return
 	}
 
+	code, err := h.store.CreateEmailVerification(req.Email)
+	if err == store.ErrEmailCodeTooSoon {
+		http.Error(w, "A code was just sent; wait a minute before asking for another", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create verification code", http.StatusInternalServerError)
+		return
+	}
+
+	body := fmt.Sprintf("Your Smack verification code is %s\n\nIt expires in %d minutes. If you didn't try to register, you can ignore this email.",
+		code, int(store.EmailVerificationTTL.Minutes()))
+	if err := h.mailer.Send(req.Email, "Your Smack verification code", body); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", req.Email, err)
+		h.store.DeleteEmailVerification(req.Email)
+		http.Error(w, "Failed to send verification email", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// RegisterWithInvite creates an account and joins the invite's channel in one step.
+// A use of the invite is claimed before the account is created and given back if
+// creating it fails, so an account is never left behind without its invite.
+func (h *AuthHandler) RegisterWithInvite(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+
+	// Check the invite before creating anything
+	invite, err := h.store.GetChannelInviteByToken(token)
+	if err != nil {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+	if !invite.IsUsable() {
+		http.Error(w, "Invite has expired or been used up", http.StatusGone)
+		return
+	}
+	channel, err := h.store.GetChannel(invite.ChannelID)
+	if err != nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	if channel.ArchivedAt != nil {
+		http.Error(w, errChannelArchived, http.StatusForbidden)
+		return
+	}
+
+	var req models.RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	invite, err = h.store.ClaimChannelInvite(token)
+	if err == store.ErrInviteUnusable {
+		http.Error(w, "Invite has expired or been used up", http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to redeem invite", http.StatusInternalServerError)
+		return
+	}
+
+	user, ok := h.createAccount(w, req, true)
+	if !ok {
+		h.store.ReleaseChannelInvite(invite.ID)
+		return
+	}
+
+	if err := h.store.JoinClaimedChannelInvite(invite, user.ID); err != nil {
+		log.Printf("Failed to add user %s to channel %s from invite: %v", user.ID, invite.ChannelID, err)
+	} else {
+		h.hub.AnnounceMembership(channel, user, nil, true)
+	}
+
+	h.signIn(w, r, user, req.DeviceName)
+}
+
+// createAccount validates a registration and creates and onboards the user,
+// writing an error response and returning false on failure. channelInvite is set
+// when registering through a channel invite link, which counts as an invitation.
+func (h *AuthHandler) createAccount(w http.ResponseWriter, req models.RegisterRequest, channelInvite bool) (*models.User, bool) {
+	if h.passwordLoginDisabled() {
+		http.Error(w, "Registration is only available through single sign-on", http.StatusForbidden)
+		return nil, false
+	}
+
+	if req.Username == "" || req.Password == "" || req.DisplayName == "" {
+		http.Error(w, "Username, display name, and password are required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email != "" && !strings.Contains(req.Email, "@") {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return nil, false
+	}
+
+	emailVerified, ok := h.checkRegistrationAllowed(w, req, channelInvite)
+	if !ok {
+		return nil, false
+	}
+
+	if !h.checkPassword(w, req.Password, req.Username) {
+		return nil, false
+	}
+
 	// Check if username exists
 	existing, _ := h.store.GetUserByUsername(req.Username)
 	if existing != nil {
 		http.Error(w, "Username already taken", http.StatusConflict)
-		return
+		return nil, false
+	}
+	if req.Email != "" {
+		if existing, _ := h.store.GetUserByEmail(req.Email); existing != nil {
+			http.Error(w, "Email already in use", http.StatusConflict)
+			return nil, false
+		}
+	}
+
+	var invite *models.RegistrationInvite
+	if req.InviteCode != "" {
+		var err error
+		invite, err = h.store.ClaimRegistrationInvite(req.InviteCode)
+		if err != nil {
+			http.Error(w, "Invite code is invalid or has expired", http.StatusForbidden)
+			return nil, false
+		}
 	}
 
 	user, err := h.store.CreateUser(req.Username, req.DisplayName, req.Password)
 	if err != nil {
+		if invite != nil {
+			h.store.ReleaseRegistrationInvite(invite.ID)
+		}
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if invite != nil {
+		if err := h.store.RecordRegistrationInviteUse(invite.ID, user.ID); err != nil {
+			log.Printf("Failed to record invite use for user %s: %v", user.ID, err)
+		}
+		// The first account on a server is always its owner
+		if invite.Role != user.Role && user.Role != models.ServerRoleOwner {
+			if err := h.store.SetUserRole(user.ID, invite.Role); err == nil {
+				user.Role = invite.Role
+			}
+		}
+	}
+
+	if req.Email != "" {
+		if err := h.store.SetUserEmail(user.ID, req.Email); err != nil {
+			log.Printf("Failed to set email for user %s: %v", user.ID, err)
+		}
+		user.Email = req.Email
+	}
+	if emailVerified {
+		if err := h.store.MarkEmailVerified(user.ID); err != nil {
+			log.Printf("Failed to mark email verified for user %s: %v", user.ID, err)
+		}
+		h.store.DeleteEmailVerification(req.Email)
+	}
+
+	// Join default channels, starter boards and apps, and send the welcome DM
+	if err := h.store.OnboardUser(user.ID); err != nil {
+		log.Printf("Failed to onboard user %s: %v", user.ID, err)
+	}
+
+	return user, true
+}
+
+// passwordLoginDisabled reports whether single sign-on has replaced password login
+func (h *AuthHandler) passwordLoginDisabled() bool {
+	settings, err := h.store.GetOIDCSettings()
+	return err == nil && settings.Enabled && settings.DisablePasswordLogin
+}
+
+// checkRegistrationAllowed enforces the server's registration mode, writing an error
+// response and returning false if the registration isn't allowed. Invite codes are
+// checked when they're claimed. emailVerified is true when the email's code was
+// checked, which domain mode needs unless an invite code is used.
+func (h *AuthHandler) checkRegistrationAllowed(w http.ResponseWriter, req models.RegisterRequest, channelInvite bool) (emailVerified, ok bool) {
+	settings, err := h.store.GetRegistrationSettings()
+	if err != nil {
+		http.Error(w, "Failed to load registration settings", http.StatusInternalServerError)
+		return false, false
+	}
+
+	switch settings.Mode {
+	case models.RegistrationModeClosed:
+		http.Error(w, "Registration is closed", http.StatusForbidden)
+		return false, false
+	case models.RegistrationModeInvite:
+		if req.InviteCode == "" && !channelInvite {
+			http.Error(w, "An invite code is required to register", http.StatusForbidden)
+			return false, false
+		}
+	case models.RegistrationModeDomain:
+		if req.InviteCode != "" {
+			return false, true
+		}
+		if !settings.AllowsEmail(req.Email) {
+			http.Error(w, "Registration requires an email address at "+strings.Join(settings.AllowedDomains, ", "), http.StatusForbidden)
+			return false, false
+		}
+		if req.EmailCode == "" {
+			http.Error(w, "Enter the code sent to your email address", http.StatusForbidden)
+			return false, false
+		}
+		if err := h.store.CheckEmailVerification(req.Email, req.EmailCode); err != nil {
+			http.Error(w, "Email verification code is invalid or has expired", http.StatusForbidden)
+			return false, false
+		}
+		return true, true
+	}
+	return false, true
+}
+
+// checkPassword validates a new password against the server's password policy,
+// writing an error response and returning false if it doesn't comply
+func (h *AuthHandler) checkPassword(w http.ResponseWriter, password, username string) bool {
+	policy, err := h.store.GetPasswordPolicy()
+	if err != nil {
+		http.Error(w, "Failed to load password policy", http.StatusInternalServerError)
+		return false
+	}
+	if err := policy.Check(password, username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// signIn starts a session for a user whose password checked out, or responds with a
+// login challenge if they need to enter or set up a second factor first
+func (h *AuthHandler) signIn(w http.ResponseWriter, r *http.Request, user *models.User, deviceName string) {
+	enabled := h.store.IsTwoFactorEnabled(user.ID)
+	if !enabled && !h.store.IsTwoFactorRequired() {
+		h.startSession(w, r, user, deviceName, nil)
+		return
+	}
+
+	token, expiresAt, err := h.store.CreateLoginChallenge(user.ID, deviceName)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
 		return
 	}
 
-	token, err := middleware.GenerateToken(user.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.LoginChallenge{
+		TwoFactorRequired:  true,
+		EnrollmentRequired: !enabled,
+		ChallengeToken:     token,
+		ExpiresAt:          expiresAt,
+	})
+}
+
+// startSession signs the user in on a new session and responds with its tokens.
+// Failed attempts against the account are only cleared here, once every factor has
+// been checked.
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, user *models.User, deviceName string, recoveryCodes []string) {
+	session, refreshToken, err := h.store.CreateSession(user.ID, deviceName, r.UserAgent(), clientIP(r))
+	if err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	h.store.ClearLoginFailures(accountThrottleKey(user.ID))
+
+	h.respondWithTokens(w, user, session, refreshToken, recoveryCodes)
+}
+
+func (h *AuthHandler) respondWithTokens(w http.ResponseWriter, user *models.User, session *models.Session, refreshToken string, recoveryCodes []string) {
+	token, expiresAt, err := middleware.GenerateToken(user.ID, session.ID)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
@@ -54,11 +354,94 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(models.AuthResponse{
-		Token: token,
-		User:  user.ToResponse(),
+		Token:            token,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+		SessionID:        session.ID,
+		User:             user.ToResponse(),
+		RecoveryCodes:    recoveryCodes,
 	})
 }
 
+// trustedProxies are the networks of the reverse proxies in front of the server.
+// Only connections from these have their X-Forwarded-For header believed.
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies sets the reverse proxies, as a comma-separated list of IPs and
+// CIDRs, whose X-Forwarded-For header is used to find the client's address
+func SetTrustedProxies(list string) error {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	trustedProxies = nets
+	return nil
+}
+
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address the request came from. X-Forwarded-For is only
+// believed when the connection comes from a trusted proxy, and then only up to the
+// right-most hop that isn't one, since anything left of that can be made up by the
+// client.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req models.LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -68,29 +451,282 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.store.GetUserByUsername(req.Username)
 	if err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		user = nil
+	}
+
+	// Refuse the attempt outright while earlier failures are backing off
+	if h.rejectThrottledLogin(w, r, user, req.Username) {
 		return
 	}
 
-	if !h.store.ValidatePassword(user, req.Password) {
+	if user == nil || !h.store.ValidatePassword(user, req.Password) {
+		h.recordLoginFailure(r, user, req.Username)
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
+	if user.IsDeactivated() {
+		http.Error(w, "Account has been deactivated", http.StatusForbidden)
+		return
+	}
+
+	// Owners can still use their password so a broken provider can't lock everyone out
+	if user.Role != models.ServerRoleOwner && h.passwordLoginDisabled() {
+		http.Error(w, "Password login is disabled; sign in with single sign-on", http.StatusForbidden)
+		return
+	}
+
 	// Update status to online
-	h.store.UpdateUserStatus(user.ID, "online")
+	h.store.SetUserPresence(user.ID, "online")
+
+	h.signIn(w, r, user, req.DeviceName)
+}
+
+// ChangePassword sets a new password for the current user and signs out their other sessions
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
 
-	token, err := middleware.GenerateToken(user.ID)
+	user, err := h.store.GetUserByID(userID)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if h.rejectThrottledLogin(w, r, user, user.Username) {
+		return
+	}
+	if !h.store.ValidatePassword(user, req.CurrentPassword) {
+		h.recordAuthFailure(r, user, "password change: wrong password")
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	if !h.checkPassword(w, req.NewPassword, user.Username) {
+		return
+	}
+
+	if err := h.store.UpdateUserPassword(userID, req.NewPassword); err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+
+	h.revokeSessions(userID, middleware.GetSessionID(r))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetPassword sets a new password using a one-time reset token and signs the user
+// out everywhere
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.store.GetPasswordResetUserID(req.Token)
+	if err != nil {
+		http.Error(w, "Reset token is invalid or has expired", http.StatusBadRequest)
+		return
+	}
+	user, err := h.store.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if !h.checkPassword(w, req.NewPassword, user.Username) {
+		return
+	}
+
+	if _, err := h.store.ResetPassword(req.Token, req.NewPassword); err != nil {
+		if errors.Is(err, store.ErrResetTokenInvalid) {
+			http.Error(w, "Reset token is invalid or has expired", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	h.revokeSessions(userID, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetPasswordPolicy returns the password rules so clients can show them before submitting
+func (h *AuthHandler) GetPasswordPolicy(w http.ResponseWriter, r *http.Request) {
+	policy, err := h.store.GetPasswordPolicy()
+	if err != nil {
+		http.Error(w, "Failed to load password policy", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models.AuthResponse{
-		Token: token,
-		User:  user.ToResponse(),
-	})
+	json.NewEncoder(w).Encode(policy)
+}
+
+// UpdatePasswordPolicy sets the password rules new passwords are checked against
+func (h *AuthHandler) UpdatePasswordPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy models.PasswordPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if policy.MinLength < 1 || policy.MinLength > models.MaxPasswordLength {
+		http.Error(w, fmt.Sprintf("min_length must be between 1 and %d", models.MaxPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.SetPasswordPolicy(policy); err != nil {
+		http.Error(w, "Failed to update", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// revokeSessions signs a user out of every session except exceptID and closes their connections
+func (h *AuthHandler) revokeSessions(userID, exceptID string) {
+	revoked, err := h.store.RevokeUserSessions(userID, exceptID)
+	if err != nil {
+		log.Printf("Failed to revoke sessions for user %s: %v", userID, err)
+	}
+	for _, sessionID := range revoked {
+		h.hub.DisconnectSession(sessionID)
+	}
+}
+
+// PasswordResetMessage is the Smackbot DM that delivers a reset token
+func PasswordResetMessage(token string, expiresAt time.Time) string {
+	return fmt.Sprintf("A password reset was requested for your account. Use this one-time code to choose a new password:\n\n`%s`\n\nIt expires %s. If you weren't expecting this, let an admin know.",
+		token, expiresAt.UTC().Format("Jan 2, 2006 at 15:04 UTC"))
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh token
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	session, refreshToken, err := h.store.RefreshSession(req.RefreshToken)
+	if errors.Is(err, store.ErrRefreshTokenReused) {
+		log.Printf("Refresh token reused for session %s; session revoked", session.ID)
+		h.hub.DisconnectSession(session.ID)
+		http.Error(w, "Refresh token has already been used; session revoked", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, store.ErrSessionInvalid) {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.store.GetUserByID(session.UserID)
+	if err != nil || user.IsDeactivated() {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	h.respondWithTokens(w, user, session, refreshToken, nil)
+}
+
+// Logout revokes the current session
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	sessionID := middleware.GetSessionID(r)
+
+	if err := h.store.RevokeSession(sessionID); err != nil {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+	h.hub.DisconnectSession(sessionID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	sessionID := middleware.GetSessionID(r)
+
+	sessions, err := h.store.GetActiveSessions(userID)
+	if err != nil {
+		http.Error(w, "Failed to get sessions", http.StatusInternalServerError)
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == sessionID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession signs out one of the user's sessions
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	sessionID := r.PathValue("id")
+
+	session, err := h.store.GetSession(sessionID)
+	if err != nil || session.UserID != userID {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.store.RevokeSession(sessionID); err != nil {
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+	h.hub.DisconnectSession(sessionID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeOtherSessions signs out every session except the current one
+func (h *AuthHandler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	h.revokeSessions(userID, middleware.GetSessionID(r))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateSigningKey replaces the access token signing key. Tokens signed with the
+// old key stay valid until they expire.
+func (h *AuthHandler) RotateSigningKey(w http.ResponseWriter, r *http.Request) {
+	key, err := h.store.RotateSigningKey()
+	if err != nil {
+		http.Error(w, "Failed to rotate signing key", http.StatusInternalServerError)
+		return
+	}
+
+	if err := LoadSigningKeys(h.store); err != nil {
+		http.Error(w, "Failed to load signing keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
+
+// LoadSigningKeys loads the stored signing keys into the token keyring
+func LoadSigningKeys(s *store.Store) error {
+	keys, err := s.GetSigningKeys()
+	if err != nil {
+		return err
+	}
+	return middleware.SetSigningKeys(keys)
 }
 
 func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
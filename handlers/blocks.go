@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"smack-server/middleware"
+	"smack-server/models"
+	"smack-server/store"
+)
+
+// ListBlocks returns everyone the current user has blocked
+func (h *UserHandler) ListBlocks(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	blocks, err := h.store.GetBlockedUsers(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch blocked users", http.StatusInternalServerError)
+		return
+	}
+	if blocks == nil {
+		blocks = []models.UserBlock{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blocks)
+}
+
+// Block stops a user from opening DMs with the current user, collapses their
+// messages and silences their mentions
+func (h *UserHandler) Block(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	targetID := r.PathValue("id")
+
+	if targetID == userID {
+		http.Error(w, "You can't block yourself", http.StatusBadRequest)
+		return
+	}
+	target, err := h.store.GetUserByID(targetID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if target.ID == "smackbot" {
+		http.Error(w, "Smackbot can't be blocked", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.BlockUser(userID, targetID); err != nil {
+		http.Error(w, "Failed to block user", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "blocked"})
+}
+
+func (h *UserHandler) Unblock(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	if err := h.store.UnblockUser(userID, r.PathValue("id")); err != nil {
+		http.Error(w, "Failed to unblock user", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "unblocked"})
+}
+
+// collapseBlockedMessages hides the content of messages from people the viewer has
+// blocked. System notices, such as someone joining, are left as they are.
+func collapseBlockedMessages(s *store.Store, viewerID string, messages []models.MessageWithUser) {
+	blocked, err := s.GetBlockedUserIDs(viewerID)
+	if err != nil || len(blocked) == 0 {
+		return
+	}
+	for i := range messages {
+		if blocked[messages[i].UserID] && messages[i].Type != models.MessageTypeSystem {
+			messages[i].Collapse()
+		}
+	}
+}
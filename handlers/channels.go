@@ -2,25 +2,32 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"smack-server/middleware"
 	"smack-server/models"
 	"smack-server/store"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// errChannelArchived is returned when a write is attempted in an archived channel
+const errChannelArchived = "Channel is archived and read-only"
+
 type ChannelHandler struct {
 	store *store.Store
+	hub   *Hub
 }
 
-func NewChannelHandler(s *store.Store) *ChannelHandler {
-	return &ChannelHandler{store: s}
+func NewChannelHandler(s *store.Store, hub *Hub) *ChannelHandler {
+	return &ChannelHandler{store: s, hub: hub}
 }
 
 func (h *ChannelHandler) List(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 
-	channels, err := h.store.GetChannelsForUser(userID)
+	channels, err := h.store.GetChannelsForUser(userID, r.URL.Query().Get("include_archived") == "true")
 	if err != nil {
 		http.Error(w, "Failed to fetch channels", http.StatusInternalServerError)
 		return
@@ -49,6 +56,61 @@ func (h *ChannelHandler) ListPublic(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(channels)
 }
 
+// Directory lists browsable channels with search, sorting and cursor pagination
+func (h *ChannelHandler) Directory(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	params := r.URL.Query()
+
+	query := models.ChannelDirectoryQuery{
+		Query:           params.Get("q"),
+		Sort:            params.Get("sort"),
+		Cursor:          params.Get("cursor"),
+		Limit:           50,
+		IncludeArchived: params.Get("include_archived") == "true",
+	}
+
+	switch query.Sort {
+	case "", models.DirectorySortName, models.DirectorySortMembers, models.DirectorySortActivity, models.DirectorySortCreated:
+	default:
+		http.Error(w, "Sort must be one of: name, members, activity, created", http.StatusBadRequest)
+		return
+	}
+
+	if l := params.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			query.Limit = parsed
+		}
+	}
+
+	page, err := h.store.GetChannelDirectory(userID, query)
+	if err == store.ErrInvalidCursor {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch channels", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func (h *ChannelHandler) ListArchived(w http.ResponseWriter, r *http.Request) {
+	channels, err := h.store.GetArchivedChannels()
+	if err != nil {
+		http.Error(w, "Failed to fetch channels", http.StatusInternalServerError)
+		return
+	}
+
+	if channels == nil {
+		channels = []models.Channel{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channels)
+}
+
 func (h *ChannelHandler) Create(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 
@@ -63,6 +125,11 @@ func (h *ChannelHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.isGuest(userID) {
+		http.Error(w, "Guests can't create channels", http.StatusForbidden)
+		return
+	}
+
 	// Sanitize channel name
 	req.Name = strings.ToLower(strings.ReplaceAll(req.Name, " ", "-"))
 
@@ -94,7 +161,42 @@ func (h *ChannelHandler) Get(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(channel)
 }
 
+// authorize checks the caller's channel role against the permission matrix for an action
+func (h *ChannelHandler) authorize(w http.ResponseWriter, channelID, userID, action string) bool {
+	if !models.ChannelRoleCan(channelRole(h.store, channelID, userID), action) {
+		http.Error(w, "Not authorized to perform this action in this channel", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// channelRole returns the caller's role in a channel, or "" if they aren't a member.
+// Server owners and admins act as channel owners everywhere except DMs, so channels
+// without an owner, like #general, can still be managed.
+func channelRole(s *store.Store, channelID, userID string) string {
+	role, err := s.GetChannelMemberRole(channelID, userID)
+	if err == nil && role == models.ChannelRoleOwner {
+		return role
+	}
+	if user, uerr := s.GetUserByID(userID); uerr == nil && user.IsAdmin() {
+		if channel, cerr := s.GetChannel(channelID); cerr == nil && !channel.IsDirect {
+			return models.ChannelRoleOwner
+		}
+	}
+	if err != nil {
+		return ""
+	}
+	return role
+}
+
+// isGuest reports whether the user has the guest server role
+func (h *ChannelHandler) isGuest(userID string) bool {
+	user, err := h.store.GetUserByID(userID)
+	return err == nil && user.Role == models.ServerRoleGuest
+}
+
 func (h *ChannelHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
 	channelID := r.PathValue("id")
 	if channelID == "" {
 		http.Error(w, "Channel ID required", http.StatusBadRequest)
@@ -112,6 +214,11 @@ func (h *ChannelHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if channel.ArchivedAt != nil {
+		http.Error(w, errChannelArchived, http.StatusForbidden)
+		return
+	}
+
 	var req models.UpdateChannelRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -120,24 +227,130 @@ func (h *ChannelHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	name := channel.Name
 	description := channel.Description
+	topic := channel.Topic
 	if req.Name != "" {
 		name = strings.ToLower(strings.ReplaceAll(req.Name, " ", "-"))
 	}
 	if req.Description != nil {
 		description = *req.Description
 	}
+	if req.Topic != nil {
+		topic = *req.Topic
+	}
+
+	if name != channel.Name && !h.authorize(w, channelID, userID, models.ChannelActionRename) {
+		return
+	}
+	if description != channel.Description && !h.authorize(w, channelID, userID, models.ChannelActionUpdate) {
+		return
+	}
+	if topic != channel.Topic && !h.authorize(w, channelID, userID, models.ChannelActionSetTopic) {
+		return
+	}
+	toggledJoinLeave := req.JoinLeaveMessages != nil && *req.JoinLeaveMessages != channel.JoinLeaveMessages
+	if toggledJoinLeave && !h.authorize(w, channelID, userID, models.ChannelActionUpdate) {
+		return
+	}
+
+	user, err := h.store.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
 
-	err = h.store.UpdateChannel(channelID, name, description)
+	err = h.store.UpdateChannel(channelID, name, description, topic)
 	if err != nil {
 		http.Error(w, "Failed to update channel", http.StatusInternalServerError)
 		return
 	}
 
+	if toggledJoinLeave {
+		if err := h.store.SetChannelJoinLeaveMessages(channelID, *req.JoinLeaveMessages); err != nil {
+			http.Error(w, "Failed to update channel", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Record each change, announce it in the channel and tell clients what changed
+	var changes []models.ChannelChange
+	for _, c := range []struct{ field, old, new string }{
+		{models.ChannelFieldName, channel.Name, name},
+		{models.ChannelFieldDescription, channel.Description, description},
+		{models.ChannelFieldTopic, channel.Topic, topic},
+	} {
+		if c.old == c.new {
+			continue
+		}
+		change, err := h.store.RecordChannelChange(channelID, userID, c.field, c.old, c.new)
+		if err != nil {
+			continue
+		}
+		changes = append(changes, *change)
+		h.hub.PostSystemMessage(channelID, user, channelChangeText(user.DisplayName, *change))
+	}
+
 	updatedChannel, _ := h.store.GetChannel(channelID)
+	if len(changes) > 0 || toggledJoinLeave {
+		h.hub.BroadcastToChannel(channelID, models.WSMessage{
+			Type: models.WSTypeChannelUpdate,
+			Payload: map[string]interface{}{
+				"channel":    updatedChannel,
+				"changes":    changes,
+				"changed_by": user.ToResponse(),
+			},
+		})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updatedChannel)
 }
 
+// channelChangeText describes a metadata change for the system message posted in the channel
+func channelChangeText(actor string, change models.ChannelChange) string {
+	switch change.Field {
+	case models.ChannelFieldName:
+		return fmt.Sprintf("%s renamed #%s to #%s", actor, change.OldValue, change.NewValue)
+	case models.ChannelFieldTopic:
+		if change.NewValue == "" {
+			return fmt.Sprintf("%s cleared the channel topic", actor)
+		}
+		return fmt.Sprintf("%s set the channel topic: %s", actor, change.NewValue)
+	default:
+		if change.NewValue == "" {
+			return fmt.Sprintf("%s cleared the channel description", actor)
+		}
+		return fmt.Sprintf("%s changed the channel description: %s", actor, change.NewValue)
+	}
+}
+
+func (h *ChannelHandler) History(w http.ResponseWriter, r *http.Request) {
+	channelID := r.PathValue("id")
+	if channelID == "" {
+		http.Error(w, "Channel ID required", http.StatusBadRequest)
+		return
+	}
+
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 200 {
+			limit = parsed
+		}
+	}
+
+	changes, err := h.store.GetChannelChanges(channelID, limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch channel history", http.StatusInternalServerError)
+		return
+	}
+
+	if changes == nil {
+		changes = []models.ChannelChange{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
 func (h *ChannelHandler) Join(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 	channelID := r.PathValue("id")
@@ -158,10 +371,20 @@ func (h *ChannelHandler) Join(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.store.JoinChannel(channelID, userID)
-	if err != nil {
-		http.Error(w, "Failed to join channel", http.StatusInternalServerError)
-		return
+	if isMember, _ := h.store.IsChannelMember(channelID, userID); !isMember {
+		if h.isGuest(userID) {
+			http.Error(w, "Guests can only join channels they're invited to", http.StatusForbidden)
+			return
+		}
+
+		err = h.store.JoinChannel(channelID, userID)
+		if err != nil {
+			http.Error(w, "Failed to join channel", http.StatusInternalServerError)
+			return
+		}
+
+		user, _ := h.store.GetUserByID(userID)
+		h.hub.AnnounceMembership(channel, user, nil, true)
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -175,53 +398,79 @@ func (h *ChannelHandler) Members(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	members, err := h.store.GetChannelMembers(channelID)
+	members, err := h.store.GetChannelMembersWithRoles(channelID)
 	if err != nil {
 		http.Error(w, "Failed to fetch members", http.StatusInternalServerError)
 		return
 	}
 
-	responses := make([]models.UserResponse, len(members))
-	for i, m := range members {
-		responses[i] = m.ToResponse()
+	if members == nil {
+		members = []models.ChannelMemberResponse{}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(responses)
+	json.NewEncoder(w).Encode(members)
 }
 
-func (h *ChannelHandler) CreateDM(w http.ResponseWriter, r *http.Request) {
+// RemoveMember kicks a user from a channel
+func (h *ChannelHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+	targetUserID := r.PathValue("userId")
 
-	var req struct {
-		UserID string `json:"user_id"`
+	if channelID == "" || targetUserID == "" {
+		http.Error(w, "Channel ID and User ID required", http.StatusBadRequest)
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+
+	channel, err := h.store.GetChannel(channelID)
+	if err != nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
 		return
 	}
 
-	if req.UserID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+	if channel.IsDirect {
+		http.Error(w, "Cannot remove members from direct message channels", http.StatusBadRequest)
 		return
 	}
 
-	if req.UserID == userID {
-		http.Error(w, "Cannot create DM with yourself", http.StatusBadRequest)
+	if !h.authorize(w, channelID, userID, models.ChannelActionKick) {
 		return
 	}
 
-	channel, err := h.store.GetOrCreateDMChannel(userID, req.UserID)
+	targetRole, err := h.store.GetChannelMemberRole(channelID, targetUserID)
 	if err != nil {
-		http.Error(w, "Failed to create DM channel", http.StatusInternalServerError)
+		http.Error(w, "User is not a member of this channel", http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(channel)
+	// Owners can't be kicked, and only owners can kick admins
+	callerRole := channelRole(h.store, channelID, userID)
+	if targetRole == models.ChannelRoleOwner {
+		http.Error(w, "Cannot remove the channel owner", http.StatusBadRequest)
+		return
+	}
+	if targetRole == models.ChannelRoleAdmin && callerRole != models.ChannelRoleOwner {
+		http.Error(w, "Only the channel owner can remove admins", http.StatusForbidden)
+		return
+	}
+
+	err = h.store.LeaveChannel(channelID, targetUserID)
+	if err != nil {
+		http.Error(w, "Failed to remove member", http.StatusInternalServerError)
+		return
+	}
+
+	actor, _ := h.store.GetUserByID(userID)
+	if target, err := h.store.GetUserByID(targetUserID); err == nil {
+		h.hub.AnnounceMembership(channel, target, actor, false)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *ChannelHandler) MarkAsRead(w http.ResponseWriter, r *http.Request) {
+// AddMembers lets channel owners and admins add other people to the channel
+func (h *ChannelHandler) AddMembers(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 	channelID := r.PathValue("id")
 
@@ -230,72 +479,168 @@ func (h *ChannelHandler) MarkAsRead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.store.MarkChannelAsRead(channelID, userID)
+	var req models.AddChannelMembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.UserIDs) == 0 {
+		http.Error(w, "User IDs are required", http.StatusBadRequest)
+		return
+	}
+
+	channel, err := h.store.GetChannel(channelID)
 	if err != nil {
-		http.Error(w, "Failed to mark channel as read", http.StatusInternalServerError)
+		http.Error(w, "Channel not found", http.StatusNotFound)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	if channel.IsDirect {
+		http.Error(w, "Cannot add members to direct message channels", http.StatusBadRequest)
+		return
+	}
+
+	if channel.ArchivedAt != nil {
+		http.Error(w, errChannelArchived, http.StatusForbidden)
+		return
+	}
+
+	if !h.authorize(w, channelID, userID, models.ChannelActionAddMembers) {
+		return
+	}
+
+	// Look everyone up first so a bad ID doesn't leave the channel half-updated
+	var users []*models.User
+	for _, id := range req.UserIDs {
+		user, err := h.store.GetUserByID(id)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		users = append(users, user)
+	}
+
+	actor, _ := h.store.GetUserByID(userID)
+	added := []models.UserResponse{}
+	for _, user := range users {
+		if isMember, _ := h.store.IsChannelMember(channelID, user.ID); isMember {
+			continue
+		}
+		if err := h.store.JoinChannel(channelID, user.ID); err != nil {
+			http.Error(w, "Failed to add member", http.StatusInternalServerError)
+			return
+		}
+		h.hub.AnnounceMembership(channel, user, actor, true)
+		added = append(added, user.ToResponse())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"added": added})
 }
 
-func (h *ChannelHandler) Mute(w http.ResponseWriter, r *http.Request) {
+// UpdateMemberRole changes a member's channel role. Making someone owner transfers ownership.
+func (h *ChannelHandler) UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 	channelID := r.PathValue("id")
+	targetUserID := r.PathValue("userId")
 
-	if channelID == "" {
-		http.Error(w, "Channel ID required", http.StatusBadRequest)
+	if channelID == "" || targetUserID == "" {
+		http.Error(w, "Channel ID and User ID required", http.StatusBadRequest)
 		return
 	}
 
-	err := h.store.MuteChannel(userID, channelID)
+	channel, err := h.store.GetChannel(channelID)
 	if err != nil {
-		http.Error(w, "Failed to mute channel", http.StatusInternalServerError)
+		http.Error(w, "Channel not found", http.StatusNotFound)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "muted"})
-}
+	if channel.IsDirect {
+		http.Error(w, "Direct message channels don't have roles", http.StatusBadRequest)
+		return
+	}
 
-func (h *ChannelHandler) Unmute(w http.ResponseWriter, r *http.Request) {
-	userID := middleware.GetUserID(r)
-	channelID := r.PathValue("id")
+	if !h.authorize(w, channelID, userID, models.ChannelActionManageRoles) {
+		return
+	}
 
-	if channelID == "" {
-		http.Error(w, "Channel ID required", http.StatusBadRequest)
+	var req models.UpdateChannelMemberRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	err := h.store.UnmuteChannel(userID, channelID)
+	if !models.IsValidChannelRole(req.Role) {
+		http.Error(w, "Invalid role. Use: owner, admin, or member", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.store.GetChannelMemberRole(channelID, targetUserID); err != nil {
+		http.Error(w, "User is not a member of this channel", http.StatusNotFound)
+		return
+	}
+
+	if targetUserID == userID && req.Role != models.ChannelRoleOwner {
+		http.Error(w, "Transfer ownership to another member before changing your own role", http.StatusBadRequest)
+		return
+	}
+
+	// A channel has a single owner, so the previous owner steps down to admin
+	if req.Role == models.ChannelRoleOwner {
+		err = h.store.TransferChannelOwnership(channelID, targetUserID)
+	} else {
+		err = h.store.SetChannelMemberRole(channelID, targetUserID, req.Role)
+	}
 	if err != nil {
-		http.Error(w, "Failed to unmute channel", http.StatusInternalServerError)
+		http.Error(w, "Failed to update role", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "unmuted"})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"channel_id": channelID,
+		"user_id":    targetUserID,
+		"role":       req.Role,
+	})
 }
 
-func (h *ChannelHandler) GetMuted(w http.ResponseWriter, r *http.Request) {
+func (h *ChannelHandler) ListPins(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+	if channelID == "" {
+		http.Error(w, "Channel ID required", http.StatusBadRequest)
+		return
+	}
 
-	channelIDs, err := h.store.GetMutedChannels(userID)
+	// Members can see pins, and so can server admins outside of DMs
+	if channelRole(h.store, channelID, userID) == "" {
+		http.Error(w, "Not a member of this channel", http.StatusForbidden)
+		return
+	}
+
+	pins, err := h.store.GetPinnedMessages(channelID)
 	if err != nil {
-		http.Error(w, "Failed to get muted channels", http.StatusInternalServerError)
+		http.Error(w, "Failed to fetch pinned messages", http.StatusInternalServerError)
 		return
 	}
 
-	if channelIDs == nil {
-		channelIDs = []string{}
+	if pins == nil {
+		pins = []models.PinnedMessage{}
+	}
+	if blocked, err := h.store.GetBlockedUserIDs(userID); err == nil {
+		for i := range pins {
+			if blocked[pins[i].UserID] && pins[i].Type != models.MessageTypeSystem {
+				pins[i].Collapse()
+			}
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(channelIDs)
+	json.NewEncoder(w).Encode(pins)
 }
 
-func (h *ChannelHandler) Leave(w http.ResponseWriter, r *http.Request) {
+func (h *ChannelHandler) Pin(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 	channelID := r.PathValue("id")
 
@@ -304,29 +649,80 @@ func (h *ChannelHandler) Leave(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if it's the general channel (can't leave)
-	channel, err := h.store.GetChannel(channelID)
-	if err != nil {
-		http.Error(w, "Channel not found", http.StatusNotFound)
+	var req models.PinMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	if channel.Name == "general" {
-		http.Error(w, "Cannot leave the general channel", http.StatusBadRequest)
+	msg, err := h.store.GetMessage(req.MessageID)
+	if err != nil || msg.ChannelID != channelID {
+		http.Error(w, "Message not found in this channel", http.StatusNotFound)
 		return
 	}
 
-	err = h.store.LeaveChannel(channelID, userID)
-	if err != nil {
-		http.Error(w, "Failed to leave channel", http.StatusInternalServerError)
+	if !h.authorize(w, channelID, userID, models.ChannelActionPin) {
+		return
+	}
+
+	if err := h.store.PinMessage(channelID, req.MessageID, userID); err != nil {
+		http.Error(w, "Failed to pin message", http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "left"})
+	json.NewEncoder(w).Encode(map[string]string{"status": "pinned"})
 }
 
-func (h *ChannelHandler) Clear(w http.ResponseWriter, r *http.Request) {
+func (h *ChannelHandler) Unpin(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+	messageID := r.PathValue("messageId")
+
+	if channelID == "" || messageID == "" {
+		http.Error(w, "Channel ID and Message ID required", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorize(w, channelID, userID, models.ChannelActionPin) {
+		return
+	}
+
+	if err := h.store.UnpinMessage(channelID, messageID); err != nil {
+		http.Error(w, "Failed to unpin message", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ChannelHandler) CreateDM(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req models.CreateDMRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userIDs := req.UserIDs
+	if req.UserID != "" {
+		userIDs = append(userIDs, req.UserID)
+	}
+
+	if len(userIDs) == 0 {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	h.openDM(w, userID, userIDs)
+}
+
+// AddDMMembers adds people to a direct message. Since a DM is identified by its
+// member set, this opens the conversation for the larger group rather than
+// changing the existing one.
+func (h *ChannelHandler) AddDMMembers(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
 	channelID := r.PathValue("id")
 
 	if channelID == "" {
@@ -334,12 +730,543 @@ func (h *ChannelHandler) Clear(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.store.ClearChannelMessages(channelID)
-	if err != nil {
-		http.Error(w, "Failed to clear channel messages", http.StatusInternalServerError)
+	var req models.AddDMMembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	if len(req.UserIDs) == 0 {
+		http.Error(w, "User IDs are required", http.StatusBadRequest)
+		return
+	}
+
+	memberIDs, err := h.store.GetDMMemberIDs(channelID)
+	if err != nil {
+		http.Error(w, "Direct message not found", http.StatusNotFound)
+		return
+	}
+
+	if isMember, err := h.store.IsChannelMember(channelID, userID); err != nil || !isMember {
+		http.Error(w, "Not a member of this direct message", http.StatusForbidden)
+		return
+	}
+
+	h.openDM(w, userID, append(memberIDs, req.UserIDs...))
+}
+
+// openDM validates the participants and returns the DM channel for them and the caller
+func (h *ChannelHandler) openDM(w http.ResponseWriter, userID string, userIDs []string) {
+	seen := map[string]bool{userID: true}
+	var others []string
+	for _, id := range userIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if _, err := h.store.GetUserByID(id); err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		others = append(others, id)
+	}
+
+	if len(others) == 0 {
+		http.Error(w, "Cannot create DM with yourself", http.StatusBadRequest)
+		return
+	}
+
+	if len(others)+1 > models.MaxGroupDMMembers {
+		http.Error(w, fmt.Sprintf("Direct messages can have at most %d members", models.MaxGroupDMMembers), http.StatusBadRequest)
+		return
+	}
+
+	channel, err := h.store.GetOrCreateGroupDM(userID, others)
+	if err == store.ErrUserBlocked {
+		http.Error(w, "You can't message this user", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create DM channel", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channel)
+}
+
+func (h *ChannelHandler) MarkAsRead(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+
+	if channelID == "" {
+		http.Error(w, "Channel ID required", http.StatusBadRequest)
+		return
+	}
+
+	err := h.store.MarkChannelAsRead(channelID, userID)
+	if err != nil {
+		http.Error(w, "Failed to mark channel as read", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+func (h *ChannelHandler) Mute(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+
+	if channelID == "" {
+		http.Error(w, "Channel ID required", http.StatusBadRequest)
+		return
+	}
+
+	err := h.store.MuteChannel(userID, channelID)
+	if err != nil {
+		http.Error(w, "Failed to mute channel", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "muted"})
+}
+
+func (h *ChannelHandler) Unmute(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+
+	if channelID == "" {
+		http.Error(w, "Channel ID required", http.StatusBadRequest)
+		return
+	}
+
+	err := h.store.UnmuteChannel(userID, channelID)
+	if err != nil {
+		http.Error(w, "Failed to unmute channel", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "unmuted"})
+}
+
+func (h *ChannelHandler) GetNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+
+	if channelID == "" {
+		http.Error(w, "Channel ID required", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := h.store.GetChannelNotificationSettings(userID, channelID)
+	if err != nil {
+		http.Error(w, "Failed to fetch notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *ChannelHandler) UpdateNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+
+	if channelID == "" {
+		http.Error(w, "Channel ID required", http.StatusBadRequest)
+		return
+	}
+
+	if isMember, err := h.store.IsChannelMember(channelID, userID); err != nil || !isMember {
+		http.Error(w, "Not a member of this channel", http.StatusForbidden)
+		return
+	}
+
+	var req models.UpdateNotificationSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	current, err := h.store.GetChannelNotificationSettings(userID, channelID)
+	if err != nil {
+		http.Error(w, "Failed to fetch notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	level := current.Level
+	if req.Level != "" {
+		if !models.IsValidNotificationLevel(req.Level) {
+			http.Error(w, "Level must be one of: all, mentions, nothing", http.StatusBadRequest)
+			return
+		}
+		level = req.Level
+	}
+
+	mutedUntil := req.MutedUntil
+	if mutedUntil != nil && !mutedUntil.After(time.Now()) {
+		mutedUntil = nil
+	}
+
+	if err := h.store.SetChannelNotificationSettings(userID, channelID, level, mutedUntil); err != nil {
+		http.Error(w, "Failed to update notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	settings, _ := h.store.GetChannelNotificationSettings(userID, channelID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *ChannelHandler) GetMuted(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	channelIDs, err := h.store.GetMutedChannels(userID)
+	if err != nil {
+		http.Error(w, "Failed to get muted channels", http.StatusInternalServerError)
+		return
+	}
+
+	if channelIDs == nil {
+		channelIDs = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channelIDs)
+}
+
+func (h *ChannelHandler) Leave(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+
+	if channelID == "" {
+		http.Error(w, "Channel ID required", http.StatusBadRequest)
+		return
+	}
+
+	// Check if it's the general channel (can't leave)
+	channel, err := h.store.GetChannel(channelID)
+	if err != nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	if channel.Name == "general" {
+		http.Error(w, "Cannot leave the general channel", http.StatusBadRequest)
+		return
+	}
+
+	wasMember, _ := h.store.IsChannelMember(channelID, userID)
+
+	err = h.store.LeaveChannel(channelID, userID)
+	if err != nil {
+		http.Error(w, "Failed to leave channel", http.StatusInternalServerError)
+		return
+	}
+
+	if wasMember {
+		user, _ := h.store.GetUserByID(userID)
+		h.hub.AnnounceMembership(channel, user, nil, false)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "left"})
+}
+
+func (h *ChannelHandler) Clear(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+
+	if channelID == "" {
+		http.Error(w, "Channel ID required", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorize(w, channelID, userID, models.ChannelActionClear) {
+		return
+	}
+
+	err := h.store.ClearChannelMessages(channelID)
+	if err != nil {
+		http.Error(w, "Failed to clear channel messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "cleared"})
 }
+
+func (h *ChannelHandler) Archive(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+
+	if channelID == "" {
+		http.Error(w, "Channel ID required", http.StatusBadRequest)
+		return
+	}
+
+	channel, err := h.store.GetChannel(channelID)
+	if err != nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	if channel.IsDirect {
+		http.Error(w, "Cannot archive direct message channels", http.StatusBadRequest)
+		return
+	}
+
+	if channel.Name == "general" {
+		http.Error(w, "Cannot archive the general channel", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorize(w, channelID, userID, models.ChannelActionArchive) {
+		return
+	}
+
+	if channel.ArchivedAt == nil {
+		if err := h.store.ArchiveChannel(channelID, userID); err != nil {
+			http.Error(w, "Failed to archive channel", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	updatedChannel, _ := h.store.GetChannel(channelID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedChannel)
+}
+
+func (h *ChannelHandler) Unarchive(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+
+	if channelID == "" {
+		http.Error(w, "Channel ID required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.store.GetChannel(channelID); err != nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	if !h.authorize(w, channelID, userID, models.ChannelActionArchive) {
+		return
+	}
+
+	if err := h.store.UnarchiveChannel(channelID); err != nil {
+		http.Error(w, "Failed to unarchive channel", http.StatusInternalServerError)
+		return
+	}
+
+	updatedChannel, _ := h.store.GetChannel(channelID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedChannel)
+}
+
+func (h *ChannelHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+
+	if channelID == "" {
+		http.Error(w, "Channel ID required", http.StatusBadRequest)
+		return
+	}
+
+	channel, err := h.store.GetChannel(channelID)
+	if err != nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	if channel.IsDirect {
+		http.Error(w, "Cannot create invites for direct message channels", http.StatusBadRequest)
+		return
+	}
+
+	if channel.ArchivedAt != nil {
+		http.Error(w, errChannelArchived, http.StatusForbidden)
+		return
+	}
+
+	if !h.authorize(w, channelID, userID, models.ChannelActionInvite) {
+		return
+	}
+
+	var req models.CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Role == "" {
+		req.Role = models.ChannelRoleMember
+	}
+	if !models.IsValidChannelRole(req.Role) || req.Role == models.ChannelRoleOwner {
+		http.Error(w, "Role must be admin or member", http.StatusBadRequest)
+		return
+	}
+	// Inviting people straight in as admins needs the same rights as promoting them
+	if req.Role != models.ChannelRoleMember && !h.authorize(w, channelID, userID, models.ChannelActionManageRoles) {
+		return
+	}
+
+	if req.MaxUses != nil && *req.MaxUses < 1 {
+		http.Error(w, "max_uses must be at least 1", http.StatusBadRequest)
+		return
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	invite, err := h.store.CreateChannelInvite(channelID, userID, req.Role, req.MaxUses, req.ExpiresAt)
+	if err != nil {
+		http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invite)
+}
+
+func (h *ChannelHandler) ListInvites(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+
+	if channelID == "" {
+		http.Error(w, "Channel ID required", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorize(w, channelID, userID, models.ChannelActionManageInvites) {
+		return
+	}
+
+	invites, err := h.store.GetChannelInvites(channelID)
+	if err != nil {
+		http.Error(w, "Failed to fetch invites", http.StatusInternalServerError)
+		return
+	}
+
+	if invites == nil {
+		invites = []models.ChannelInviteWithUses{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+// RevokeInvite disables an invite. Its creator or a channel owner/admin can revoke it.
+func (h *ChannelHandler) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	channelID := r.PathValue("id")
+	inviteID := r.PathValue("inviteId")
+
+	if channelID == "" || inviteID == "" {
+		http.Error(w, "Channel ID and invite ID required", http.StatusBadRequest)
+		return
+	}
+
+	invite, err := h.store.GetChannelInvite(inviteID)
+	if err != nil || invite.ChannelID != channelID {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	if invite.CreatedBy != userID && !h.authorize(w, channelID, userID, models.ChannelActionManageInvites) {
+		return
+	}
+
+	if err := h.store.RevokeChannelInvite(inviteID); err != nil {
+		http.Error(w, "Failed to revoke invite", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PreviewInvite shows which channel an invite is for. It doesn't require auth so
+// that the signup page can show it.
+func (h *ChannelHandler) PreviewInvite(w http.ResponseWriter, r *http.Request) {
+	invite, err := h.store.GetChannelInviteByToken(r.PathValue("token"))
+	if err != nil {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	channel, err := h.store.GetChannel(invite.ChannelID)
+	if err != nil {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	members, _ := h.store.GetChannelMembers(channel.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.InvitePreview{
+		Token:              invite.Token,
+		ChannelID:          channel.ID,
+		ChannelName:        channel.Name,
+		ChannelDescription: channel.Description,
+		MemberCount:        len(members),
+		ExpiresAt:          invite.ExpiresAt,
+		Valid:              invite.IsUsable() && channel.ArchivedAt == nil,
+	})
+}
+
+func (h *ChannelHandler) RedeemInvite(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	channel, ok := redeemInvite(w, h.store, h.hub, r.PathValue("token"), userID)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channel)
+}
+
+// redeemInvite joins the user to the invite's channel and announces it, writing an
+// error response and returning false if the invite can't be used.
+func redeemInvite(w http.ResponseWriter, s *store.Store, hub *Hub, token, userID string) (*models.Channel, bool) {
+	invite, err := s.GetChannelInviteByToken(token)
+	if err != nil {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	channel, err := s.GetChannel(invite.ChannelID)
+	if err != nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	if channel.ArchivedAt != nil {
+		http.Error(w, errChannelArchived, http.StatusForbidden)
+		return nil, false
+	}
+
+	_, joined, err := s.RedeemChannelInvite(token, userID)
+	if err == store.ErrInviteUnusable {
+		http.Error(w, "Invite has expired or been used up", http.StatusGone)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to redeem invite", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if joined && hub != nil {
+		user, _ := s.GetUserByID(userID)
+		hub.AnnounceMembership(channel, user, nil, true)
+	}
+	return channel, true
+}
@@ -266,6 +266,12 @@ func (h *CommandHandler) Execute(w http.ResponseWriter, r *http.Request) {
 		ctx.ChannelName = channel.Name
 	}
 
+	// Commands that post into the channel can't run in an archived one
+	if cmd.ResponseMode == "channel" && channel != nil && channel.ArchivedAt != nil {
+		http.Error(w, `{"error":"Channel is archived and read-only"}`, http.StatusForbidden)
+		return
+	}
+
 	// Execute HTTP request
 	result := h.executeHTTPRequest(cmd, ctx)
 
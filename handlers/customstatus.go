@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"smack-server/middleware"
+	"smack-server/models"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// SetCustomStatus sets the current user's status emoji and text, optionally
+// clearing itself at expires_at
+func (h *UserHandler) SetCustomStatus(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req models.CustomStatus
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Emoji = strings.TrimSpace(req.Emoji)
+	req.Text = strings.TrimSpace(req.Text)
+	if req.Emoji == "" && req.Text == "" {
+		http.Error(w, "Emoji or text is required", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(req.Text) > models.MaxCustomStatusLength {
+		http.Error(w, "Status text is too long", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(req.Emoji) > 32 {
+		http.Error(w, "Invalid emoji", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.SetCustomStatus(userID, &req); err != nil {
+		http.Error(w, "Failed to set custom status", http.StatusInternalServerError)
+		return
+	}
+
+	user := h.hub.BroadcastUserStatus(userID)
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user.ToResponse())
+}
+
+func (h *UserHandler) ClearCustomStatus(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	if err := h.store.ClearCustomStatus(userID); err != nil {
+		http.Error(w, "Failed to clear custom status", http.StatusInternalServerError)
+		return
+	}
+	h.hub.BroadcastUserStatus(userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartCustomStatusExpiry starts a goroutine that clears custom statuses once they expire
+func (h *UserHandler) StartCustomStatusExpiry() {
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			userIDs, err := h.store.ClearExpiredCustomStatuses()
+			if err != nil {
+				log.Printf("Failed to clear expired custom statuses: %v", err)
+				continue
+			}
+			for _, userID := range userIDs {
+				h.hub.BroadcastUserStatus(userID)
+			}
+		}
+	}()
+}
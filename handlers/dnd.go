@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"smack-server/middleware"
+	"smack-server/models"
+	"time"
+)
+
+// GetDND returns the current user's do-not-disturb schedule, snooze and whether
+// it's on right now
+func (h *UserHandler) GetDND(w http.ResponseWriter, r *http.Request) {
+	h.writeDNDStatus(w, middleware.GetUserID(r))
+}
+
+// UpdateDNDSchedule sets the current user's recurring do-not-disturb window
+func (h *UserHandler) UpdateDNDSchedule(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var schedule models.DNDSchedule
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := schedule.Validate(); err != nil {
+		http.Error(w, "Invalid schedule: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.SetDNDSchedule(userID, &schedule); err != nil {
+		http.Error(w, "Failed to update schedule", http.StatusInternalServerError)
+		return
+	}
+	h.dndChanged(userID)
+
+	h.writeDNDStatus(w, userID)
+}
+
+// SnoozeDND turns do not disturb on for the given number of hours
+func (h *UserHandler) SnoozeDND(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req models.SnoozeDNDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Hours < 1 || req.Hours > models.MaxDNDSnoozeHours {
+		http.Error(w, "Hours must be between 1 and 24", http.StatusBadRequest)
+		return
+	}
+
+	until := time.Now().Add(time.Duration(req.Hours) * time.Hour)
+	if err := h.store.SetDNDSnooze(userID, &until); err != nil {
+		http.Error(w, "Failed to snooze", http.StatusInternalServerError)
+		return
+	}
+	h.dndChanged(userID)
+
+	h.writeDNDStatus(w, userID)
+}
+
+// EndDNDSnooze ends a snooze early
+func (h *UserHandler) EndDNDSnooze(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	if err := h.store.SetDNDSnooze(userID, nil); err != nil {
+		http.Error(w, "Failed to end snooze", http.StatusInternalServerError)
+		return
+	}
+	h.dndChanged(userID)
+
+	h.writeDNDStatus(w, userID)
+}
+
+// dndChanged tells everyone whether the user now has do not disturb on and, if
+// it's off, delivers anything held back
+func (h *UserHandler) dndChanged(userID string) {
+	h.hub.BroadcastUserStatus(userID)
+	go h.hub.DeliverHeldNotifications(userID)
+}
+
+func (h *UserHandler) writeDNDStatus(w http.ResponseWriter, userID string) {
+	settings, err := h.store.GetDNDSettings(userID)
+	if err != nil {
+		http.Error(w, "Failed to load do not disturb settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.DNDStatus{
+		Active:      settings.ActiveAt(time.Now()),
+		Schedule:    settings.Schedule,
+		SnoozeUntil: settings.SnoozeUntil,
+	})
+}
@@ -39,8 +39,9 @@ func NewGitHandler(s *store.Store, appsDir string, hub *Hub) *GitHandler {
 	}
 }
 
-// authenticateRequest extracts Basic Auth credentials and validates JWT token
-func (h *GitHandler) authenticateRequest(w http.ResponseWriter, r *http.Request) (userID string, ok bool) {
+// authenticateRequest extracts Basic Auth credentials and validates the password,
+// which is either a JWT or a personal access token with the given scope
+func (h *GitHandler) authenticateRequest(w http.ResponseWriter, r *http.Request, scope string) (userID string, ok bool) {
 	_, password, hasAuth := r.BasicAuth()
 	if !hasAuth {
 		w.Header().Set("WWW-Authenticate", `Basic realm="Smack Git"`)
@@ -48,9 +49,22 @@ func (h *GitHandler) authenticateRequest(w http.ResponseWriter, r *http.Request)
 		return "", false
 	}
 
-	// Password is the JWT token
+	if strings.HasPrefix(password, models.AccessTokenPrefix) {
+		pat, err := h.store.AuthenticateAccessToken(password)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Smack Git"`)
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return "", false
+		}
+		if !pat.HasScope(scope) {
+			http.Error(w, "Access token is missing the "+scope+" scope", http.StatusForbidden)
+			return "", false
+		}
+		return pat.UserID, true
+	}
+
 	claims, err := middleware.ValidateToken(password)
-	if err != nil {
+	if err != nil || !h.store.IsSessionActive(claims.SessionID) {
 		w.Header().Set("WWW-Authenticate", `Basic realm="Smack Git"`)
 		http.Error(w, "Invalid token", http.StatusUnauthorized)
 		return "", false
@@ -96,7 +110,12 @@ func (h *GitHandler) InfoRefs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, ok := h.authenticateRequest(w, r)
+	scope := models.ScopeGitPull
+	if service == "git-receive-pack" {
+		scope = models.ScopeGitPush
+	}
+
+	userID, ok := h.authenticateRequest(w, r, scope)
 	if !ok {
 		return
 	}
@@ -147,7 +166,7 @@ func (h *GitHandler) UploadPack(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, ok := h.authenticateRequest(w, r)
+	userID, ok := h.authenticateRequest(w, r, models.ScopeGitPull)
 	if !ok {
 		return
 	}
@@ -211,7 +230,7 @@ func (h *GitHandler) ReceivePack(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, ok := h.authenticateRequest(w, r)
+	userID, ok := h.authenticateRequest(w, r, models.ScopeGitPush)
 	if !ok {
 		return
 	}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"smack-server/mailer"
+	"smack-server/middleware"
+	"smack-server/models"
+	"smack-server/store"
+	"smack-server/totp"
+	"testing"
+	"time"
+)
+
+const testPassword = "secret123"
+
+// newTestStore opens a fresh database with signing keys loaded
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	return openTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+}
+
+func openTestStore(t *testing.T, path string) *store.Store {
+	t.Helper()
+	s, err := store.New(path)
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	if err := LoadSigningKeys(s); err != nil {
+		t.Fatalf("LoadSigningKeys: %v", err)
+	}
+	return s
+}
+
+type authTest struct {
+	t      *testing.T
+	store  *store.Store
+	auth   *AuthHandler
+	dbPath string // for changing rows the store has no method for
+}
+
+func newAuthTest(t *testing.T) *authTest {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s := openTestStore(t, dbPath)
+	return &authTest{t: t, store: s, auth: NewAuthHandler(s, NewHub(s), mailer.New("", "", "", "", "")), dbPath: dbPath}
+}
+
+func (a *authTest) createUser(username string) *models.User {
+	a.t.Helper()
+	user, err := a.store.CreateUser(username, username, testPassword)
+	if err != nil {
+		a.t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+// enableTwoFactor turns on 2FA for a user, returning their TOTP secret and recovery codes
+func (a *authTest) enableTwoFactor(userID string) (string, []string) {
+	a.t.Helper()
+	secret, err := a.store.StartTOTPEnrollment(userID)
+	if err != nil {
+		a.t.Fatalf("StartTOTPEnrollment: %v", err)
+	}
+	code, _ := totp.CodeAt(secret, totp.Step(time.Now())-1)
+	recoveryCodes, err := a.store.ConfirmTOTPEnrollment(userID, code)
+	if err != nil {
+		a.t.Fatalf("ConfirmTOTPEnrollment: %v", err)
+	}
+	return secret, recoveryCodes
+}
+
+// call runs a handler with body as JSON, signed in as userID and sessionID if set
+func (a *authTest) call(handler http.HandlerFunc, method, path, userID, sessionID string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	ctx := context.Background()
+	if userID != "" {
+		ctx = middleware.SetUserID(ctx, userID)
+	}
+	if sessionID != "" {
+		ctx = middleware.SetSessionID(ctx, sessionID)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req.WithContext(ctx))
+	return rec
+}
+
+func (a *authTest) login(username, password string) *httptest.ResponseRecorder {
+	return a.call(a.auth.Login, http.MethodPost, "/api/auth/login", "", "", models.LoginRequest{Username: username, Password: password})
+}
+
+// challenge signs in with the right password and returns the 2FA challenge token
+func (a *authTest) challenge(username string) string {
+	a.t.Helper()
+	rec := a.login(username, testPassword)
+	if rec.Code != http.StatusOK {
+		a.t.Fatalf("login: status %d: %s", rec.Code, rec.Body)
+	}
+	var challenge models.LoginChallenge
+	if err := json.Unmarshal(rec.Body.Bytes(), &challenge); err != nil || challenge.ChallengeToken == "" {
+		a.t.Fatalf("login didn't return a challenge: %s", rec.Body)
+	}
+	return challenge.ChallengeToken
+}
+
+func (a *authTest) completeLogin(token, code string) *httptest.ResponseRecorder {
+	return a.call(a.auth.CompleteLogin, http.MethodPost, "/api/auth/login/2fa", "", "", models.LoginChallengeRequest{ChallengeToken: token, Code: code})
+}
@@ -79,7 +79,7 @@ func (h *MessageHandler) ensureBotUser() {
 		}
 	}
 	h.botID = bot.ID
-	h.store.UpdateUserStatus(h.botID, "online")
+	h.store.SetUserPresence(h.botID, "online")
 }
 
 func (h *MessageHandler) GetChannelMessages(w http.ResponseWriter, r *http.Request) {
@@ -132,6 +132,7 @@ func (h *MessageHandler) GetChannelMessages(w http.ResponseWriter, r *http.Reque
 	if messages == nil {
 		messages = []models.MessageWithUser{}
 	}
+	collapseBlockedMessages(h.store, middleware.GetUserID(r), messages)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(messages)
@@ -151,6 +152,11 @@ func (h *MessageHandler) Send(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.store.IsChannelArchived(req.ChannelID) {
+		http.Error(w, errChannelArchived, http.StatusForbidden)
+		return
+	}
+
 	msg, err := h.store.CreateMessage(req.ChannelID, userID, req.Content, req.ThreadID)
 	if err != nil {
 		http.Error(w, "Failed to send message", http.StatusInternalServerError)
@@ -169,6 +175,7 @@ func (h *MessageHandler) Send(w http.ResponseWriter, r *http.Request) {
 			Type:    models.WSTypeNewMessage,
 			Payload: msgWithUser,
 		})
+		go h.hub.SendNotifications(msgWithUser)
 	}
 
 	// Check if this is a bot DM channel
@@ -199,6 +206,11 @@ func (h *MessageHandler) sendBotResponse(channelID, userMessage string, threadID
 	delay := time.Duration(1000+rand.Intn(2000)) * time.Millisecond
 	time.Sleep(delay)
 
+	// Bots stay quiet in archived channels
+	if h.store.IsChannelArchived(channelID) {
+		return
+	}
+
 	// Generate response
 	var response string
 	lowerMsg := strings.ToLower(userMessage)
@@ -289,6 +301,10 @@ func (h *MessageHandler) findMentionedBot(content string) *models.Bot {
 // sendMentionedBotResponse handles bot responses when mentioned in a channel
 // isFollowUp indicates this is an auto-follow-up response (bot may choose to give brief/no response if not relevant)
 func (h *MessageHandler) sendMentionedBotResponse(channelID, userMessage string, threadID *string, bot *models.Bot, isFollowUp bool) {
+	if h.store.IsChannelArchived(channelID) {
+		return
+	}
+
 	// Get the AI client for this provider
 	client, ok := h.aiClients[bot.Provider]
 	if !ok {
@@ -431,6 +447,10 @@ func (h *MessageHandler) sendMentionedBotResponse(channelID, userMessage string,
 }
 
 func (h *MessageHandler) sendAIBotResponse(channelID, userMessage string, threadID *string) {
+	if h.store.IsChannelArchived(channelID) {
+		return
+	}
+
 	// Get the bot for this channel
 	bot, err := h.store.GetBotForChannel(channelID)
 	if err != nil {
@@ -571,6 +591,7 @@ func (h *MessageHandler) GetThread(w http.ResponseWriter, r *http.Request) {
 	if messages == nil {
 		messages = []models.MessageWithUser{}
 	}
+	collapseBlockedMessages(h.store, middleware.GetUserID(r), messages)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(messages)
@@ -605,6 +626,11 @@ func (h *MessageHandler) Reply(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.store.IsChannelArchived(parent.ChannelID) {
+		http.Error(w, errChannelArchived, http.StatusForbidden)
+		return
+	}
+
 	msg, err := h.store.CreateMessage(parent.ChannelID, userID, req.Content, &threadID)
 	if err != nil {
 		http.Error(w, "Failed to send reply", http.StatusInternalServerError)
@@ -623,6 +649,7 @@ func (h *MessageHandler) Reply(w http.ResponseWriter, r *http.Request) {
 			Type:    models.WSTypeNewMessage,
 			Payload: msgWithUser,
 		})
+		go h.hub.SendNotifications(msgWithUser)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -630,6 +657,82 @@ func (h *MessageHandler) Reply(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(msgWithUser)
 }
 
+func (h *MessageHandler) GetThreadNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	messageID := r.PathValue("id")
+
+	if messageID == "" {
+		http.Error(w, "Message ID required", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := h.store.GetMessage(messageID)
+	if err != nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	if isMember, err := h.store.IsChannelMember(msg.ChannelID, userID); err != nil || !isMember {
+		http.Error(w, "Not a member of this channel", http.StatusForbidden)
+		return
+	}
+
+	level, err := h.store.GetThreadNotificationLevel(userID, messageID)
+	if err != nil {
+		http.Error(w, "Failed to fetch notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.ThreadNotificationSettings{MessageID: messageID, Level: level})
+}
+
+// UpdateThreadNotifications overrides the channel's notification level for one thread
+func (h *MessageHandler) UpdateThreadNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	messageID := r.PathValue("id")
+
+	if messageID == "" {
+		http.Error(w, "Message ID required", http.StatusBadRequest)
+		return
+	}
+
+	var req models.UpdateThreadNotificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Level != "" && !models.IsValidNotificationLevel(req.Level) {
+		http.Error(w, "Level must be one of: all, mentions, nothing", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := h.store.GetMessage(messageID)
+	if err != nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	if isMember, err := h.store.IsChannelMember(msg.ChannelID, userID); err != nil || !isMember {
+		http.Error(w, "Not a member of this channel", http.StatusForbidden)
+		return
+	}
+
+	if msg.ThreadID != nil {
+		http.Error(w, "Notification overrides apply to the thread's parent message", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.SetThreadNotificationLevel(userID, messageID, req.Level); err != nil {
+		http.Error(w, "Failed to update notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.ThreadNotificationSettings{MessageID: messageID, Level: req.Level})
+}
+
 func (h *MessageHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 	messageID := r.PathValue("id")
@@ -0,0 +1,457 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"smack-server/models"
+	"smack-server/oidc"
+	"smack-server/store"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcLoginTTL is how long someone has to finish signing in at the provider
+const oidcLoginTTL = 10 * time.Minute
+
+// OIDCHandler signs users in through an OpenID Connect provider using the
+// authorization-code flow with PKCE. Tokens are issued through AuthHandler, so
+// 2FA and sessions work the same as for password logins.
+type OIDCHandler struct {
+	store *store.Store
+	auth  *AuthHandler
+
+	mu       sync.Mutex
+	provider *oidc.Provider // discovered provider, reused while the issuer is unchanged
+}
+
+func NewOIDCHandler(s *store.Store, auth *AuthHandler) *OIDCHandler {
+	return &OIDCHandler{store: s, auth: auth}
+}
+
+// getProvider returns the discovered provider for an issuer, discovering it on first use
+func (h *OIDCHandler) getProvider(ctx context.Context, issuer string) (*oidc.Provider, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.provider != nil && h.provider.Issuer == strings.TrimSuffix(issuer, "/") {
+		return h.provider, nil
+	}
+
+	provider, err := oidc.Discover(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	h.provider = provider
+	return provider, nil
+}
+
+// enabledSettings loads the SSO settings, writing an error response and returning
+// false if SSO isn't turned on
+func (h *OIDCHandler) enabledSettings(w http.ResponseWriter) (*models.OIDCSettings, bool) {
+	settings, err := h.store.GetOIDCSettings()
+	if err != nil {
+		http.Error(w, "Failed to load single sign-on settings", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !settings.Enabled {
+		http.Error(w, "Single sign-on is not enabled", http.StatusNotFound)
+		return nil, false
+	}
+	return settings, true
+}
+
+// Methods tells clients which login methods to offer
+func (h *OIDCHandler) Methods(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.store.GetOIDCSettings()
+	if err != nil {
+		http.Error(w, "Failed to load single sign-on settings", http.StatusInternalServerError)
+		return
+	}
+
+	methods := models.AuthMethods{PasswordLogin: !settings.Enabled || !settings.DisablePasswordLogin}
+	if settings.Enabled {
+		label := settings.ButtonLabel
+		if label == "" {
+			label = "Sign in with SSO"
+		}
+		methods.OIDC = &models.OIDCLoginInfo{ButtonLabel: label, LoginURL: "/api/auth/oidc/login"}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(methods)
+}
+
+// Login redirects to the provider to start signing in
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	settings, ok := h.enabledSettings(w)
+	if !ok {
+		return
+	}
+
+	provider, err := h.getProvider(r.Context(), settings.IssuerURL)
+	if err != nil {
+		log.Printf("OIDC discovery failed: %v", err)
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	state, err := oidc.RandomString(24)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := oidc.RandomString(24)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.store.SaveOIDCLoginState(&models.OIDCLoginState{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		DeviceName:   r.URL.Query().Get("device_name"),
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	})
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, provider.AuthCodeURL(settings.ClientID, settings.RedirectURL, settings.Scopes, state, nonce, challenge), http.StatusFound)
+}
+
+// Callback handles the provider's redirect: it exchanges the code, verifies the
+// ID token, finds or creates the user and signs them in
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if errCode := query.Get("error"); errCode != "" {
+		msg := errCode
+		if desc := query.Get("error_description"); desc != "" {
+			msg += ": " + desc
+		}
+		http.Error(w, "Sign-in was not completed ("+msg+")", http.StatusUnauthorized)
+		return
+	}
+
+	settings, ok := h.enabledSettings(w)
+	if !ok {
+		return
+	}
+
+	login, err := h.store.ConsumeOIDCLoginState(query.Get("state"))
+	if err != nil {
+		http.Error(w, "Login is invalid or has expired", http.StatusBadRequest)
+		return
+	}
+
+	provider, err := h.getProvider(r.Context(), settings.IssuerURL)
+	if err != nil {
+		log.Printf("OIDC discovery failed: %v", err)
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(r.Context(), settings.ClientID, settings.ClientSecret, settings.RedirectURL, query.Get("code"), login.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC token exchange failed: %v", err)
+		http.Error(w, "Failed to complete sign-in with the identity provider", http.StatusBadGateway)
+		return
+	}
+
+	claims, err := provider.Verify(r.Context(), rawIDToken, settings.ClientID, login.Nonce)
+	if err != nil {
+		log.Printf("OIDC ID token rejected: %v", err)
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		return
+	}
+
+	user, ok := h.findOrCreateUser(w, settings, provider.Issuer, claims, login.DeviceName)
+	if !ok {
+		return
+	}
+
+	if user.IsDeactivated() {
+		http.Error(w, "Account has been deactivated", http.StatusForbidden)
+		return
+	}
+
+	if err := h.store.LinkUserIdentity(user.ID, provider.Issuer, claims.Subject, claims.Email); err != nil {
+		http.Error(w, "Failed to link account", http.StatusInternalServerError)
+		return
+	}
+
+	h.store.SetUserPresence(user.ID, "online")
+
+	h.auth.signIn(w, r, user, login.DeviceName)
+}
+
+// findOrCreateUser maps the provider account to a user: first by a linked identity,
+// then by verified email if allowed, and finally by creating a new account if allowed.
+// An account whose email was never verified isn't linked straight away, since anyone
+// could have registered it with somebody else's address; its owner has to confirm
+// their password first.
+func (h *OIDCHandler) findOrCreateUser(w http.ResponseWriter, settings *models.OIDCSettings, issuer string, claims *oidc.Claims, deviceName string) (*models.User, bool) {
+	user, err := h.store.GetUserByIdentity(issuer, claims.Subject)
+	if err == nil {
+		return user, true
+	}
+	if err != sql.ErrNoRows {
+		http.Error(w, "Failed to look up account", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if settings.LinkByEmail && claims.Email != "" && claims.EmailVerified {
+		user, err := h.store.GetUserByEmail(claims.Email)
+		if err == nil && user.EmailVerifiedAt != nil {
+			return user, true
+		}
+		if err == nil {
+			h.requireLinkConfirmation(w, user, issuer, claims, deviceName)
+			return nil, false
+		}
+		if err != sql.ErrNoRows {
+			http.Error(w, "Failed to look up account", http.StatusInternalServerError)
+			return nil, false
+		}
+	}
+
+	if !settings.AutoCreateUsers {
+		http.Error(w, "No account is linked to this identity", http.StatusForbidden)
+		return nil, false
+	}
+
+	username, err := h.availableUsername(claims)
+	if err != nil {
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	displayName := claims.Name
+	if displayName == "" {
+		displayName = username
+	}
+
+	// The account gets a random password nobody knows; it can be set later with a reset
+	password, err := oidc.RandomString(32)
+	if err != nil {
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	user, err = h.store.CreateUser(username, displayName, password)
+	if err != nil {
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if claims.Email != "" && claims.EmailVerified {
+		if err := h.store.SetUserEmail(user.ID, claims.Email); err != nil {
+			log.Printf("Failed to set email for user %s: %v", user.ID, err)
+		} else if err := h.store.MarkEmailVerified(user.ID); err != nil {
+			log.Printf("Failed to mark email verified for user %s: %v", user.ID, err)
+		}
+		user.Email = claims.Email
+	}
+
+	if err := h.store.OnboardUser(user.ID); err != nil {
+		log.Printf("Failed to onboard user %s: %v", user.ID, err)
+	}
+
+	return user, true
+}
+
+// requireLinkConfirmation saves the provider account as a pending link to user and
+// tells the client to confirm it with the account's password
+func (h *OIDCHandler) requireLinkConfirmation(w http.ResponseWriter, user *models.User, issuer string, claims *oidc.Claims, deviceName string) {
+	if user.IsBot() || user.IsDeleted() {
+		http.Error(w, "No account is linked to this identity", http.StatusForbidden)
+		return
+	}
+
+	link := &models.OIDCPendingLink{
+		UserID:     user.ID,
+		Issuer:     issuer,
+		Subject:    claims.Subject,
+		Email:      claims.Email,
+		DeviceName: deviceName,
+		ExpiresAt:  time.Now().Add(oidcLoginTTL),
+	}
+	token, err := h.store.CreateOIDCPendingLink(link)
+	if err != nil {
+		http.Error(w, "Failed to start linking account", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(models.OIDCLinkRequiredResponse{
+		LinkRequired: true,
+		LinkToken:    token,
+		Username:     user.Username,
+		ExpiresAt:    link.ExpiresAt,
+	})
+}
+
+// ConfirmLink links a provider account to an existing account once its password is
+// confirmed, then signs in. Wrong passwords count towards the login lockout.
+func (h *OIDCHandler) ConfirmLink(w http.ResponseWriter, r *http.Request) {
+	var req models.OIDCLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	link, err := h.store.ConsumeOIDCPendingLink(req.LinkToken)
+	if err != nil {
+		http.Error(w, "Link is invalid or has expired; sign in with single sign-on again", http.StatusBadRequest)
+		return
+	}
+	user, err := h.store.GetUserByID(link.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if h.auth.rejectThrottledLogin(w, r, user, user.Username) {
+		return
+	}
+	if !h.store.ValidatePassword(user, req.Password) {
+		h.auth.recordLoginFailure(r, user, user.Username)
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	if user.IsDeactivated() {
+		http.Error(w, "Account has been deactivated", http.StatusForbidden)
+		return
+	}
+
+	if err := h.store.LinkUserIdentity(user.ID, link.Issuer, link.Subject, link.Email); err != nil {
+		http.Error(w, "Failed to link account", http.StatusInternalServerError)
+		return
+	}
+	// The provider vouched for the address and the owner proved the account is theirs
+	if strings.EqualFold(user.Email, link.Email) {
+		if err := h.store.MarkEmailVerified(user.ID); err != nil {
+			log.Printf("Failed to mark email verified for user %s: %v", user.ID, err)
+		}
+	}
+
+	h.store.SetUserPresence(user.ID, "online")
+
+	h.auth.signIn(w, r, user, link.DeviceName)
+}
+
+// availableUsername derives a username from the ID token, adding a number if it's taken
+func (h *OIDCHandler) availableUsername(claims *oidc.Claims) (string, error) {
+	base := sanitizeUsername(claims.PreferredUsername)
+	if base == "" {
+		local, _, _ := strings.Cut(claims.Email, "@")
+		base = sanitizeUsername(local)
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	for i := 1; i <= 100; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-%d", base, i)
+		}
+		if existing, _ := h.store.GetUserByUsername(candidate); existing == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no username available for %q", base)
+}
+
+// sanitizeUsername lowercases a name and keeps only letters, digits, dots, dashes and underscores
+func sanitizeUsername(name string) string {
+	name, _, _ = strings.Cut(name, "@")
+
+	var b strings.Builder
+	for _, c := range strings.ToLower(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+			b.WriteRune(c)
+		}
+	}
+	return strings.Trim(b.String(), ".-_")
+}
+
+// GetSettings returns the SSO configuration without the client secret
+func (h *OIDCHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.store.GetOIDCSettings()
+	if err != nil {
+		http.Error(w, "Failed to load single sign-on settings", http.StatusInternalServerError)
+		return
+	}
+
+	writeOIDCSettings(w, settings)
+}
+
+// UpdateSettings replaces the SSO configuration. An empty client secret keeps the
+// current one. Enabling SSO checks that the issuer can be discovered first.
+func (h *OIDCHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var settings models.OIDCSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	current, err := h.store.GetOIDCSettings()
+	if err != nil {
+		http.Error(w, "Failed to load single sign-on settings", http.StatusInternalServerError)
+		return
+	}
+	if settings.ClientSecret == "" {
+		settings.ClientSecret = current.ClientSecret
+	}
+	if len(settings.Scopes) == 0 {
+		settings.Scopes = models.DefaultOIDCScopes
+	}
+	settings.HasClientSecret = false
+
+	if settings.Enabled {
+		if settings.IssuerURL == "" || settings.ClientID == "" || settings.RedirectURL == "" {
+			http.Error(w, "Issuer URL, client ID and redirect URL are required", http.StatusBadRequest)
+			return
+		}
+		if u, err := url.Parse(settings.RedirectURL); err != nil || u.Host == "" {
+			http.Error(w, "Redirect URL must be an absolute URL", http.StatusBadRequest)
+			return
+		}
+		if _, err := h.getProvider(r.Context(), settings.IssuerURL); err != nil {
+			http.Error(w, fmt.Sprintf("Could not reach the identity provider: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.store.SetOIDCSettings(&settings); err != nil {
+		http.Error(w, "Failed to update", http.StatusInternalServerError)
+		return
+	}
+
+	writeOIDCSettings(w, &settings)
+}
+
+func writeOIDCSettings(w http.ResponseWriter, settings *models.OIDCSettings) {
+	settings.HasClientSecret = settings.ClientSecret != ""
+	settings.ClientSecret = ""
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"smack-server/mailer"
+	"smack-server/models"
+	"smack-server/oidc/oidctest"
+	"smack-server/store"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type oidcTest struct {
+	t       *testing.T
+	store   *store.Store
+	handler *OIDCHandler
+	issuer  *oidctest.Issuer
+}
+
+func newOIDCTest(t *testing.T, autoCreate, linkByEmail bool) *oidcTest {
+	t.Helper()
+	s := newTestStore(t)
+
+	issuer := oidctest.NewIssuer("smack")
+	t.Cleanup(issuer.Close)
+
+	err := s.SetOIDCSettings(&models.OIDCSettings{
+		Enabled:         true,
+		IssuerURL:       issuer.URL,
+		ClientID:        "smack",
+		RedirectURL:     "https://smack.example.com/api/auth/oidc/callback",
+		Scopes:          models.DefaultOIDCScopes,
+		AutoCreateUsers: autoCreate,
+		LinkByEmail:     linkByEmail,
+	})
+	if err != nil {
+		t.Fatalf("SetOIDCSettings: %v", err)
+	}
+
+	auth := NewAuthHandler(s, NewHub(s), mailer.New("", "", "", "", ""))
+	return &oidcTest{t: t, store: s, handler: NewOIDCHandler(s, auth), issuer: issuer}
+}
+
+// signIn goes through Login, the provider and Callback for an account with claims
+func (o *oidcTest) signIn(claims jwt.MapClaims) *httptest.ResponseRecorder {
+	o.t.Helper()
+
+	rec := httptest.NewRecorder()
+	o.handler.Login(rec, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		o.t.Fatalf("Login: status %d: %s", rec.Code, rec.Body)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		o.t.Fatalf("Login redirect: %v", err)
+	}
+	params := location.Query()
+
+	claims["nonce"] = params.Get("nonce")
+	code := o.issuer.Authorize(claims, params.Get("code_challenge"))
+
+	rec = httptest.NewRecorder()
+	callback := "/api/auth/oidc/callback?" + url.Values{"state": {params.Get("state")}, "code": {code}}.Encode()
+	o.handler.Callback(rec, httptest.NewRequest(http.MethodGet, callback, nil))
+	return rec
+}
+
+func (o *oidcTest) confirmLink(token, password string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(models.OIDCLinkRequest{LinkToken: token, Password: password})
+	rec := httptest.NewRecorder()
+	o.handler.ConfirmLink(rec, httptest.NewRequest(http.MethodPost, "/api/auth/oidc/link", bytes.NewReader(body)))
+	return rec
+}
+
+func signedInUser(t *testing.T, rec *httptest.ResponseRecorder) models.UserResponse {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected to be signed in, got status %d: %s", rec.Code, rec.Body)
+	}
+	var resp models.AuthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding auth response: %v", err)
+	}
+	if resp.Token == "" || resp.RefreshToken == "" {
+		t.Fatalf("auth response is missing tokens: %s", rec.Body)
+	}
+	return resp.User
+}
+
+func TestOIDCCallbackCreatesUser(t *testing.T) {
+	o := newOIDCTest(t, true, false)
+
+	claims := jwt.MapClaims{"sub": "sub-ada", "email": "ada@example.com", "email_verified": true, "name": "Ada Lovelace", "preferred_username": "Ada"}
+	user := signedInUser(t, o.signIn(claims))
+	if user.Username != "ada" || user.DisplayName != "Ada Lovelace" {
+		t.Errorf("created user %q (%q), want ada (Ada Lovelace)", user.Username, user.DisplayName)
+	}
+
+	stored, err := o.store.GetUserByIdentity(o.issuer.URL, "sub-ada")
+	if err != nil || stored.ID != user.ID {
+		t.Fatalf("identity isn't linked to the new user: %v", err)
+	}
+	if stored.Email != "ada@example.com" || stored.EmailVerifiedAt == nil {
+		t.Errorf("email = %q, verified %v; want the provider's verified email", stored.Email, stored.EmailVerifiedAt)
+	}
+
+	// Signing in again finds the same account through the linked identity
+	again := signedInUser(t, o.signIn(jwt.MapClaims{"sub": "sub-ada", "preferred_username": "Ada"}))
+	if again.ID != user.ID {
+		t.Errorf("second sign-in got user %s, want %s", again.ID, user.ID)
+	}
+}
+
+func TestOIDCCallbackWithoutAutoCreate(t *testing.T) {
+	o := newOIDCTest(t, false, false)
+
+	rec := o.signIn(jwt.MapClaims{"sub": "sub-nobody", "email": "nobody@example.com", "email_verified": true})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestOIDCCallbackLinksVerifiedEmail(t *testing.T) {
+	o := newOIDCTest(t, true, true)
+
+	existing, err := o.store.CreateUser("grace", "Grace", "secret123")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	o.store.SetUserEmail(existing.ID, "grace@example.com")
+	o.store.MarkEmailVerified(existing.ID)
+
+	user := signedInUser(t, o.signIn(jwt.MapClaims{"sub": "sub-grace", "email": "Grace@example.com", "email_verified": true}))
+	if user.ID != existing.ID {
+		t.Fatalf("signed in as %s, want the existing account %s", user.ID, existing.ID)
+	}
+	if linked, err := o.store.GetUserByIdentity(o.issuer.URL, "sub-grace"); err != nil || linked.ID != existing.ID {
+		t.Errorf("identity isn't linked to the existing account: %v", err)
+	}
+}
+
+func TestOIDCCallbackConfirmsUnverifiedEmailWithPassword(t *testing.T) {
+	o := newOIDCTest(t, true, true)
+
+	existing, err := o.store.CreateUser("grace", "Grace", "secret123")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	o.store.SetUserEmail(existing.ID, "grace@example.com")
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{"sub": "sub-grace", "email": "grace@example.com", "email_verified": true}
+	}
+
+	linkToken := func() string {
+		rec := o.signIn(claims())
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+		}
+		var resp models.OIDCLinkRequiredResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if !resp.LinkRequired || resp.LinkToken == "" || resp.Username != "grace" {
+			t.Fatalf("unexpected link response: %s", rec.Body)
+		}
+		return resp.LinkToken
+	}
+
+	// The account isn't linked or signed in to until its password is confirmed
+	token := linkToken()
+	if _, err := o.store.GetUserByIdentity(o.issuer.URL, "sub-grace"); err == nil {
+		t.Fatal("identity was linked before the password was confirmed")
+	}
+	if rec := o.confirmLink(token, "wrong-password"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := o.confirmLink(token, "secret123"); rec.Code != http.StatusBadRequest {
+		t.Errorf("reused link token: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	user := signedInUser(t, o.confirmLink(linkToken(), "secret123"))
+	if user.ID != existing.ID {
+		t.Fatalf("signed in as %s, want %s", user.ID, existing.ID)
+	}
+	stored, _ := o.store.GetUserByID(existing.ID)
+	if stored.EmailVerifiedAt == nil {
+		t.Error("email isn't verified after confirming the link")
+	}
+
+	// From now on the identity signs straight in
+	if again := signedInUser(t, o.signIn(claims())); again.ID != existing.ID {
+		t.Errorf("later sign-in got user %s, want %s", again.ID, existing.ID)
+	}
+}
+
+func TestOIDCCallbackIgnoresUnverifiedProviderEmail(t *testing.T) {
+	o := newOIDCTest(t, true, true)
+
+	existing, err := o.store.CreateUser("grace", "Grace", "secret123")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	o.store.SetUserEmail(existing.ID, "grace@example.com")
+	o.store.MarkEmailVerified(existing.ID)
+
+	user := signedInUser(t, o.signIn(jwt.MapClaims{"sub": "sub-mallory", "email": "grace@example.com", "email_verified": false}))
+	if user.ID == existing.ID {
+		t.Fatal("linked to an account through an email the provider hasn't verified")
+	}
+	if created, _ := o.store.GetUserByID(user.ID); created.Email != "" {
+		t.Errorf("new account took the unverified email %q", created.Email)
+	}
+}
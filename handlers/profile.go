@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"smack-server/middleware"
+	"smack-server/models"
+	"strings"
+	"time"
+)
+
+// UpdateMyProfile edits the current user's display name, title, pronouns, phone,
+// timezone and custom profile fields
+func (h *UserHandler) UpdateMyProfile(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req models.UpdateUserProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	for _, value := range []*string{req.DisplayName, req.Title, req.Pronouns, req.Phone, req.Timezone} {
+		if value == nil {
+			continue
+		}
+		*value = strings.TrimSpace(*value)
+		if len([]rune(*value)) > models.MaxProfileFieldLength {
+			http.Error(w, "Profile values can't be longer than 200 characters", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.DisplayName != nil && *req.DisplayName == "" {
+		http.Error(w, "Display name can't be empty", http.StatusBadRequest)
+		return
+	}
+	if req.Timezone != nil && *req.Timezone != "" {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil {
+			http.Error(w, "Unknown timezone", http.StatusBadRequest)
+			return
+		}
+	}
+
+	fields := make(map[string]*models.ProfileField)
+	for fieldID, value := range req.Fields {
+		field, err := h.store.GetProfileField(fieldID)
+		if err != nil {
+			http.Error(w, "Unknown profile field: "+fieldID, http.StatusBadRequest)
+			return
+		}
+		if err := field.ValidateValue(strings.TrimSpace(value)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fields[fieldID] = field
+	}
+
+	if req.DisplayName != nil {
+		if err := h.store.UpdateUserDisplayName(userID, *req.DisplayName); err != nil {
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := h.store.UpdateUserProfile(userID, req.Title, req.Pronouns, req.Phone, req.Timezone); err != nil {
+		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+		return
+	}
+	for fieldID, value := range req.Fields {
+		if err := h.store.SetUserProfileValue(userID, fieldID, strings.TrimSpace(value)); err != nil {
+			http.Error(w, "Failed to update "+fields[fieldID].Name, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	user, err := h.store.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	user.DoNotDisturb = h.store.IsDNDActive(user.ID)
+	h.loadProfileFields(user, user)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user.ToPrivateResponse())
+}
+
+// ListProfileFields returns the custom profile fields users can fill in
+func (h *UserHandler) ListProfileFields(w http.ResponseWriter, r *http.Request) {
+	fields, err := h.store.GetProfileFields()
+	if err != nil {
+		http.Error(w, "Failed to fetch profile fields", http.StatusInternalServerError)
+		return
+	}
+	if fields == nil {
+		fields = []models.ProfileField{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fields)
+}
+
+// loadProfileFields fills in the custom field values of user that viewer is allowed
+// to see. Values visible only to admins are shown to admins and the user themselves.
+func (h *UserHandler) loadProfileFields(user, viewer *models.User) {
+	values, err := h.store.GetUserProfileValues(user.ID)
+	if err != nil {
+		return
+	}
+
+	seesAll := viewer != nil && (viewer.ID == user.ID || viewer.IsAdmin())
+	for _, v := range values {
+		if v.Visibility == models.ProfileFieldVisibilityEveryone || seesAll {
+			user.ProfileFields = append(user.ProfileFields, v)
+		}
+	}
+}
+
+func (h *AdminHandler) CreateProfileField(w http.ResponseWriter, r *http.Request) {
+	var req models.ProfileFieldRequest
+	if !decodeProfileFieldRequest(w, r, &req) {
+		return
+	}
+
+	field, err := h.store.CreateProfileField(&req)
+	if err != nil {
+		http.Error(w, "Failed to create profile field", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(field)
+}
+
+func (h *AdminHandler) UpdateProfileField(w http.ResponseWriter, r *http.Request) {
+	fieldID := r.PathValue("id")
+
+	if _, err := h.store.GetProfileField(fieldID); err != nil {
+		http.Error(w, "Profile field not found", http.StatusNotFound)
+		return
+	}
+
+	var req models.ProfileFieldRequest
+	if !decodeProfileFieldRequest(w, r, &req) {
+		return
+	}
+
+	if err := h.store.UpdateProfileField(fieldID, &req); err != nil {
+		http.Error(w, "Failed to update profile field", http.StatusInternalServerError)
+		return
+	}
+
+	field, err := h.store.GetProfileField(fieldID)
+	if err != nil {
+		http.Error(w, "Profile field not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(field)
+}
+
+// DeleteProfileField removes a custom field and everyone's values for it
+func (h *AdminHandler) DeleteProfileField(w http.ResponseWriter, r *http.Request) {
+	fieldID := r.PathValue("id")
+
+	if _, err := h.store.GetProfileField(fieldID); err != nil {
+		http.Error(w, "Profile field not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.store.DeleteProfileField(fieldID); err != nil {
+		http.Error(w, "Failed to delete profile field", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeProfileFieldRequest(w http.ResponseWriter, r *http.Request, req *models.ProfileFieldRequest) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Field name is required", http.StatusBadRequest)
+		return false
+	}
+	if req.Type == "" {
+		req.Type = models.ProfileFieldText
+	}
+	if !models.IsValidProfileFieldType(req.Type) {
+		http.Error(w, "Type must be text, link, date or select", http.StatusBadRequest)
+		return false
+	}
+	if req.Visibility == "" {
+		req.Visibility = models.ProfileFieldVisibilityEveryone
+	}
+	if req.Visibility != models.ProfileFieldVisibilityEveryone && req.Visibility != models.ProfileFieldVisibilityAdmins {
+		http.Error(w, "Visibility must be everyone or admins", http.StatusBadRequest)
+		return false
+	}
+	if req.Type == models.ProfileFieldSelect && len(req.Options) == 0 {
+		http.Error(w, "Select fields need at least one option", http.StatusBadRequest)
+		return false
+	}
+	if req.Type != models.ProfileFieldSelect {
+		req.Options = nil
+	}
+	return true
+}
@@ -38,6 +38,11 @@ func (h *ReactionHandler) Add(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.store.IsChannelArchived(msg.ChannelID) {
+		http.Error(w, errChannelArchived, http.StatusForbidden)
+		return
+	}
+
 	// Add the reaction
 	reaction, err := h.store.AddReaction(req.MessageID, userID, req.Emoji)
 	if err != nil {
@@ -87,6 +92,11 @@ func (h *ReactionHandler) Remove(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.store.IsChannelArchived(msg.ChannelID) {
+		http.Error(w, errChannelArchived, http.StatusForbidden)
+		return
+	}
+
 	// Remove the reaction
 	err = h.store.RemoveReaction(req.MessageID, userID, req.Emoji)
 	if err != nil {
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"smack-server/middleware"
+	"smack-server/models"
+	"strings"
+	"time"
+)
+
+func (h *AdminHandler) GetRegistrationSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.store.GetRegistrationSettings()
+	if err != nil {
+		http.Error(w, "Failed to load registration settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// UpdateRegistrationSettings sets who can register: anyone, people with an invite,
+// people with an email address at an allowed domain, or nobody
+func (h *AdminHandler) UpdateRegistrationSettings(w http.ResponseWriter, r *http.Request) {
+	var settings models.RegistrationSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !models.IsValidRegistrationMode(settings.Mode) {
+		http.Error(w, "Mode must be open, invite, domain or closed", http.StatusBadRequest)
+		return
+	}
+
+	domains := []string{}
+	for _, domain := range settings.AllowedDomains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain == "" {
+			continue
+		}
+		if !strings.Contains(domain, ".") || strings.ContainsAny(domain, "@/ ") {
+			http.Error(w, "Invalid domain: "+domain, http.StatusBadRequest)
+			return
+		}
+		domains = append(domains, domain)
+	}
+	settings.AllowedDomains = domains
+
+	if settings.Mode == models.RegistrationModeDomain && len(domains) == 0 {
+		http.Error(w, "At least one allowed domain is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.SetRegistrationSettings(&settings); err != nil {
+		http.Error(w, "Failed to update", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// ListRegistrationInvites returns every invite code with who registered with it
+func (h *AdminHandler) ListRegistrationInvites(w http.ResponseWriter, r *http.Request) {
+	invites, err := h.store.GetRegistrationInvites()
+	if err != nil {
+		http.Error(w, "Failed to fetch invites", http.StatusInternalServerError)
+		return
+	}
+	if invites == nil {
+		invites = []models.RegistrationInviteWithUses{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+func (h *AdminHandler) CreateRegistrationInvite(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req models.CreateRegistrationInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	role := req.Role
+	if role == "" {
+		role = models.ServerRoleMember
+	}
+	if role != models.ServerRoleMember && role != models.ServerRoleGuest {
+		http.Error(w, "Role must be member or guest", http.StatusBadRequest)
+		return
+	}
+	if req.MaxUses != nil && *req.MaxUses < 1 {
+		http.Error(w, "max_uses must be at least 1", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	invite, err := h.store.CreateRegistrationInvite(userID, req.Note, role, req.MaxUses, req.ExpiresAt)
+	if err != nil {
+		http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invite)
+}
+
+func (h *AdminHandler) RevokeRegistrationInvite(w http.ResponseWriter, r *http.Request) {
+	inviteID := r.PathValue("id")
+
+	if _, err := h.store.GetRegistrationInvite(inviteID); err != nil {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.store.RevokeRegistrationInvite(inviteID); err != nil {
+		http.Error(w, "Failed to revoke invite", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
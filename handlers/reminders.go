@@ -33,8 +33,12 @@ func (h *ReminderHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse the remind_at time
-	remindAt, err := parseRemindTime(req.RemindAt)
+	// Parse the remind_at time; times without a zone are in the user's timezone
+	loc := time.UTC
+	if user, err := h.store.GetUserByID(userID); err == nil {
+		loc = user.Location()
+	}
+	remindAt, err := parseRemindTime(req.RemindAt, loc)
 	if err != nil {
 		http.Error(w, "Invalid time format: "+err.Error(), http.StatusBadRequest)
 		return
@@ -101,10 +105,13 @@ func (h *ReminderHandler) StartReminderChecker() {
 
 func (h *ReminderHandler) checkAndSendReminders() {
 	reminders, err := h.store.GetDueReminders()
-	if err != nil {
+	if err != nil || len(reminders) == 0 {
 		return
 	}
 
+	// Reminders due for someone with do not disturb on are held until it ends
+	dndUserIDs, _ := h.store.GetDNDUserIDs()
+
 	for _, reminder := range reminders {
 		// Get or create Smackbot DM channel with the user
 		dmChannel, err := h.store.GetOrCreateSmackbotDM(reminder.UserID)
@@ -137,18 +144,24 @@ func (h *ReminderHandler) checkAndSendReminders() {
 		})
 
 		// Also send reminder notification via WebSocket
-		h.hub.SendToUser(reminder.UserID, models.WSMessage{
+		reminderEvent := models.WSMessage{
 			Type:    models.WSTypeReminder,
 			Payload: reminder,
-		})
+		}
+		if dndUserIDs != nil {
+			h.hub.sendToUser(reminder.UserID, reminderEvent, dndUserIDs[reminder.UserID])
+		} else {
+			h.hub.SendToUser(reminder.UserID, reminderEvent)
+		}
 
 		// Mark as completed
 		h.store.MarkReminderComplete(reminder.ID)
 	}
 }
 
-// parseRemindTime parses various time formats
-func parseRemindTime(input string) (time.Time, error) {
+// parseRemindTime parses various time formats. Dates and times without a zone
+// are read in loc.
+func parseRemindTime(input string, loc *time.Location) (time.Time, error) {
 	input = strings.TrimSpace(strings.ToLower(input))
 
 	// Try ISO 8601 first
@@ -164,7 +177,7 @@ func parseRemindTime(input string) (time.Time, error) {
 		"2006-01-02",
 	}
 	for _, format := range formats {
-		if t, err := time.Parse(format, input); err == nil {
+		if t, err := time.ParseInLocation(format, input, loc); err == nil {
 			return t, nil
 		}
 	}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"smack-server/middleware"
+	"smack-server/models"
+	"smack-server/store"
+	"strings"
+)
+
+type SectionHandler struct {
+	store *store.Store
+	hub   *Hub
+}
+
+func NewSectionHandler(s *store.Store, h *Hub) *SectionHandler {
+	return &SectionHandler{store: s, hub: h}
+}
+
+func (h *SectionHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	sections, err := h.store.GetChannelSections(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch sections", http.StatusInternalServerError)
+		return
+	}
+
+	if sections == nil {
+		sections = []models.ChannelSection{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sections)
+}
+
+func (h *SectionHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req models.CreateSectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Section name is required", http.StatusBadRequest)
+		return
+	}
+
+	section, err := h.store.CreateChannelSection(userID, req.Name)
+	if err != nil {
+		http.Error(w, "Failed to create section", http.StatusInternalServerError)
+		return
+	}
+
+	h.syncSections(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(section)
+}
+
+func (h *SectionHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	section, ok := h.getOwnSection(w, r.PathValue("id"), userID)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateSectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	name := section.Name
+	collapsed := section.Collapsed
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+		if name == "" {
+			http.Error(w, "Section name is required", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Collapsed != nil {
+		collapsed = *req.Collapsed
+	}
+
+	if err := h.store.UpdateChannelSection(section.ID, name, collapsed); err != nil {
+		http.Error(w, "Failed to update section", http.StatusInternalServerError)
+		return
+	}
+
+	h.syncSections(userID)
+
+	updated, _ := h.store.GetChannelSection(section.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+func (h *SectionHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	section, ok := h.getOwnSection(w, r.PathValue("id"), userID)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeleteChannelSection(section.ID); err != nil {
+		http.Error(w, "Failed to delete section", http.StatusInternalServerError)
+		return
+	}
+
+	h.syncSections(userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *SectionHandler) Reorder(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req models.ReorderSectionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.ReorderChannelSections(userID, req.SectionIDs); err != nil {
+		http.Error(w, "Failed to reorder sections", http.StatusInternalServerError)
+		return
+	}
+
+	sections := h.syncSections(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sections)
+}
+
+// SetChannels sets which channels are in a section and their order
+func (h *SectionHandler) SetChannels(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	section, ok := h.getOwnSection(w, r.PathValue("id"), userID)
+	if !ok {
+		return
+	}
+
+	var req models.SetSectionChannelsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	for _, channelID := range req.ChannelIDs {
+		if isMember, err := h.store.IsChannelMember(channelID, userID); err != nil || !isMember {
+			http.Error(w, "Not a member of channel "+channelID, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.store.SetSectionChannels(userID, section.ID, req.ChannelIDs); err != nil {
+		http.Error(w, "Failed to update section channels", http.StatusInternalServerError)
+		return
+	}
+
+	h.syncSections(userID)
+
+	updated, _ := h.store.GetChannelSection(section.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// getOwnSection loads a section and checks that it belongs to the user
+func (h *SectionHandler) getOwnSection(w http.ResponseWriter, sectionID, userID string) (*models.ChannelSection, bool) {
+	if sectionID == "" {
+		http.Error(w, "Section ID required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	section, err := h.store.GetChannelSection(sectionID)
+	if err != nil || section.UserID != userID {
+		http.Error(w, "Section not found", http.StatusNotFound)
+		return nil, false
+	}
+	return section, true
+}
+
+// syncSections pushes the user's current layout to all of their connected devices
+func (h *SectionHandler) syncSections(userID string) []models.ChannelSection {
+	sections, err := h.store.GetChannelSections(userID)
+	if err != nil || sections == nil {
+		sections = []models.ChannelSection{}
+	}
+
+	if h.hub != nil {
+		h.hub.SendToUser(userID, models.WSMessage{
+			Type:    models.WSTypeSectionsUpdated,
+			Payload: sections,
+		})
+	}
+	return sections
+}
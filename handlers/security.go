@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"smack-server/models"
+	"strconv"
+	"time"
+)
+
+func accountThrottleKey(userID string) string { return "user:" + userID }
+func ipThrottleKey(ip string) string          { return "ip:" + ip }
+
+// loginRetryAfter returns how long a login from ip for userID (which may be empty
+// for unknown usernames) has to wait because of earlier failures
+func (h *AuthHandler) loginRetryAfter(ip, userID string) time.Duration {
+	now := time.Now()
+
+	var wait time.Duration
+	if t, err := h.store.GetLoginThrottle(ipThrottleKey(ip)); err == nil {
+		wait = models.IPLoginPolicy.RetryAfter(t, now)
+	}
+	if userID != "" {
+		if t, err := h.store.GetLoginThrottle(accountThrottleKey(userID)); err == nil {
+			wait = max(wait, models.AccountLoginPolicy.RetryAfter(t, now))
+		}
+	}
+	return wait
+}
+
+// rejectThrottledLogin responds with 429 and returns true if the login has to wait
+func (h *AuthHandler) rejectThrottledLogin(w http.ResponseWriter, r *http.Request, user *models.User, username string) bool {
+	ip := clientIP(r)
+	userID := ""
+	if user != nil {
+		userID = user.ID
+	}
+
+	wait := h.loginRetryAfter(ip, userID)
+	if wait <= 0 {
+		return false
+	}
+
+	h.logSecurityEvent(models.SecurityEvent{
+		Type:      models.SecurityEventLoginBlocked,
+		UserID:    userID,
+		IPAddress: ip,
+		Details:   "username: " + username,
+	})
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, fmt.Sprintf("Too many failed login attempts; try again in %s", time.Duration(seconds)*time.Second), http.StatusTooManyRequests)
+	return true
+}
+
+// recordLoginFailure counts a failed login against the IP and, if the username
+// exists, the account. The user is told by Smackbot when their account gets locked.
+func (h *AuthHandler) recordLoginFailure(r *http.Request, user *models.User, username string) {
+	h.recordAuthFailure(r, user, "username: "+username)
+}
+
+// recordAuthFailure counts a wrong password or two-factor code the same way as a
+// failed login, so second factors and re-authentication share the login lockout
+func (h *AuthHandler) recordAuthFailure(r *http.Request, user *models.User, details string) {
+	ip := clientIP(r)
+
+	if _, _, err := h.store.RecordLoginFailure(ipThrottleKey(ip), models.IPLoginPolicy); err != nil {
+		log.Printf("Failed to record login failure for %s: %v", ip, err)
+	}
+
+	event := models.SecurityEvent{Type: models.SecurityEventLoginFailed, IPAddress: ip, Details: details}
+	if user == nil {
+		h.logSecurityEvent(event)
+		return
+	}
+	event.UserID = user.ID
+	h.logSecurityEvent(event)
+
+	throttle, locked, err := h.store.RecordLoginFailure(accountThrottleKey(user.ID), models.AccountLoginPolicy)
+	if err != nil {
+		log.Printf("Failed to record login failure for user %s: %v", user.ID, err)
+		return
+	}
+	if !locked {
+		return
+	}
+
+	h.logSecurityEvent(models.SecurityEvent{
+		Type:      models.SecurityEventAccountLocked,
+		UserID:    user.ID,
+		IPAddress: ip,
+		Details:   fmt.Sprintf("%d failed attempts; locked until %s", throttle.Failures, throttle.LockedUntil.UTC().Format(time.RFC3339)),
+	})
+
+	content := fmt.Sprintf("🔒 Your account was locked after %d failed sign-in attempts, most recently from %s. "+
+		"It will unlock at %s UTC, or an admin can unlock it sooner.\n\nIf this wasn't you, someone may be trying to guess your password. Consider changing it and turning on two-factor authentication.",
+		throttle.Failures, ip, throttle.LockedUntil.UTC().Format("2006-01-02 15:04"))
+	h.hub.SendSmackbotDM(user.ID, content)
+}
+
+func (h *AuthHandler) logSecurityEvent(event models.SecurityEvent) {
+	if err := h.store.LogSecurityEvent(event); err != nil {
+		log.Printf("Failed to log security event %s: %v", event.Type, err)
+	}
+}
+
+// UnlockUser clears a user's failed login attempts, ending any lockout
+func (h *AdminHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.ClearLoginFailures(accountThrottleKey(target.ID)); err != nil {
+		http.Error(w, "Failed to unlock user", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.store.LogSecurityEvent(models.SecurityEvent{
+		Type:      models.SecurityEventAccountUnlocked,
+		UserID:    target.ID,
+		ActorID:   actor.ID,
+		IPAddress: clientIP(r),
+	}); err != nil {
+		log.Printf("Failed to log security event: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(target.ToResponse())
+}
+
+// ListSecurityEvents returns the security log, newest first. It can be filtered by
+// user_id and type, and paged with before (an RFC 3339 timestamp) and limit.
+func (h *AdminHandler) ListSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := 100
+	if l := query.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 500 {
+			limit = parsed
+		}
+	}
+
+	var before *time.Time
+	if b := query.Get("before"); b != "" {
+		t, err := time.Parse(time.RFC3339Nano, b)
+		if err != nil {
+			http.Error(w, "Invalid before timestamp", http.StatusBadRequest)
+			return
+		}
+		before = &t
+	}
+
+	events, err := h.store.GetSecurityEvents(query.Get("user_id"), query.Get("type"), before, limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch security events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"smack-server/models"
+	"smack-server/totp"
+	"testing"
+	"time"
+)
+
+func TestWrongSecondFactorCodesLockTheAccount(t *testing.T) {
+	a := newAuthTest(t)
+	user := a.createUser("ada")
+	secret, _ := a.enableTwoFactor(user.ID)
+
+	// Each new challenge needs the right password, but wrong codes still add up
+	for i := 0; i < models.AccountLoginPolicy.FreeAttempts; i++ {
+		if rec := a.completeLogin(a.challenge("ada"), "000000"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d, want %d", i+1, rec.Code, http.StatusUnauthorized)
+		}
+	}
+
+	if rec := a.login("ada", testPassword); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("login after wrong codes: status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	events, err := a.store.GetSecurityEvents(user.ID, models.SecurityEventLoginFailed, nil, 10)
+	if err != nil {
+		t.Fatalf("GetSecurityEvents: %v", err)
+	}
+	if len(events) != models.AccountLoginPolicy.FreeAttempts {
+		t.Errorf("%d login_failed events, want %d", len(events), models.AccountLoginPolicy.FreeAttempts)
+	}
+
+	// Once the lockout has passed, signing in fully clears the account's failures
+	a.store.ClearLoginFailures(accountThrottleKey(user.ID))
+	a.store.ClearLoginFailures(ipThrottleKey("192.0.2.1"))
+	a.completeLogin(a.challenge("ada"), "000000")
+	code, _ := totp.CodeAt(secret, totp.Step(time.Now()))
+	if rec := a.completeLogin(a.challenge("ada"), code); rec.Code != http.StatusOK {
+		t.Fatalf("right code: status %d: %s", rec.Code, rec.Body)
+	}
+	if throttle, _ := a.store.GetLoginThrottle(accountThrottleKey(user.ID)); throttle != nil {
+		t.Errorf("account still has %d failures after signing in", throttle.Failures)
+	}
+}
+
+func TestCorrectPasswordDoesNotClearFailuresBeforeSecondFactor(t *testing.T) {
+	a := newAuthTest(t)
+	user := a.createUser("ada")
+	a.enableTwoFactor(user.ID)
+
+	a.completeLogin(a.challenge("ada"), "000000")
+	a.challenge("ada")
+
+	throttle, err := a.store.GetLoginThrottle(accountThrottleKey(user.ID))
+	if err != nil || throttle == nil || throttle.Failures != 1 {
+		t.Errorf("account failures after a correct password = %+v (%v), want 1", throttle, err)
+	}
+}
+
+func TestReauthenticationFailuresAreThrottled(t *testing.T) {
+	a := newAuthTest(t)
+	user := a.createUser("ada")
+
+	change := func() int {
+		return a.call(a.auth.ChangePassword, http.MethodPut, "/api/auth/password", user.ID, "",
+			models.ChangePasswordRequest{CurrentPassword: "wrong", NewPassword: "another123"}).Code
+	}
+	for i := 0; i < models.AccountLoginPolicy.FreeAttempts; i++ {
+		if code := change(); code != http.StatusForbidden {
+			t.Fatalf("attempt %d: status %d, want %d", i+1, code, http.StatusForbidden)
+		}
+	}
+	if code := change(); code != http.StatusTooManyRequests {
+		t.Errorf("status %d after repeated wrong passwords, want %d", code, http.StatusTooManyRequests)
+	}
+	if rec := a.login("ada", testPassword); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("login: status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
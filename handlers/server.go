@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"smack-server/middleware"
+	"smack-server/models"
 	"smack-server/store"
 )
 
@@ -20,6 +21,10 @@ type ServerHandler struct {
 type ServerInfo struct {
 	Name      string `json:"name"`
 	IconURL   string `json:"icon_url,omitempty"`
+
+	// Set on the public info so the login screen knows how people can sign up
+	RegistrationMode    string   `json:"registration_mode,omitempty"`
+	AllowedEmailDomains []string `json:"allowed_email_domains,omitempty"`
 }
 
 func NewServerHandler(s *store.Store, uploadDir string) *ServerHandler {
@@ -182,7 +187,70 @@ func (h *ServerHandler) GetPublicInfo(w http.ResponseWriter, r *http.Request) {
 	if iconURL, err := h.store.GetServerSetting("icon_url"); err == nil {
 		info.IconURL = iconURL
 	}
+	if settings, err := h.store.GetRegistrationSettings(); err == nil {
+		info.RegistrationMode = settings.Mode
+		if settings.Mode == models.RegistrationModeDomain {
+			info.AllowedEmailDomains = settings.AllowedDomains
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(info)
 }
+
+func (h *ServerHandler) GetOnboarding(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.store.GetOnboardingSettings()
+	if err != nil {
+		http.Error(w, "Failed to load onboarding settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *ServerHandler) UpdateOnboarding(w http.ResponseWriter, r *http.Request) {
+	var settings models.OnboardingSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if settings.DefaultChannels == nil {
+		settings.DefaultChannels = []string{}
+	}
+	if settings.StarterBoards == nil {
+		settings.StarterBoards = []string{}
+	}
+	if settings.StarterApps == nil {
+		settings.StarterApps = []string{}
+	}
+
+	for _, channelID := range settings.DefaultChannels {
+		channel, err := h.store.GetChannel(channelID)
+		if err != nil || channel.IsDirect {
+			http.Error(w, "Unknown channel: "+channelID, http.StatusBadRequest)
+			return
+		}
+	}
+	for _, boardID := range settings.StarterBoards {
+		if _, err := h.store.GetBoard(boardID); err != nil {
+			http.Error(w, "Unknown board: "+boardID, http.StatusBadRequest)
+			return
+		}
+	}
+	for _, appID := range settings.StarterApps {
+		if _, err := h.store.GetApp(appID); err != nil {
+			http.Error(w, "Unknown app: "+appID, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.store.SetOnboardingSettings(&settings); err != nil {
+		http.Error(w, "Failed to update", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"smack-server/middleware"
+	"smack-server/models"
+	"time"
+)
+
+// ListAccessTokens returns the current user's personal access tokens
+func (h *AuthHandler) ListAccessTokens(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	tokens, err := h.store.GetAccessTokens(userID)
+	if err != nil {
+		http.Error(w, "Failed to get access tokens", http.StatusInternalServerError)
+		return
+	}
+	if tokens == nil {
+		tokens = []models.PersonalAccessToken{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// CreateAccessToken issues a personal access token; the response is the only time
+// the token itself is shown
+func (h *AuthHandler) CreateAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req models.CreateAccessTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		http.Error(w, "Token name is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !models.IsValidAccessTokenScope(scope) {
+			http.Error(w, "Unknown scope: "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		http.Error(w, "expires_in_days can't be negative", http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	pat, token, err := h.store.CreateAccessToken(userID, req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		http.Error(w, "Failed to create access token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.CreateAccessTokenResponse{PersonalAccessToken: *pat, Token: token})
+}
+
+func (h *AuthHandler) DeleteAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	err := h.store.DeleteAccessToken(r.PathValue("id"), userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Access token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete access token", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
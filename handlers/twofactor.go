@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"smack-server/middleware"
+	"smack-server/models"
+	"smack-server/store"
+	"smack-server/totp"
+)
+
+// CompleteLogin finishes a login challenge with a TOTP or recovery code. If the user
+// is setting up 2FA as part of signing in, the code confirms enrollment and the
+// response includes their recovery codes.
+func (h *AuthHandler) CompleteLogin(w http.ResponseWriter, r *http.Request) {
+	var req models.LoginChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	login, user, ok := h.loadLoginChallenge(w, req.ChallengeToken)
+	if !ok {
+		return
+	}
+
+	// Wrong codes count towards the account and IP lockout as well as the challenge's
+	// own limit, so new challenges can't be used to keep guessing
+	if h.rejectThrottledLogin(w, r, user, user.Username) {
+		return
+	}
+
+	var recoveryCodes []string
+	if h.store.IsTwoFactorEnabled(user.ID) {
+		if !h.store.VerifyTwoFactorCode(user.ID, req.Code) {
+			h.store.RecordLoginChallengeFailure(req.ChallengeToken)
+			h.recordAuthFailure(r, user, "two-factor code for "+user.Username)
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+			return
+		}
+	} else {
+		codes, err := h.store.ConfirmTOTPEnrollment(user.ID, req.Code)
+		if errors.Is(err, store.ErrInvalidTwoFactorCode) {
+			h.store.RecordLoginChallengeFailure(req.ChallengeToken)
+			h.recordAuthFailure(r, user, "two-factor enrollment code for "+user.Username)
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		recoveryCodes = codes
+	}
+
+	h.store.DeleteLoginChallenge(req.ChallengeToken)
+	h.startSession(w, r, user, login.DeviceName, recoveryCodes)
+}
+
+// EnrollDuringLogin starts 2FA setup for a user who must have it before signing in
+func (h *AuthHandler) EnrollDuringLogin(w http.ResponseWriter, r *http.Request) {
+	var req models.LoginChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	_, user, ok := h.loadLoginChallenge(w, req.ChallengeToken)
+	if !ok {
+		return
+	}
+
+	h.startEnrollment(w, user)
+}
+
+// loadLoginChallenge looks up a pending login and its user, writing an error
+// response and returning false if it can't be used
+func (h *AuthHandler) loadLoginChallenge(w http.ResponseWriter, token string) (*models.PendingLogin, *models.User, bool) {
+	login, err := h.store.GetLoginChallenge(token)
+	if err != nil {
+		http.Error(w, "Login challenge is invalid or has expired", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	user, err := h.store.GetUserByID(login.UserID)
+	if err != nil || user.IsDeactivated() {
+		http.Error(w, "Login challenge is invalid or has expired", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	return login, user, true
+}
+
+func (h *AuthHandler) GetTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	status, err := h.store.GetTwoFactorStatus(userID)
+	if err != nil {
+		http.Error(w, "Failed to get two-factor status", http.StatusInternalServerError)
+		return
+	}
+	status.Required = h.store.IsTwoFactorRequired()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// EnrollTwoFactor starts 2FA setup, returning a new secret to add to an authenticator app
+func (h *AuthHandler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	user, err := h.store.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	h.startEnrollment(w, user)
+}
+
+func (h *AuthHandler) startEnrollment(w http.ResponseWriter, user *models.User) {
+	if h.store.IsTwoFactorEnabled(user.ID) {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := h.store.StartTOTPEnrollment(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to start enrollment", http.StatusInternalServerError)
+		return
+	}
+
+	issuer, err := h.store.GetServerSetting("name")
+	if err != nil || issuer == "" {
+		issuer = "Smack Server"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.TwoFactorEnrollment{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(issuer, user.Username, secret),
+	})
+}
+
+// ConfirmTwoFactor turns on 2FA after checking a code from the newly enrolled authenticator
+func (h *AuthHandler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req models.TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if h.store.IsTwoFactorEnabled(userID) {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	codes, err := h.store.ConfirmTOTPEnrollment(userID, req.Code)
+	if errors.Is(err, store.ErrInvalidTwoFactorCode) {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor turns off 2FA; it needs both the password and a current code
+func (h *AuthHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req models.DisableTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if h.store.IsTwoFactorRequired() {
+		http.Error(w, "Two-factor authentication is required on this server", http.StatusForbidden)
+		return
+	}
+
+	user, err := h.store.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if !h.store.IsTwoFactorEnabled(userID) {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	if h.rejectThrottledLogin(w, r, user, user.Username) {
+		return
+	}
+	if !h.store.ValidatePassword(user, req.Password) || !h.store.VerifyTwoFactorCode(userID, req.Code) {
+		h.recordAuthFailure(r, user, "disabling two-factor: wrong password or code")
+		http.Error(w, "Invalid password or code", http.StatusForbidden)
+		return
+	}
+
+	if err := h.store.DisableTwoFactor(userID); err != nil {
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a TOTP code
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req models.TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.store.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if !h.store.IsTwoFactorEnabled(userID) {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	if h.rejectThrottledLogin(w, r, user, user.Username) {
+		return
+	}
+	if !h.store.ValidateTOTP(userID, req.Code) {
+		h.recordAuthFailure(r, user, "regenerating recovery codes: wrong code")
+		http.Error(w, "Invalid code", http.StatusForbidden)
+		return
+	}
+
+	codes, err := h.store.RegenerateRecoveryCodes(userID)
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (h *AuthHandler) GetTwoFactorSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.TwoFactorSettings{Required: h.store.IsTwoFactorRequired()})
+}
+
+// UpdateTwoFactorSettings turns the server-wide 2FA requirement on or off. Users
+// without 2FA will have to set it up the next time they sign in.
+func (h *AuthHandler) UpdateTwoFactorSettings(w http.ResponseWriter, r *http.Request) {
+	var settings models.TwoFactorSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.SetTwoFactorRequired(settings.Required); err != nil {
+		http.Error(w, "Failed to update", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"smack-server/models"
+	"smack-server/totp"
+	"testing"
+	"time"
+)
+
+// clearThrottles forgets failed attempts so a test can keep going past the lockout
+func (a *authTest) clearThrottles(userID string) {
+	a.store.ClearLoginFailures(accountThrottleKey(userID))
+	a.store.ClearLoginFailures(ipThrottleKey("192.0.2.1"))
+}
+
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := totp.CodeAt(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatalf("CodeAt: %v", err)
+	}
+	return code
+}
+
+func decodeAuthResponse(t *testing.T, body []byte) models.AuthResponse {
+	t.Helper()
+	var resp models.AuthResponse
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Token == "" {
+		t.Fatalf("no tokens in response: %s", body)
+	}
+	return resp
+}
+
+func TestCompleteLoginWrongCode(t *testing.T) {
+	a := newAuthTest(t)
+	user := a.createUser("ada")
+	secret, _ := a.enableTwoFactor(user.ID)
+
+	token := a.challenge("ada")
+	if rec := a.completeLogin(token, "000000"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong code: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	// The challenge survives a wrong code, but not being used
+	rec := a.completeLogin(token, currentCode(t, secret))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("right code: status %d: %s", rec.Code, rec.Body)
+	}
+	if resp := decodeAuthResponse(t, rec.Body.Bytes()); resp.User.ID != user.ID {
+		t.Errorf("signed in as %s, want %s", resp.User.ID, user.ID)
+	}
+	if rec := a.completeLogin(token, currentCode(t, secret)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("reused challenge: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestCompleteLoginExpiredChallenge(t *testing.T) {
+	a := newAuthTest(t)
+	user := a.createUser("ada")
+	secret, _ := a.enableTwoFactor(user.ID)
+	token := a.challenge("ada")
+
+	db, err := sql.Open("sqlite3", a.dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("UPDATE login_challenges SET expires_at = ?", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("expiring challenge: %v", err)
+	}
+
+	if rec := a.completeLogin(token, currentCode(t, secret)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expired challenge: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestCompleteLoginChallengeAttemptLimit(t *testing.T) {
+	a := newAuthTest(t)
+	user := a.createUser("ada")
+	secret, _ := a.enableTwoFactor(user.ID)
+	token := a.challenge("ada")
+
+	for i := 0; i < models.MaxLoginChallengeAttempts; i++ {
+		// Keep the account lockout out of the way to test the challenge's own limit
+		a.clearThrottles(user.ID)
+		if rec := a.completeLogin(token, "000000"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d, want %d", i+1, rec.Code, http.StatusUnauthorized)
+		}
+	}
+
+	a.clearThrottles(user.ID)
+	if rec := a.completeLogin(token, currentCode(t, secret)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("right code after %d wrong ones: status %d, want %d", models.MaxLoginChallengeAttempts, rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestCompleteLoginWithRecoveryCode(t *testing.T) {
+	a := newAuthTest(t)
+	user := a.createUser("ada")
+	_, recoveryCodes := a.enableTwoFactor(user.ID)
+
+	rec := a.completeLogin(a.challenge("ada"), recoveryCodes[0])
+	if rec.Code != http.StatusOK {
+		t.Fatalf("recovery code: status %d: %s", rec.Code, rec.Body)
+	}
+	decodeAuthResponse(t, rec.Body.Bytes())
+
+	if rec := a.completeLogin(a.challenge("ada"), recoveryCodes[0]); rec.Code != http.StatusUnauthorized {
+		t.Errorf("used recovery code: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestCompleteLoginEnrollsWhenRequired(t *testing.T) {
+	a := newAuthTest(t)
+	user := a.createUser("ada")
+	if err := a.store.SetTwoFactorRequired(true); err != nil {
+		t.Fatalf("SetTwoFactorRequired: %v", err)
+	}
+
+	rec := a.login("ada", testPassword)
+	var challenge models.LoginChallenge
+	if err := json.Unmarshal(rec.Body.Bytes(), &challenge); err != nil || !challenge.EnrollmentRequired {
+		t.Fatalf("login didn't require enrollment: %s", rec.Body)
+	}
+
+	rec = a.call(a.auth.EnrollDuringLogin, http.MethodPost, "/api/auth/login/2fa/enroll", "", "",
+		models.LoginChallengeRequest{ChallengeToken: challenge.ChallengeToken})
+	var enrollment models.TwoFactorEnrollment
+	if err := json.Unmarshal(rec.Body.Bytes(), &enrollment); err != nil || enrollment.Secret == "" {
+		t.Fatalf("enrollment didn't return a secret: %s", rec.Body)
+	}
+
+	if rec := a.completeLogin(challenge.ChallengeToken, "000000"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong code: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if a.store.IsTwoFactorEnabled(user.ID) {
+		t.Fatal("2FA enabled by a wrong code")
+	}
+
+	rec = a.completeLogin(challenge.ChallengeToken, currentCode(t, enrollment.Secret))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("right code: status %d: %s", rec.Code, rec.Body)
+	}
+	if resp := decodeAuthResponse(t, rec.Body.Bytes()); len(resp.RecoveryCodes) == 0 {
+		t.Error("no recovery codes after enrolling during login")
+	}
+	if !a.store.IsTwoFactorEnabled(user.ID) {
+		t.Error("2FA not enabled after enrolling during login")
+	}
+}
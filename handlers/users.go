@@ -6,14 +6,16 @@ import (
 	"smack-server/middleware"
 	"smack-server/models"
 	"smack-server/store"
+	"strconv"
 )
 
 type UserHandler struct {
 	store *store.Store
+	hub   *Hub
 }
 
-func NewUserHandler(s *store.Store) *UserHandler {
-	return &UserHandler{store: s}
+func NewUserHandler(s *store.Store, hub *Hub) *UserHandler {
+	return &UserHandler{store: s, hub: hub}
 }
 
 func (h *UserHandler) List(w http.ResponseWriter, r *http.Request) {
@@ -23,8 +25,11 @@ func (h *UserHandler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	dndUserIDs, _ := h.store.GetDNDUserIDs()
+
 	responses := make([]models.UserResponse, len(users))
 	for i, u := range users {
+		u.DoNotDisturb = dndUserIDs[u.ID]
 		responses[i] = u.ToResponse()
 	}
 
@@ -32,6 +37,73 @@ func (h *UserHandler) List(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(responses)
 }
 
+// Directory lists users with prefix search, filters and cursor pagination
+func (h *UserHandler) Directory(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	query := models.UserDirectoryQuery{
+		Query:       params.Get("q"),
+		Kind:        params.Get("type"),
+		OnlineOnly:  params.Get("online") == "true",
+		Deactivated: params.Get("deactivated") == "true",
+		Cursor:      params.Get("cursor"),
+		Limit:       50,
+	}
+
+	switch query.Kind {
+	case "", models.UserKindHumans, models.UserKindBots:
+	default:
+		http.Error(w, "Type must be humans or bots", http.StatusBadRequest)
+		return
+	}
+
+	if l := params.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			query.Limit = parsed
+		}
+	}
+
+	page, err := h.store.GetUserDirectory(query)
+	if err == store.ErrInvalidCursor {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+
+	dndUserIDs, _ := h.store.GetDNDUserIDs()
+	for i := range page.Users {
+		page.Users[i].DoNotDisturb = dndUserIDs[page.Users[i].ID]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// Autocomplete suggests users for an @mention prefix, putting members of the
+// optional channel_id first
+func (h *UserHandler) Autocomplete(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	limit := 10
+	if l := params.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 25 {
+			limit = parsed
+		}
+	}
+
+	suggestions, err := h.store.SuggestUsers(params.Get("q"), params.Get("channel_id"), limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suggestions)
+}
+
 func (h *UserHandler) Get(w http.ResponseWriter, r *http.Request) {
 	userID := r.PathValue("id")
 	if userID == "" {
@@ -44,9 +116,13 @@ func (h *UserHandler) Get(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	user.DoNotDisturb = h.store.IsDNDActive(user.ID)
+
+	viewer, _ := h.store.GetUserByID(middleware.GetUserID(r))
+	h.loadProfileFields(user, viewer)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user.ToResponse())
+	json.NewEncoder(w).Encode(user.ToResponseFor(viewer))
 }
 
 func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
@@ -57,9 +133,11 @@ func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	user.DoNotDisturb = h.store.IsDNDActive(user.ID)
+	h.loadProfileFields(user, user)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user.ToResponse())
+	json.NewEncoder(w).Encode(user.ToPrivateResponse())
 }
 
 func (h *UserHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
@@ -93,9 +171,10 @@ func (h *UserHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	user.DoNotDisturb = h.store.IsDNDActive(user.ID)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user.ToResponse())
+	json.NewEncoder(w).Encode(user.ToPrivateResponse())
 }
 
 func (h *UserHandler) GetPreferences(w http.ResponseWriter, r *http.Request) {
@@ -193,6 +272,8 @@ func (h *UserHandler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to update status", http.StatusInternalServerError)
 		return
 	}
+	h.hub.BroadcastUserStatus(userID)
+	go h.hub.DeliverHeldNotifications(userID)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": req.Status})
@@ -52,6 +52,17 @@ func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if channel.ArchivedAt != nil {
+		http.Error(w, `{"error":"Channel is archived and read-only"}`, http.StatusForbidden)
+		return
+	}
+
+	// Only channel owners and admins can create webhooks
+	if !models.ChannelRoleCan(channelRole(h.store, req.ChannelID, userID), models.ChannelActionCreateWebhook) {
+		http.Error(w, `{"error":"Not authorized to create webhooks in this channel"}`, http.StatusForbidden)
+		return
+	}
+
 	webhook, err := h.store.CreateWebhook(req.Name, req.ChannelID, userID)
 	if err != nil {
 		http.Error(w, `{"error":"Failed to create webhook"}`, http.StatusInternalServerError)
@@ -156,6 +167,11 @@ func (h *WebhookHandler) Incoming(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.store.IsChannelArchived(webhook.ChannelID) {
+		http.Error(w, `{"error":"Channel is archived and read-only"}`, http.StatusForbidden)
+		return
+	}
+
 	var req models.IncomingWebhookRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, `{"error":"Invalid request body"}`, http.StatusBadRequest)
@@ -219,6 +235,7 @@ func (h *WebhookHandler) Incoming(w http.ResponseWriter, r *http.Request) {
 		Type:    models.WSTypeNewMessage,
 		Payload: msgWithUser,
 	})
+	go h.hub.SendNotifications(msgWithUser)
 
 	// Return simplified response
 	response := map[string]interface{}{
@@ -2,11 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"regexp"
 	"smack-server/middleware"
 	"smack-server/models"
 	"smack-server/store"
+	"strings"
 	"sync"
 	"time"
 
@@ -33,10 +36,12 @@ type Client struct {
 	conn       *websocket.Conn
 	send       chan []byte
 	userID     string
+	sessionID  string
 	channels   map[string]bool
 	channelsMu sync.RWMutex
 	apps       map[string]bool
 	appsMu     sync.RWMutex
+	lastActive time.Time // guarded by hub.mu
 }
 
 type Hub struct {
@@ -45,6 +50,7 @@ type Hub struct {
 	register   chan *Client
 	unregister chan *Client
 	store      *store.Store
+	idle       map[string]bool // users shown as away for inactivity
 	mu         sync.RWMutex
 }
 
@@ -55,6 +61,7 @@ func NewHub(s *store.Store) *Hub {
 		register:   make(chan *Client, 16),
 		unregister: make(chan *Client, 16),
 		store:      s,
+		idle:       make(map[string]bool),
 	}
 }
 
@@ -74,13 +81,19 @@ func (h *Hub) Run() {
 				}
 			}
 			h.clients[client] = true
+			wasIdle := h.idle[client.userID]
+			delete(h.idle, client.userID)
 			clientCount := len(h.clients)
 			h.mu.Unlock()
 
 			log.Printf("[WS HUB] ✅ Client registered: %s (total clients: %d, first=%v)", client.userID, clientCount, isFirstConnection)
 
-			// Update user status to online
-			h.store.UpdateUserStatus(client.userID, "online")
+			// Mark the user as connected; any status they picked is kept
+			h.store.SetUserPresence(client.userID, "online")
+			h.store.TouchLastSeen(client.userID)
+			if wasIdle {
+				h.BroadcastUserStatus(client.userID)
+			}
 
 			// Only broadcast user_online on first connection to avoid duplicate notifications
 			if isFirstConnection {
@@ -109,6 +122,9 @@ func (h *Hub) Run() {
 						break
 					}
 				}
+				if !hasOtherConnections {
+					delete(h.idle, client.userID)
+				}
 			}
 			clientCount := len(h.clients)
 			h.mu.Unlock()
@@ -118,7 +134,7 @@ func (h *Hub) Run() {
 
 				// Only mark offline and broadcast if this was the user's last connection
 				if !hasOtherConnections {
-					h.store.UpdateUserStatus(client.userID, "offline")
+					h.store.SetUserPresence(client.userID, "offline")
 
 					go h.BroadcastAll(models.WSMessage{
 						Type: models.WSTypeUserOffline,
@@ -169,6 +185,135 @@ func (h *Hub) Run() {
 	}
 }
 
+// markActive records activity on a client, bringing its user back from idle
+func (h *Hub) markActive(client *Client) {
+	h.mu.Lock()
+	client.lastActive = time.Now()
+	wasIdle := h.idle[client.userID]
+	delete(h.idle, client.userID)
+	h.mu.Unlock()
+
+	h.store.TouchLastSeen(client.userID)
+	if wasIdle {
+		h.store.SetUserPresence(client.userID, "online")
+		h.BroadcastUserStatus(client.userID)
+	}
+}
+
+// StartIdleChecker starts a goroutine that shows users as away once none of their
+// connections has seen activity for models.IdleTimeout
+func (h *Hub) StartIdleChecker() {
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			h.checkIdle()
+		}
+	}()
+}
+
+func (h *Hub) checkIdle() {
+	now := time.Now()
+
+	h.mu.Lock()
+	lastActive := make(map[string]time.Time)
+	for client := range h.clients {
+		if client.lastActive.After(lastActive[client.userID]) {
+			lastActive[client.userID] = client.lastActive
+		}
+	}
+	var nowIdle []string
+	for userID, t := range lastActive {
+		if !h.idle[userID] && now.Sub(t) >= models.IdleTimeout {
+			h.idle[userID] = true
+			nowIdle = append(nowIdle, userID)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, userID := range nowIdle {
+		h.store.SetUserPresence(userID, "away")
+		h.BroadcastUserStatus(userID)
+	}
+}
+
+// BroadcastUserStatus tells everyone about a user's current status and custom
+// status, returning the user
+func (h *Hub) BroadcastUserStatus(userID string) *models.User {
+	user, err := h.store.GetUserByID(userID)
+	if err != nil {
+		return nil
+	}
+	user.DoNotDisturb = h.store.IsDNDActive(userID)
+
+	go h.BroadcastAll(models.WSMessage{
+		Type: models.WSTypeUserStatusChanged,
+		Payload: models.UserStatusChanged{
+			UserID:       user.ID,
+			Status:       user.Status,
+			CustomStatus: user.CustomStatus,
+			DoNotDisturb: user.DoNotDisturb,
+		},
+	})
+	return user
+}
+
+// StartDNDChecker starts a goroutine that delivers held pushes once do not disturb ends
+func (h *Hub) StartDNDChecker() {
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			userIDs, err := h.store.GetUsersWithHeldNotifications()
+			if err != nil {
+				continue
+			}
+			for _, userID := range userIDs {
+				h.DeliverHeldNotifications(userID)
+			}
+		}
+	}()
+}
+
+// DeliverHeldNotifications sends a user a summary of the pushes held back during
+// do not disturb, if it has ended and they're connected
+func (h *Hub) DeliverHeldNotifications(userID string) {
+	if h.store.IsDNDActive(userID) || !h.isConnected(userID) {
+		return
+	}
+
+	held, err := h.store.TakeHeldNotifications(userID)
+	if err != nil || len(held) == 0 {
+		return
+	}
+
+	summary := models.DNDSummary{Held: held}
+	for _, n := range held {
+		switch n.Type {
+		case models.WSTypeMention:
+			summary.Mentions++
+		case models.WSTypeNotification:
+			summary.Notifications++
+		case models.WSTypeReminder:
+			summary.Reminders++
+		}
+	}
+	h.SendToUser(userID, models.WSMessage{Type: models.WSTypeDNDSummary, Payload: summary})
+}
+
+func (h *Hub) isConnected(userID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		if client.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
 // disconnectUser closes all existing connections for a user
 func (h *Hub) disconnectUser(userID string) {
 	h.mu.Lock()
@@ -190,6 +335,23 @@ func (h *Hub) disconnectUser(userID string) {
 	}
 }
 
+// DisconnectSession closes every connection opened with a session's tokens. The
+// read pumps then unregister the clients as usual.
+func (h *Hub) DisconnectSession(sessionID string) {
+	h.mu.RLock()
+	closed := 0
+	for client := range h.clients {
+		if client.sessionID == sessionID {
+			client.conn.Close()
+			closed++
+		}
+	}
+	h.mu.RUnlock()
+	if closed > 0 {
+		log.Printf("[WS] Closed %d connection(s) for revoked session %s", closed, sessionID)
+	}
+}
+
 func (h *Hub) BroadcastToChannel(channelID string, msg models.WSMessage) {
 	// Simplified: broadcast to ALL clients, let client filter by channel
 	// This removes subscription complexity that was causing delivery issues
@@ -200,13 +362,20 @@ func (h *Hub) BroadcastToChannel(channelID string, msg models.WSMessage) {
 
 	isTyping := msg.Type == models.WSTypeTyping
 
+	// People who blocked the author get the message collapsed
+	blockers, collapsed := h.collapsedForBlockers(msg)
+
 	sentCount := 0
 	var staleClients []*Client
 	h.mu.RLock()
 	totalClients := len(h.clients)
 	for client := range h.clients {
+		clientData := data
+		if blockers[client.userID] {
+			clientData = collapsed
+		}
 		select {
-		case client.send <- data:
+		case client.send <- clientData:
 			sentCount++
 		default:
 			log.Printf("[WS] Client %s buffer full, closing", client.userID)
@@ -231,6 +400,29 @@ func (h *Hub) BroadcastToChannel(channelID string, msg models.WSMessage) {
 	}
 }
 
+// collapsedForBlockers returns who blocked the author of a new message, along with
+// the collapsed message to send them instead
+func (h *Hub) collapsedForBlockers(msg models.WSMessage) (map[string]bool, []byte) {
+	if msg.Type != models.WSTypeNewMessage {
+		return nil, nil
+	}
+	m, ok := msg.Payload.(models.MessageWithUser)
+	if !ok || m.Type == models.MessageTypeSystem {
+		return nil, nil
+	}
+	blockers, err := h.store.GetBlockerIDs(m.UserID)
+	if err != nil || len(blockers) == 0 {
+		return nil, nil
+	}
+
+	m.Collapse()
+	collapsed, err := json.Marshal(models.WSMessage{Type: msg.Type, Payload: m})
+	if err != nil {
+		return nil, nil
+	}
+	return blockers, collapsed
+}
+
 // BroadcastToChannelExcept sends a message to all clients in a channel except the specified one
 func (h *Hub) BroadcastToChannelExcept(channelID string, except *Client, msg models.WSMessage) {
 	data, err := json.Marshal(msg)
@@ -263,12 +455,27 @@ func (h *Hub) BroadcastAll(msg models.WSMessage) {
 }
 
 func (h *Hub) SendToUser(userID string, msg models.WSMessage) {
+	h.sendToUser(userID, msg, models.HoldsDuringDND(msg.Type) && h.store.IsDNDActive(userID))
+}
+
+// sendToUser sends to all of a user's connections, or holds the message back if dnd
+// is set. Fan-outs look up who has do not disturb on once and pass it in.
+func (h *Hub) sendToUser(userID string, msg models.WSMessage, dnd bool) {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		log.Printf("[WS] SendToUser marshal error for type '%s': %v", msg.Type, err)
 		return
 	}
 
+	// Hold mentions, notifications and reminders back while the user has do not disturb on
+	if dnd && models.HoldsDuringDND(msg.Type) {
+		payload, _ := json.Marshal(msg.Payload)
+		if err := h.store.HoldNotification(userID, msg.Type, payload); err != nil {
+			log.Printf("[WS] Failed to hold '%s' for user %s: %v", msg.Type, userID, err)
+		}
+		return
+	}
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
@@ -288,6 +495,149 @@ func (h *Hub) SendToUser(userID string, msg models.WSMessage) {
 	log.Printf("[WS] SendToUser type '%s' to user %s: sent to %d connections", msg.Type, userID, sentCount)
 }
 
+// PostSystemMessage creates a system message in the channel and broadcasts it
+func (h *Hub) PostSystemMessage(channelID string, user *models.User, content string) {
+	msg, err := h.store.CreateSystemMessage(channelID, user.ID, content)
+	if err != nil {
+		return
+	}
+
+	h.BroadcastToChannel(channelID, models.WSMessage{
+		Type: models.WSTypeNewMessage,
+		Payload: models.MessageWithUser{
+			Message: *msg,
+			User:    user.ToResponse(),
+		},
+	})
+}
+
+// SendSmackbotDM sends the user a direct message from Smackbot. Only the user's own
+// connections are told about it, since these messages can hold codes and other
+// details meant for them alone.
+func (h *Hub) SendSmackbotDM(userID, content string) (*models.Message, error) {
+	msg, err := h.store.SendSmackbotDM(userID, content)
+	if err != nil {
+		log.Printf("Failed to send Smackbot DM to %s: %v", userID, err)
+		return nil, err
+	}
+	smackbot, err := h.store.GetSmackbot()
+	if err != nil {
+		return msg, nil
+	}
+	h.SendToUser(userID, models.WSMessage{
+		Type: models.WSTypeNewMessage,
+		Payload: models.MessageWithUser{
+			Message: *msg,
+			User:    smackbot.ToResponse(),
+		},
+	})
+	return msg, nil
+}
+
+// AnnounceMembership tells clients that user joined or left the channel and, if the
+// channel has join/leave messages on, posts a system message. actor is set when
+// someone else added or removed the user.
+func (h *Hub) AnnounceMembership(channel *models.Channel, user, actor *models.User, joined bool) {
+	if user == nil {
+		return
+	}
+
+	payload := models.MemberEventPayload{
+		ChannelID: channel.ID,
+		User:      user.ToResponse(),
+	}
+	if actor != nil && actor.ID != user.ID {
+		actorResp := actor.ToResponse()
+		payload.Actor = &actorResp
+	}
+
+	eventType := models.WSTypeMemberLeft
+	if joined {
+		eventType = models.WSTypeMemberJoined
+	}
+	h.BroadcastToChannel(channel.ID, models.WSMessage{Type: eventType, Payload: payload})
+
+	if !channel.JoinLeaveMessages || channel.IsDirect || channel.ArchivedAt != nil {
+		return
+	}
+
+	author := user
+	var text string
+	switch {
+	case payload.Actor != nil && joined:
+		author = actor
+		text = fmt.Sprintf("%s added %s to #%s", actor.DisplayName, user.DisplayName, channel.Name)
+	case payload.Actor != nil:
+		author = actor
+		text = fmt.Sprintf("%s removed %s from #%s", actor.DisplayName, user.DisplayName, channel.Name)
+	case joined:
+		text = fmt.Sprintf("%s joined #%s", user.DisplayName, channel.Name)
+	default:
+		text = fmt.Sprintf("%s left #%s", user.DisplayName, channel.Name)
+	}
+	h.PostSystemMessage(channel.ID, author, text)
+}
+
+var mentionPattern = regexp.MustCompile(`@([\w.-]+)`)
+
+// SendNotifications tells channel members about a new message. Each member gets a
+// mention or notification event depending on their notification level for the
+// channel (or thread); the sender is skipped.
+func (h *Hub) SendNotifications(msg models.MessageWithUser) {
+	members, err := h.store.GetChannelMembers(msg.ChannelID)
+	if err != nil {
+		return
+	}
+
+	mentioned := make(map[string]bool)
+	everyone := false
+	for _, m := range mentionPattern.FindAllStringSubmatch(msg.Content, -1) {
+		name := strings.ToLower(m[1])
+		if name == "channel" || name == "here" || name == "everyone" {
+			everyone = true
+		}
+		mentioned[name] = true
+	}
+
+	payload := models.NotificationPayload{
+		ChannelID: msg.ChannelID,
+		ThreadID:  msg.ThreadID,
+		Message:   msg,
+	}
+
+	// People who blocked the sender aren't notified
+	blockers, _ := h.store.GetBlockerIDs(msg.UserID)
+
+	dndUserIDs, err := h.store.GetDNDUserIDs()
+	if err != nil {
+		log.Printf("[WS] Failed to load do not disturb users: %v", err)
+	}
+	send := func(userID string, msg models.WSMessage) {
+		if dndUserIDs == nil {
+			h.SendToUser(userID, msg)
+			return
+		}
+		h.sendToUser(userID, msg, dndUserIDs[userID])
+	}
+
+	for _, member := range members {
+		if member.ID == msg.UserID || blockers[member.ID] {
+			continue
+		}
+
+		level := h.store.GetNotificationLevel(member.ID, msg.ChannelID, msg.ThreadID)
+		if level == models.NotificationLevelNothing {
+			continue
+		}
+
+		if everyone || mentioned[strings.ToLower(member.Username)] {
+			send(member.ID, models.WSMessage{Type: models.WSTypeMention, Payload: payload})
+		} else if level == models.NotificationLevelAll {
+			send(member.ID, models.WSMessage{Type: models.WSTypeNotification, Payload: payload})
+		}
+	}
+}
+
 func (h *Hub) BroadcastToApp(appID string, msg models.WSMessage) {
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -338,6 +688,11 @@ func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid token", http.StatusUnauthorized)
 		return
 	}
+	if !h.store.IsSessionActive(claims.SessionID) {
+		log.Printf("[WS] Connection rejected - session %s revoked from %s", claims.SessionID, r.RemoteAddr)
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
 
 	log.Printf("[WS] Token validated for user %s from %s", claims.UserID, r.RemoteAddr)
 
@@ -353,7 +708,7 @@ func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[WS] Connection upgraded to WebSocket for user %s", claims.UserID)
 
 	// Get user's channels
-	channels, err := h.store.GetChannelsForUser(claims.UserID)
+	channels, err := h.store.GetChannelsForUser(claims.UserID, true)
 	if err != nil {
 		log.Printf("[WS] ⚠️ Failed to get channels for user %s: %v", claims.UserID, err)
 	}
@@ -364,12 +719,14 @@ func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[WS] User %s auto-subscribed to %d channels", claims.UserID, len(channelMap))
 
 	client := &Client{
-		hub:      h,
-		conn:     conn,
-		send:     make(chan []byte, 256),
-		userID:   claims.UserID,
-		channels: channelMap,
-		apps:     make(map[string]bool),
+		hub:        h,
+		conn:       conn,
+		send:       make(chan []byte, 256),
+		userID:     claims.UserID,
+		sessionID:  claims.SessionID,
+		channels:   channelMap,
+		apps:       make(map[string]bool),
+		lastActive: time.Now(),
 	}
 
 	// Send a welcome message immediately BEFORE registering
@@ -460,7 +817,10 @@ func (c *Client) readPump() {
 		}
 
 		switch wsMsg.Type {
+		case models.WSTypeActivity:
+			c.hub.markActive(c)
 		case models.WSTypeTyping:
+			c.hub.markActive(c)
 			// Broadcast typing indicator to OTHER clients in channel (not back to sender)
 			if payload, ok := wsMsg.Payload.(map[string]interface{}); ok {
 				if channelID, ok := payload["channel_id"].(string); ok {
@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer sends plain text email through an SMTP server. Without a server configured,
+// messages are written to the log instead so codes can still be read in development.
+type Mailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func New(host, port, username, password, from string) *Mailer {
+	if port == "" {
+		port = "587"
+	}
+	return &Mailer{host: host, port: port, username: username, password: password, from: from}
+}
+
+// Configured reports whether an SMTP server has been set up
+func (m *Mailer) Configured() bool {
+	return m.host != ""
+}
+
+// Send sends a plain text email to one address
+func (m *Mailer) Send(to, subject, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return errors.New("invalid email header")
+	}
+
+	if !m.Configured() {
+		log.Printf("[Mail] SMTP isn't configured; email to %s:\nSubject: %s\n\n%s", to, subject, body)
+		return nil
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+	return smtp.SendMail(m.host+":"+m.port, auth, m.from, []string{to}, []byte(msg.String()))
+}
@@ -5,7 +5,9 @@ import (
 	"net/http"
 	"os"
 	"smack-server/handlers"
+	"smack-server/mailer"
 	"smack-server/middleware"
+	"smack-server/models"
 	"smack-server/store"
 	"strings"
 )
@@ -23,16 +25,36 @@ func main() {
 	}
 	defer s.Close()
 
+	// Administrative subcommands run against the database and exit
+	if runCommand(s, os.Args[1:]) {
+		return
+	}
+
+	// Load access token signing keys
+	if err := handlers.LoadSigningKeys(s); err != nil {
+		log.Fatal("Failed to load signing keys:", err)
+	}
+	withAuth := requireAuth(s)
+	withAdmin := requireAdmin(s, withAuth)
+
 	// Initialize WebSocket hub
 	hub := handlers.NewHub(s)
 	go hub.Run()
+	hub.StartIdleChecker()
+	hub.StartDNDChecker()
+
+	// Outgoing email, used for registration codes. Without SMTP_HOST emails are logged.
+	mail := mailer.New(os.Getenv("SMTP_HOST"), os.Getenv("SMTP_PORT"), os.Getenv("SMTP_USERNAME"),
+		os.Getenv("SMTP_PASSWORD"), os.Getenv("SMTP_FROM"))
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(s)
-	channelHandler := handlers.NewChannelHandler(s)
+	authHandler := handlers.NewAuthHandler(s, hub, mail)
+	oidcHandler := handlers.NewOIDCHandler(s, authHandler)
+	channelHandler := handlers.NewChannelHandler(s, hub)
 	messageHandler := handlers.NewMessageHandler(s, hub)
-	userHandler := handlers.NewUserHandler(s)
+	userHandler := handlers.NewUserHandler(s, hub)
 	reminderHandler := handlers.NewReminderHandler(s, hub)
+	sectionHandler := handlers.NewSectionHandler(s, hub)
 	botHandler := handlers.NewBotHandler(s)
 	reactionHandler := handlers.NewReactionHandler(s, hub)
 	webhookHandler := handlers.NewWebhookHandler(s, hub)
@@ -58,16 +80,52 @@ func main() {
 	}
 	fileHandler := handlers.NewFileHandler(uploadDir)
 	serverHandler := handlers.NewServerHandler(s, uploadDir)
+	adminHandler := handlers.NewAdminHandler(s, hub)
+
+	// Personal data export directory
+	exportDir := os.Getenv("EXPORT_DIR")
+	if exportDir == "" {
+		exportDir = "./exports"
+	}
+	accountHandler, err := handlers.NewAccountHandler(s, hub, authHandler, uploadDir, exportDir)
+	if err != nil {
+		log.Fatal("Failed to create export directory:", err)
+	}
 
 	// Start reminder checker
 	reminderHandler.StartReminderChecker()
 
+	// Start clearing expired custom statuses
+	userHandler.StartCustomStatusExpiry()
+
+	// Start removing expired data exports
+	accountHandler.StartExportCleanup()
+
+	// Reverse proxies whose X-Forwarded-For can be believed
+	if err := handlers.SetTrustedProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		log.Fatal("Failed to parse TRUSTED_PROXIES:", err)
+	}
+
 	// Create router
 	mux := http.NewServeMux()
 
 	// Public routes (no auth required)
 	mux.HandleFunc("POST /api/auth/register", authHandler.Register)
+	mux.HandleFunc("POST /api/auth/register/email", authHandler.SendRegistrationEmailCode)
 	mux.HandleFunc("POST /api/auth/login", authHandler.Login)
+	mux.HandleFunc("POST /api/auth/login/2fa", authHandler.CompleteLogin)
+	mux.HandleFunc("POST /api/auth/login/2fa/enroll", authHandler.EnrollDuringLogin)
+	mux.HandleFunc("POST /api/auth/refresh", authHandler.Refresh)
+	mux.HandleFunc("POST /api/auth/password/reset", authHandler.ResetPassword)
+	mux.HandleFunc("GET /api/auth/password-policy", authHandler.GetPasswordPolicy)
+	mux.HandleFunc("GET /api/auth/methods", oidcHandler.Methods)
+	mux.HandleFunc("GET /api/auth/oidc/login", oidcHandler.Login)
+	mux.HandleFunc("GET /api/auth/oidc/callback", oidcHandler.Callback)
+	mux.HandleFunc("POST /api/auth/oidc/link", oidcHandler.ConfirmLink)
+
+	// Channel invites (public - preview and sign up through an invite link)
+	mux.HandleFunc("GET /api/invites/{token}", channelHandler.PreviewInvite)
+	mux.HandleFunc("POST /api/invites/{token}/register", authHandler.RegisterWithInvite)
 	mux.HandleFunc("GET /api/ws", hub.HandleWebSocket)
 	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("OK"))
@@ -78,34 +136,101 @@ func main() {
 
 	// Protected routes (auth required)
 	mux.HandleFunc("GET /api/auth/me", withAuth(authHandler.Me))
-
-	// Server settings (auth required)
-	mux.HandleFunc("PUT /api/server", withAuth(serverHandler.UpdateInfo))
-	mux.HandleFunc("POST /api/server/icon", withAuth(serverHandler.UploadIcon))
-	mux.HandleFunc("DELETE /api/server/icon", withAuth(serverHandler.DeleteIcon))
+	mux.HandleFunc("POST /api/auth/logout", withAuth(authHandler.Logout))
+	mux.HandleFunc("POST /api/auth/password", withAuth(authHandler.ChangePassword))
+	mux.HandleFunc("GET /api/auth/2fa", withAuth(authHandler.GetTwoFactorStatus))
+	mux.HandleFunc("POST /api/auth/2fa/enroll", withAuth(authHandler.EnrollTwoFactor))
+	mux.HandleFunc("POST /api/auth/2fa/confirm", withAuth(authHandler.ConfirmTwoFactor))
+	mux.HandleFunc("POST /api/auth/2fa/disable", withAuth(authHandler.DisableTwoFactor))
+	mux.HandleFunc("POST /api/auth/2fa/recovery-codes", withAuth(authHandler.RegenerateRecoveryCodes))
+	mux.HandleFunc("GET /api/auth/sessions", withAuth(authHandler.ListSessions))
+	mux.HandleFunc("DELETE /api/auth/sessions", withAuth(authHandler.RevokeOtherSessions))
+	mux.HandleFunc("DELETE /api/auth/sessions/{id}", withAuth(authHandler.RevokeSession))
+	mux.HandleFunc("GET /api/auth/tokens", withAuth(authHandler.ListAccessTokens))
+	mux.HandleFunc("POST /api/auth/tokens", withAuth(authHandler.CreateAccessToken))
+	mux.HandleFunc("DELETE /api/auth/tokens/{id}", withAuth(authHandler.DeleteAccessToken))
+
+	// Server settings (admin only)
+	mux.HandleFunc("PUT /api/server", withAdmin(serverHandler.UpdateInfo))
+	mux.HandleFunc("POST /api/server/icon", withAdmin(serverHandler.UploadIcon))
+	mux.HandleFunc("DELETE /api/server/icon", withAdmin(serverHandler.DeleteIcon))
+
+	// Admin
+	mux.HandleFunc("GET /api/admin/users", withAdmin(adminHandler.ListUsers))
+	mux.HandleFunc("PUT /api/admin/users/{id}/role", withAdmin(adminHandler.UpdateUserRole))
+	mux.HandleFunc("POST /api/admin/users/{id}/deactivate", withAdmin(adminHandler.DeactivateUser))
+	mux.HandleFunc("POST /api/admin/users/{id}/reactivate", withAdmin(adminHandler.ReactivateUser))
+	mux.HandleFunc("POST /api/admin/users/{id}/password-reset", withAdmin(adminHandler.ResetUserPassword))
+	mux.HandleFunc("POST /api/admin/users/{id}/unlock", withAdmin(adminHandler.UnlockUser))
+	mux.HandleFunc("GET /api/admin/security-events", withAdmin(adminHandler.ListSecurityEvents))
+	mux.HandleFunc("GET /api/admin/registration", withAdmin(adminHandler.GetRegistrationSettings))
+	mux.HandleFunc("PUT /api/admin/registration", withAdmin(adminHandler.UpdateRegistrationSettings))
+	mux.HandleFunc("GET /api/admin/invites", withAdmin(adminHandler.ListRegistrationInvites))
+	mux.HandleFunc("POST /api/admin/invites", withAdmin(adminHandler.CreateRegistrationInvite))
+	mux.HandleFunc("DELETE /api/admin/invites/{id}", withAdmin(adminHandler.RevokeRegistrationInvite))
+	mux.HandleFunc("POST /api/admin/profile-fields", withAdmin(adminHandler.CreateProfileField))
+	mux.HandleFunc("PUT /api/admin/profile-fields/{id}", withAdmin(adminHandler.UpdateProfileField))
+	mux.HandleFunc("DELETE /api/admin/profile-fields/{id}", withAdmin(adminHandler.DeleteProfileField))
+	mux.HandleFunc("GET /api/admin/onboarding", withAdmin(serverHandler.GetOnboarding))
+	mux.HandleFunc("PUT /api/admin/onboarding", withAdmin(serverHandler.UpdateOnboarding))
+	mux.HandleFunc("POST /api/admin/signing-keys/rotate", withAdmin(authHandler.RotateSigningKey))
+	mux.HandleFunc("GET /api/admin/password-policy", withAdmin(authHandler.GetPasswordPolicy))
+	mux.HandleFunc("PUT /api/admin/password-policy", withAdmin(authHandler.UpdatePasswordPolicy))
+	mux.HandleFunc("GET /api/admin/two-factor", withAdmin(authHandler.GetTwoFactorSettings))
+	mux.HandleFunc("PUT /api/admin/two-factor", withAdmin(authHandler.UpdateTwoFactorSettings))
+	mux.HandleFunc("GET /api/admin/oidc", withAdmin(oidcHandler.GetSettings))
+	mux.HandleFunc("PUT /api/admin/oidc", withAdmin(oidcHandler.UpdateSettings))
 
 	// Channels
 	mux.HandleFunc("GET /api/channels", withAuth(channelHandler.List))
 	mux.HandleFunc("GET /api/channels/public", withAuth(channelHandler.ListPublic))
+	mux.HandleFunc("GET /api/channels/archived", withAuth(channelHandler.ListArchived))
+	mux.HandleFunc("GET /api/channels/directory", withAuth(channelHandler.Directory))
 	mux.HandleFunc("POST /api/channels", withAuth(channelHandler.Create))
 	mux.HandleFunc("GET /api/channels/{id}", withAuth(channelHandler.Get))
 	mux.HandleFunc("PUT /api/channels/{id}", withAuth(channelHandler.Update))
+	mux.HandleFunc("GET /api/channels/{id}/history", withAuth(channelHandler.History))
 	mux.HandleFunc("POST /api/channels/{id}/join", withAuth(channelHandler.Join))
 	mux.HandleFunc("POST /api/channels/{id}/read", withAuth(channelHandler.MarkAsRead))
 	mux.HandleFunc("POST /api/channels/{id}/mute", withAuth(channelHandler.Mute))
 	mux.HandleFunc("POST /api/channels/{id}/unmute", withAuth(channelHandler.Unmute))
+	mux.HandleFunc("GET /api/channels/{id}/notifications", withAuth(channelHandler.GetNotificationSettings))
+	mux.HandleFunc("PUT /api/channels/{id}/notifications", withAuth(channelHandler.UpdateNotificationSettings))
 	mux.HandleFunc("POST /api/channels/{id}/leave", withAuth(channelHandler.Leave))
 	mux.HandleFunc("POST /api/channels/{id}/clear", withAuth(channelHandler.Clear))
+	mux.HandleFunc("POST /api/channels/{id}/archive", withAuth(channelHandler.Archive))
+	mux.HandleFunc("POST /api/channels/{id}/unarchive", withAuth(channelHandler.Unarchive))
 	mux.HandleFunc("GET /api/channels/{id}/members", withAuth(channelHandler.Members))
+	mux.HandleFunc("POST /api/channels/{id}/members", withAuth(channelHandler.AddMembers))
+	mux.HandleFunc("DELETE /api/channels/{id}/members/{userId}", withAuth(channelHandler.RemoveMember))
+	mux.HandleFunc("PUT /api/channels/{id}/members/{userId}/role", withAuth(channelHandler.UpdateMemberRole))
+	mux.HandleFunc("GET /api/channels/{id}/pins", withAuth(channelHandler.ListPins))
+	mux.HandleFunc("POST /api/channels/{id}/pins", withAuth(channelHandler.Pin))
+	mux.HandleFunc("DELETE /api/channels/{id}/pins/{messageId}", withAuth(channelHandler.Unpin))
+	mux.HandleFunc("GET /api/channels/{id}/invites", withAuth(channelHandler.ListInvites))
+	mux.HandleFunc("POST /api/channels/{id}/invites", withAuth(channelHandler.CreateInvite))
+	mux.HandleFunc("DELETE /api/channels/{id}/invites/{inviteId}", withAuth(channelHandler.RevokeInvite))
+	mux.HandleFunc("POST /api/invites/{token}/redeem", withAuth(channelHandler.RedeemInvite))
 	mux.HandleFunc("GET /api/channels/{id}/messages", withAuth(messageHandler.GetChannelMessages))
 	mux.HandleFunc("GET /api/channels/muted", withAuth(channelHandler.GetMuted))
 	mux.HandleFunc("POST /api/dm", withAuth(channelHandler.CreateDM))
+	mux.HandleFunc("POST /api/dm/{id}/members", withAuth(channelHandler.AddDMMembers))
+
+	// Sidebar sections
+	mux.HandleFunc("GET /api/sections", withAuth(sectionHandler.List))
+	mux.HandleFunc("POST /api/sections", withAuth(sectionHandler.Create))
+	mux.HandleFunc("PUT /api/sections/order", withAuth(sectionHandler.Reorder))
+	mux.HandleFunc("PUT /api/sections/{id}", withAuth(sectionHandler.Update))
+	mux.HandleFunc("DELETE /api/sections/{id}", withAuth(sectionHandler.Delete))
+	mux.HandleFunc("PUT /api/sections/{id}/channels", withAuth(sectionHandler.SetChannels))
 
 	// Messages
 	mux.HandleFunc("POST /api/messages", withAuth(messageHandler.Send))
 	mux.HandleFunc("DELETE /api/messages/{id}", withAuth(messageHandler.Delete))
 	mux.HandleFunc("GET /api/messages/{id}/thread", withAuth(messageHandler.GetThread))
 	mux.HandleFunc("POST /api/messages/{id}/reply", withAuth(messageHandler.Reply))
+	mux.HandleFunc("GET /api/messages/{id}/notifications", withAuth(messageHandler.GetThreadNotifications))
+	mux.HandleFunc("PUT /api/messages/{id}/notifications", withAuth(messageHandler.UpdateThreadNotifications))
 
 	// Text-to-Speech
 	mux.HandleFunc("POST /api/tts", withAuth(messageHandler.TextToSpeech))
@@ -113,8 +238,25 @@ func main() {
 	// Users
 	mux.HandleFunc("GET /api/users", withAuth(userHandler.List))
 	mux.HandleFunc("PUT /api/users/me", withAuth(userHandler.UpdateProfile))
+	mux.HandleFunc("PUT /api/users/me/profile", withAuth(userHandler.UpdateMyProfile))
 	mux.HandleFunc("PUT /api/users/me/status", withAuth(userHandler.UpdateStatus))
+	mux.HandleFunc("PUT /api/users/me/custom-status", withAuth(userHandler.SetCustomStatus))
+	mux.HandleFunc("DELETE /api/users/me/custom-status", withAuth(userHandler.ClearCustomStatus))
+	mux.HandleFunc("GET /api/users/me/dnd", withAuth(userHandler.GetDND))
+	mux.HandleFunc("PUT /api/users/me/dnd/schedule", withAuth(userHandler.UpdateDNDSchedule))
+	mux.HandleFunc("POST /api/users/me/dnd/snooze", withAuth(userHandler.SnoozeDND))
+	mux.HandleFunc("DELETE /api/users/me/dnd/snooze", withAuth(userHandler.EndDNDSnooze))
 	mux.HandleFunc("GET /api/users/me", withAuth(userHandler.GetMe))
+	mux.HandleFunc("DELETE /api/users/me", withAuth(accountHandler.DeleteAccount))
+	mux.HandleFunc("POST /api/users/me/export", withAuth(accountHandler.RequestExport))
+	mux.HandleFunc("GET /api/users/me/exports", withAuth(accountHandler.ListExports))
+	mux.HandleFunc("GET /api/users/me/exports/{id}/download", withAuth(accountHandler.DownloadExport))
+	mux.HandleFunc("GET /api/users/profile-fields", withAuth(userHandler.ListProfileFields))
+	mux.HandleFunc("GET /api/users/directory", withAuth(userHandler.Directory))
+	mux.HandleFunc("GET /api/users/autocomplete", withAuth(userHandler.Autocomplete))
+	mux.HandleFunc("GET /api/users/me/blocks", withAuth(userHandler.ListBlocks))
+	mux.HandleFunc("POST /api/users/{id}/block", withAuth(userHandler.Block))
+	mux.HandleFunc("POST /api/users/{id}/unblock", withAuth(userHandler.Unblock))
 	mux.HandleFunc("GET /api/users/{id}", withAuth(userHandler.Get))
 
 	// User Preferences
@@ -220,7 +362,7 @@ func main() {
 	mux.HandleFunc("POST /api/commands/execute", withAuth(commandHandler.Execute))
 	mux.HandleFunc("POST /api/commands/ai-generate", withAuth(commandHandler.AIGenerate))
 
-	// Git HTTP (for cloning/pushing app repos - uses HTTP Basic Auth with JWT token)
+	// Git HTTP (for cloning/pushing app repos - uses HTTP Basic Auth with an access token)
 	mux.HandleFunc("GET /git/{appID}/info/refs", gitHandler.InfoRefs)
 	mux.HandleFunc("POST /git/{appID}/git-upload-pack", gitHandler.UploadPack)
 	mux.HandleFunc("POST /git/{appID}/git-receive-pack", gitHandler.ReceivePack)
@@ -238,30 +380,75 @@ func main() {
 	log.Fatal(http.ListenAndServe(":"+port, handler))
 }
 
-// withAuth wraps a handler with authentication
-func withAuth(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header required", http.StatusUnauthorized)
-			return
-		}
-
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			http.Error(w, "Invalid authorization format", http.StatusUnauthorized)
-			return
-		}
-
-		claims, err := middleware.ValidateToken(tokenString)
-		if err != nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
+// requireAuth returns a wrapper that authenticates requests with an access token
+// whose session is still active
+func requireAuth(s *store.Store) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				http.Error(w, "Authorization header required", http.StatusUnauthorized)
+				return
+			}
+
+			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+			if tokenString == authHeader {
+				http.Error(w, "Invalid authorization format", http.StatusUnauthorized)
+				return
+			}
+
+			// Personal access tokens only reach the routes their scopes cover
+			if strings.HasPrefix(tokenString, models.AccessTokenPrefix) {
+				pat, err := s.AuthenticateAccessToken(tokenString)
+				if err != nil {
+					http.Error(w, "Invalid token", http.StatusUnauthorized)
+					return
+				}
+				scope := middleware.RequiredScope(r)
+				if scope == "" {
+					http.Error(w, "This endpoint can't be used with an access token", http.StatusForbidden)
+					return
+				}
+				if !pat.HasScope(scope) {
+					http.Error(w, "Access token is missing the "+scope+" scope", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(middleware.SetUserID(r.Context(), pat.UserID)))
+				return
+			}
+
+			claims, err := middleware.ValidateToken(tokenString)
+			if err != nil {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			// Revoked sessions are rejected straight away rather than when the token expires
+			if !s.IsSessionActive(claims.SessionID) {
+				http.Error(w, "Session has been revoked", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := r.Context()
+			ctx = middleware.SetUserID(ctx, claims.UserID)
+			ctx = middleware.SetSessionID(ctx, claims.SessionID)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		}
+	}
+}
 
-		ctx := r.Context()
-		ctx = middleware.SetUserID(ctx, claims.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+// requireAdmin returns a wrapper that authenticates the request and only lets
+// server owners and admins through
+func requireAdmin(s *store.Store, withAuth func(http.HandlerFunc) http.HandlerFunc) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return withAuth(func(w http.ResponseWriter, r *http.Request) {
+			user, err := s.GetUserByID(middleware.GetUserID(r))
+			if err != nil || !user.IsAdmin() {
+				http.Error(w, "Admin access required", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
 	}
 }
 
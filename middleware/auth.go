@@ -2,47 +2,110 @@ package middleware
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"smack-server/models"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var JWTSecret = []byte("smack-secret-key-change-in-production")
+// AccessTokenTTL is how long an access token is valid; clients use their
+// refresh token to get a new one
+const AccessTokenTTL = 15 * time.Minute
 
 type contextKey string
 
-const UserIDKey contextKey = "userID"
+const (
+	UserIDKey    contextKey = "userID"
+	SessionIDKey contextKey = "sessionID"
+)
 
 type Claims struct {
-	UserID string `json:"user_id"`
+	UserID    string `json:"user_id"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID string) (string, error) {
+// keyring holds the key new tokens are signed with and every key that may still
+// verify a token. It's loaded from the store at startup and after a rotation.
+var keyring struct {
+	sync.RWMutex
+	active *models.SigningKey
+	keys   map[string][]byte
+}
+
+// SetSigningKeys replaces the keyring. The first unretired key signs new tokens;
+// all keys are accepted when verifying.
+func SetSigningKeys(keys []models.SigningKey) error {
+	var active *models.SigningKey
+	byID := make(map[string][]byte, len(keys))
+	for i := range keys {
+		byID[keys[i].ID] = keys[i].Secret
+		if active == nil && keys[i].RetiredAt == nil {
+			active = &keys[i]
+		}
+	}
+	if active == nil {
+		return fmt.Errorf("no active signing key")
+	}
+
+	keyring.Lock()
+	keyring.active = active
+	keyring.keys = byID
+	keyring.Unlock()
+	return nil
+}
+
+// GenerateToken issues an access token for a session, returning it with its expiry
+func GenerateToken(userID, sessionID string) (string, time.Time, error) {
+	keyring.RLock()
+	key := keyring.active
+	keyring.RUnlock()
+	if key == nil {
+		return "", time.Time{}, fmt.Errorf("no signing key loaded")
+	}
+
+	expiresAt := time.Now().Add(AccessTokenTTL)
 	claims := &Claims{
-		UserID: userID,
+		UserID:    userID,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(JWTSecret)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.Secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
 }
 
+// ValidateToken checks an access token's signature and expiry. It doesn't check
+// whether the session has been revoked; callers with a store should do that.
 func ValidateToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return JWTSecret, nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		keyring.RLock()
+		secret, ok := keyring.keys[kid]
+		keyring.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid && claims.SessionID != "" {
 		return claims, nil
 	}
 
@@ -70,6 +133,7 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		}
 
 		ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+		ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -84,3 +148,14 @@ func GetUserID(r *http.Request) string {
 func SetUserID(ctx context.Context, userID string) context.Context {
 	return context.WithValue(ctx, UserIDKey, userID)
 }
+
+func GetSessionID(r *http.Request) string {
+	if sessionID, ok := r.Context().Value(SessionIDKey).(string); ok {
+		return sessionID
+	}
+	return ""
+}
+
+func SetSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, SessionIDKey, sessionID)
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"smack-server/models"
+	"strings"
+)
+
+// scopeResources maps the first path segment under /api to the resource whose
+// scopes cover it. Routes not listed here can't be used with access tokens.
+var scopeResources = map[string]string{
+	"channels":  "channels",
+	"dm":        "channels",
+	"sections":  "channels",
+	"messages":  "messages",
+	"reactions": "messages",
+	"users":     "users",
+	"bots":      "users",
+	"boards":    "boards",
+	"cards":     "boards",
+	"columns":   "boards",
+	"labels":    "boards",
+	"comments":  "boards",
+	"reminders": "reminders",
+	"files":     "files",
+	"apps":      "apps",
+}
+
+// RequiredScope returns the access token scope needed for a request, or "" if the
+// route isn't available to access tokens (sign-in, session, admin, data export and
+// account deletion routes)
+func RequiredScope(r *http.Request) string {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/")
+	resource, ok := scopeResources[parts[0]]
+	if !ok {
+		return ""
+	}
+
+	// Exporting everything about an account, or deleting it, needs a signed-in session
+	if parts[0] == "users" && len(parts) >= 2 && parts[1] == "me" {
+		if len(parts) == 2 && r.Method == http.MethodDelete {
+			return ""
+		}
+		if len(parts) >= 3 && (parts[2] == "export" || parts[2] == "exports") {
+			return ""
+		}
+	}
+
+	// Message routes nested under a channel, and running app queries, have their own scopes
+	if resource == "channels" && len(parts) >= 3 {
+		switch parts[2] {
+		case "messages", "history", "pins":
+			resource = "messages"
+		}
+	}
+	if resource == "apps" && len(parts) >= 3 && parts[2] == "query" {
+		return models.ScopeAppsQuery
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return resource + ":read"
+	}
+	return resource + ":write"
+}
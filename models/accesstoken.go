@@ -0,0 +1,94 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// AccessTokenPrefix starts every personal access token so they're easy to tell apart
+// from session tokens and to spot in leaked code
+const AccessTokenPrefix = "smk_"
+
+// Access token scopes. Each one covers a group of API routes; see middleware.RequiredScope.
+const (
+	ScopeChannelsRead   = "channels:read"
+	ScopeChannelsWrite  = "channels:write"
+	ScopeMessagesRead   = "messages:read"
+	ScopeMessagesWrite  = "messages:write"
+	ScopeUsersRead      = "users:read"
+	ScopeUsersWrite     = "users:write"
+	ScopeBoardsRead     = "boards:read"
+	ScopeBoardsWrite    = "boards:write"
+	ScopeRemindersRead  = "reminders:read"
+	ScopeRemindersWrite = "reminders:write"
+	ScopeFilesRead      = "files:read"
+	ScopeFilesWrite     = "files:write"
+	ScopeAppsRead       = "apps:read"
+	ScopeAppsWrite      = "apps:write"
+	ScopeAppsQuery      = "apps:query"
+	ScopeGitPull        = "git:pull"
+	ScopeGitPush        = "git:push"
+)
+
+// AccessTokenScopes lists every scope a token can be given
+var AccessTokenScopes = []string{
+	ScopeChannelsRead, ScopeChannelsWrite,
+	ScopeMessagesRead, ScopeMessagesWrite,
+	ScopeUsersRead, ScopeUsersWrite,
+	ScopeBoardsRead, ScopeBoardsWrite,
+	ScopeRemindersRead, ScopeRemindersWrite,
+	ScopeFilesRead, ScopeFilesWrite,
+	ScopeAppsRead, ScopeAppsWrite, ScopeAppsQuery,
+	ScopeGitPull, ScopeGitPush,
+}
+
+func IsValidAccessTokenScope(scope string) bool {
+	for _, s := range AccessTokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// PersonalAccessToken is a long-lived token for scripts and integrations. Only a
+// hash is stored; the token itself is shown once when it's created.
+type PersonalAccessToken struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Name        string     `json:"name"`
+	TokenPrefix string     `json:"token_prefix"` // first characters of the token, to help recognise it
+	Scopes      []string   `json:"scopes"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// HasScope reports whether the token grants a scope. Write scopes include read access
+// to the same resource, and git:push includes git:pull.
+func (t *PersonalAccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+		if scope == ScopeGitPull && s == ScopeGitPush {
+			return true
+		}
+		if resource, ok := strings.CutSuffix(scope, ":read"); ok && s == resource+":write" {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateAccessTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty"` // 0 means the token doesn't expire
+}
+
+// CreateAccessTokenResponse includes the token itself, which can't be retrieved again
+type CreateAccessTokenResponse struct {
+	PersonalAccessToken
+	Token string `json:"token"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// UserBlock is someone the current user has blocked
+type UserBlock struct {
+	User      UserResponse `json:"user"`
+	BlockedAt time.Time    `json:"blocked_at"`
+}
+
+// Collapse hides a message's content from someone who blocked its author, leaving
+// a placeholder clients can show collapsed
+func (m *MessageWithUser) Collapse() {
+	m.Content = ""
+	m.HTMLContent = nil
+	m.WidgetSize = nil
+	m.Blocked = true
+}
@@ -3,20 +3,122 @@ package models
 import "time"
 
 type Channel struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description,omitempty"`
-	IsDirect    bool      `json:"is_direct"`
-	CreatedBy   string    `json:"created_by"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Topic       string     `json:"topic,omitempty"`
+	IsDirect    bool       `json:"is_direct"`
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ArchivedAt  *time.Time `json:"archived_at,omitempty"`
+
+	// JoinLeaveMessages controls whether membership changes post a system message
+	JoinLeaveMessages bool `json:"join_leave_messages"`
 }
 
 type ChannelMember struct {
 	ChannelID string    `json:"channel_id"`
 	UserID    string    `json:"user_id"`
+	Role      string    `json:"role"` // "owner", "admin", "member"
 	JoinedAt  time.Time `json:"joined_at"`
 }
 
+// ChannelMemberResponse is a channel member's user info along with their channel role
+type ChannelMemberResponse struct {
+	UserResponse
+	Role string `json:"role"`
+}
+
+// Channel roles
+const (
+	ChannelRoleOwner  = "owner"
+	ChannelRoleAdmin  = "admin"
+	ChannelRoleMember = "member"
+)
+
+// Channel actions that require a minimum channel role
+const (
+	ChannelActionUpdate        = "update"
+	ChannelActionRename        = "rename"
+	ChannelActionClear         = "clear"
+	ChannelActionPin           = "pin"
+	ChannelActionKick          = "kick"
+	ChannelActionAddMembers    = "add_members"
+	ChannelActionCreateWebhook = "create_webhook"
+	ChannelActionManageRoles   = "manage_roles"
+	ChannelActionArchive       = "archive"
+	ChannelActionSetTopic      = "set_topic"
+	ChannelActionInvite        = "invite"
+	ChannelActionManageInvites = "manage_invites"
+)
+
+// channelPermissions maps each channel action to the roles allowed to perform it
+var channelPermissions = map[string][]string{
+	ChannelActionUpdate:        {ChannelRoleOwner, ChannelRoleAdmin},
+	ChannelActionRename:        {ChannelRoleOwner, ChannelRoleAdmin},
+	ChannelActionClear:         {ChannelRoleOwner},
+	ChannelActionPin:           {ChannelRoleOwner, ChannelRoleAdmin},
+	ChannelActionKick:          {ChannelRoleOwner, ChannelRoleAdmin},
+	ChannelActionAddMembers:    {ChannelRoleOwner, ChannelRoleAdmin},
+	ChannelActionCreateWebhook: {ChannelRoleOwner, ChannelRoleAdmin},
+	ChannelActionManageRoles:   {ChannelRoleOwner},
+	ChannelActionArchive:       {ChannelRoleOwner, ChannelRoleAdmin},
+	ChannelActionSetTopic:      {ChannelRoleOwner, ChannelRoleAdmin, ChannelRoleMember},
+	ChannelActionInvite:        {ChannelRoleOwner, ChannelRoleAdmin, ChannelRoleMember},
+	ChannelActionManageInvites: {ChannelRoleOwner, ChannelRoleAdmin},
+}
+
+// ChannelRoleCan reports whether a member with the given role may perform the action
+func ChannelRoleCan(role, action string) bool {
+	for _, allowed := range channelPermissions[action] {
+		if role == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidChannelRole reports whether role is a known channel role
+func IsValidChannelRole(role string) bool {
+	return role == ChannelRoleOwner || role == ChannelRoleAdmin || role == ChannelRoleMember
+}
+
+// PinnedMessage is a message pinned to a channel
+type PinnedMessage struct {
+	MessageWithUser
+	PinnedBy string    `json:"pinned_by"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// DirectoryChannel is a channel as listed in the channel directory
+type DirectoryChannel struct {
+	Channel
+	MemberCount    int        `json:"member_count"`
+	LastActivityAt *time.Time `json:"last_activity_at,omitempty"`
+	IsMember       bool       `json:"is_member"`
+}
+
+// Channel directory sort orders
+const (
+	DirectorySortName     = "name"
+	DirectorySortMembers  = "members"
+	DirectorySortActivity = "activity"
+	DirectorySortCreated  = "created"
+)
+
+type ChannelDirectoryQuery struct {
+	Query           string
+	Sort            string
+	Cursor          string
+	Limit           int
+	IncludeArchived bool
+}
+
+type ChannelDirectoryPage struct {
+	Channels   []DirectoryChannel `json:"channels"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
 type CreateChannelRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description,omitempty"`
@@ -25,6 +127,47 @@ type CreateChannelRequest struct {
 type UpdateChannelRequest struct {
 	Name        string  `json:"name,omitempty"`
 	Description *string `json:"description,omitempty"`
+	Topic       *string `json:"topic,omitempty"`
+
+	JoinLeaveMessages *bool `json:"join_leave_messages,omitempty"`
+}
+
+type AddChannelMembersRequest struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+// MemberEventPayload is sent with member_joined and member_left events
+type MemberEventPayload struct {
+	ChannelID string        `json:"channel_id"`
+	User      UserResponse  `json:"user"`
+	Actor     *UserResponse `json:"actor,omitempty"` // set when someone else added or removed the user
+}
+
+// ChannelChange is an entry in a channel's metadata history
+type ChannelChange struct {
+	ID        string        `json:"id"`
+	ChannelID string        `json:"channel_id"`
+	UserID    string        `json:"user_id"`
+	User      *UserResponse `json:"user,omitempty"`
+	Field     string        `json:"field"`
+	OldValue  string        `json:"old_value"`
+	NewValue  string        `json:"new_value"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// Channel metadata fields tracked in the change history
+const (
+	ChannelFieldName        = "name"
+	ChannelFieldDescription = "description"
+	ChannelFieldTopic       = "topic"
+)
+
+type UpdateChannelMemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type PinMessageRequest struct {
+	MessageID string `json:"message_id"`
 }
 
 type ChannelWithMembers struct {
@@ -34,11 +177,30 @@ type ChannelWithMembers struct {
 }
 
 type ChannelWithUnread struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description,omitempty"`
-	IsDirect    bool      `json:"is_direct"`
-	CreatedBy   string    `json:"created_by"`
-	CreatedAt   time.Time `json:"created_at"`
-	UnreadCount int       `json:"unread_count"`
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Topic       string         `json:"topic,omitempty"`
+	IsDirect    bool           `json:"is_direct"`
+	CreatedBy   string         `json:"created_by"`
+	CreatedAt   time.Time      `json:"created_at"`
+	ArchivedAt  *time.Time     `json:"archived_at,omitempty"`
+	UnreadCount int            `json:"unread_count"`
+	Members     []UserResponse `json:"members,omitempty"`
+
+	// Sidebar placement for the requesting user; unset when the channel isn't in a section
+	SectionID       *string `json:"section_id,omitempty"`
+	SectionPosition *int    `json:"section_position,omitempty"`
+}
+
+// MaxGroupDMMembers is the most people, including the creator, a direct message can have
+const MaxGroupDMMembers = 9
+
+type CreateDMRequest struct {
+	UserID  string   `json:"user_id,omitempty"`
+	UserIDs []string `json:"user_ids,omitempty"`
+}
+
+type AddDMMembersRequest struct {
+	UserIDs []string `json:"user_ids"`
 }
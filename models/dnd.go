@@ -0,0 +1,145 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DNDSchedule is a recurring do-not-disturb window, such as weekdays 18:00 to 09:00.
+// Days are the days the window starts on (0 is Sunday); a window that ends before
+// it starts runs overnight into the next day.
+type DNDSchedule struct {
+	Enabled  bool   `json:"enabled"`
+	Days     []int  `json:"days"`
+	Start    string `json:"start"`              // "HH:MM"
+	End      string `json:"end"`                // "HH:MM"
+	Timezone string `json:"timezone,omitempty"` // defaults to the user's profile timezone
+}
+
+// Validate checks the schedule's days, times and timezone
+func (s *DNDSchedule) Validate() error {
+	for _, day := range s.Days {
+		if day < 0 || day > 6 {
+			return fmt.Errorf("days must be between 0 (Sunday) and 6 (Saturday)")
+		}
+	}
+	start, err := parseClock(s.Start)
+	if err != nil {
+		return fmt.Errorf("invalid start time")
+	}
+	end, err := parseClock(s.End)
+	if err != nil {
+		return fmt.Errorf("invalid end time")
+	}
+	if start == end {
+		return fmt.Errorf("start and end must be different")
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone")
+	}
+	if s.Enabled && len(s.Days) == 0 {
+		return fmt.Errorf("at least one day is required")
+	}
+	return nil
+}
+
+// ActiveAt reports whether t falls inside the schedule
+func (s *DNDSchedule) ActiveAt(t time.Time) bool {
+	if !s.Enabled {
+		return false
+	}
+	start, err := parseClock(s.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(s.End)
+	if err != nil {
+		return false
+	}
+	if loc, err := time.LoadLocation(s.Timezone); err == nil {
+		t = t.In(loc)
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	today := int(t.Weekday())
+	yesterday := (today + 6) % 7
+
+	if start < end {
+		return s.hasDay(today) && minute >= start && minute < end
+	}
+	return (s.hasDay(today) && minute >= start) || (s.hasDay(yesterday) && minute < end)
+}
+
+func (s *DNDSchedule) hasDay(day int) bool {
+	for _, d := range s.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock turns "HH:MM" into minutes after midnight
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// DNDSettings are a user's do-not-disturb schedule and snooze
+type DNDSettings struct {
+	Schedule    *DNDSchedule `json:"schedule,omitempty"`
+	SnoozeUntil *time.Time   `json:"snooze_until,omitempty"`
+	ManualDND   bool         `json:"-"` // the user's status is set to dnd
+}
+
+// ActiveAt reports whether do not disturb is on at t, from the dnd status, a snooze
+// or the schedule
+func (s *DNDSettings) ActiveAt(t time.Time) bool {
+	if s.ManualDND {
+		return true
+	}
+	if s.SnoozeUntil != nil && t.Before(*s.SnoozeUntil) {
+		return true
+	}
+	return s.Schedule != nil && s.Schedule.ActiveAt(t)
+}
+
+// DNDStatus is returned by GET /api/users/me/dnd
+type DNDStatus struct {
+	Active      bool         `json:"active"`
+	Schedule    *DNDSchedule `json:"schedule,omitempty"`
+	SnoozeUntil *time.Time   `json:"snooze_until,omitempty"`
+}
+
+type SnoozeDNDRequest struct {
+	Hours int `json:"hours"`
+}
+
+// MaxDNDSnoozeHours is the longest snooze allowed
+const MaxDNDSnoozeHours = 24
+
+// HeldNotification is a push held back while the user had do not disturb on
+type HeldNotification struct {
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// DNDSummary is sent when do not disturb ends, with everything held back during it
+type DNDSummary struct {
+	Mentions      int                `json:"mentions"`
+	Notifications int                `json:"notifications"`
+	Reminders     int                `json:"reminders"`
+	Held          []HeldNotification `json:"held"`
+}
+
+// HoldsDuringDND reports whether pushes of this type are held back during do not disturb
+func HoldsDuringDND(wsType string) bool {
+	return wsType == WSTypeMention || wsType == WSTypeNotification || wsType == WSTypeReminder
+}
+
+const WSTypeDNDSummary = "dnd_summary"
@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// Data export statuses
+const (
+	DataExportPending = "pending"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+)
+
+// DataExportTTL is how long a finished export can be downloaded before it's removed
+const DataExportTTL = 7 * 24 * time.Hour
+
+// DataExport is a zip of everything a user has stored on the server, built in the
+// background after they ask for it
+type DataExport struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Status      string     `json:"status"`
+	Size        int64      `json:"size,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// ExportedMessage is a message the user wrote, with the channel it was posted in
+type ExportedMessage struct {
+	Message
+	ChannelName string `json:"channel_name"`
+	IsDirect    bool   `json:"is_direct"`
+}
+
+// KanbanActivity is the user's kanban history: cards they created or are assigned
+// to, and comments they wrote
+type KanbanActivity struct {
+	CardsCreated  []KanbanCard    `json:"cards_created"`
+	CardsAssigned []KanbanCard    `json:"cards_assigned"`
+	Comments      []KanbanComment `json:"comments"`
+}
+
+// DeleteAccountRequest confirms account deletion with the user's password or a
+// two-factor code. Neither is needed right after signing in, so people who sign in
+// through single sign-on and don't know their password can sign in again instead.
+type DeleteAccountRequest struct {
+	Password string `json:"password,omitempty"`
+	Code     string `json:"code,omitempty"` // TOTP or recovery code
+}
+
+// RecentSignInWindow is how long after signing in an account can be deleted without
+// a password or two-factor code
+const RecentSignInWindow = 10 * time.Minute
+
+// DeletedUserDisplayName is shown in place of the name of a user who deleted their account
+const DeletedUserDisplayName = "Deleted user"
+
+const WSTypeDataExportReady = "data_export_ready"
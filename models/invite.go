@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// ChannelInvite is a shareable link that lets people join a channel
+type ChannelInvite struct {
+	ID        string     `json:"id"`
+	ChannelID string     `json:"channel_id"`
+	Token     string     `json:"token"`
+	CreatedBy string     `json:"created_by"`
+	Role      string     `json:"role"`
+	MaxUses   *int       `json:"max_uses,omitempty"`
+	UseCount  int        `json:"use_count"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// IsUsable reports whether the invite can still be redeemed
+func (i *ChannelInvite) IsUsable() bool {
+	if i.RevokedAt != nil {
+		return false
+	}
+	if i.ExpiresAt != nil && time.Now().After(*i.ExpiresAt) {
+		return false
+	}
+	return i.MaxUses == nil || i.UseCount < *i.MaxUses
+}
+
+// ChannelInviteUse records who redeemed an invite
+type ChannelInviteUse struct {
+	User   UserResponse `json:"user"`
+	UsedAt time.Time    `json:"used_at"`
+}
+
+// ChannelInviteWithUses is an invite along with everyone who has used it
+type ChannelInviteWithUses struct {
+	ChannelInvite
+	Uses []ChannelInviteUse `json:"uses"`
+}
+
+type CreateInviteRequest struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxUses   *int       `json:"max_uses,omitempty"`
+	Role      string     `json:"role,omitempty"` // defaults to "member"
+}
+
+// InvitePreview is what someone holding an invite link can see before redeeming it
+type InvitePreview struct {
+	Token              string     `json:"token"`
+	ChannelID          string     `json:"channel_id"`
+	ChannelName        string     `json:"channel_name"`
+	ChannelDescription string     `json:"channel_description,omitempty"`
+	MemberCount        int        `json:"member_count"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`
+	Valid              bool       `json:"valid"`
+}
@@ -10,14 +10,19 @@ type Message struct {
 	HTMLContent *string   `json:"html_content,omitempty"`
 	WidgetSize  *string   `json:"widget_size,omitempty"`
 	ThreadID    *string   `json:"thread_id,omitempty"`
+	Type        string    `json:"type,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// MessageTypeSystem marks automated notices such as channel renames
+const MessageTypeSystem = "system"
+
 type MessageWithUser struct {
 	Message
 	User        UserResponse `json:"user"`
 	ReplyCount  int          `json:"reply_count"`
 	LatestReply *time.Time   `json:"latest_reply,omitempty"`
+	Blocked     bool         `json:"blocked,omitempty"` // the viewer blocked the author; content is hidden
 }
 
 type SendMessageRequest struct {
@@ -39,6 +44,8 @@ const (
 	WSTypeUserOffline       = "user_offline"
 	WSTypeTyping            = "typing"
 	WSTypeChannelUpdate     = "channel_update"
+	WSTypeMemberJoined      = "member_joined"
+	WSTypeMemberLeft        = "member_left"
 	WSTypeReactionUpdate    = "reaction_update"
 	WSTypeMessageStreamStart = "message_stream_start"
 	WSTypeMessageStreamDelta = "message_stream_delta"
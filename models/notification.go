@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// Notification levels for a channel or thread
+const (
+	NotificationLevelAll      = "all"
+	NotificationLevelMentions = "mentions"
+	NotificationLevelNothing  = "nothing"
+)
+
+// IsValidNotificationLevel reports whether level is a known notification level
+func IsValidNotificationLevel(level string) bool {
+	return level == NotificationLevelAll || level == NotificationLevelMentions || level == NotificationLevelNothing
+}
+
+// ChannelNotificationSettings is a user's notification preference for a channel
+type ChannelNotificationSettings struct {
+	ChannelID  string     `json:"channel_id"`
+	Level      string     `json:"level"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+}
+
+// UpdateNotificationSettingsRequest sets a channel's level. MutedUntil silences the
+// channel until that time, after which the level applies again.
+type UpdateNotificationSettingsRequest struct {
+	Level      string     `json:"level,omitempty"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+}
+
+// ThreadNotificationSettings overrides the channel level for replies in one thread
+type ThreadNotificationSettings struct {
+	MessageID string `json:"message_id"`
+	Level     string `json:"level"` // empty when the thread follows the channel setting
+}
+
+type UpdateThreadNotificationRequest struct {
+	Level string `json:"level"` // empty to clear the override
+}
+
+// NotificationPayload is sent with mention and notification events
+type NotificationPayload struct {
+	ChannelID string          `json:"channel_id"`
+	ThreadID  *string         `json:"thread_id,omitempty"`
+	Message   MessageWithUser `json:"message"`
+}
+
+const (
+	WSTypeMention      = "mention"
+	WSTypeNotification = "notification"
+)
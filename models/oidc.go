@@ -0,0 +1,73 @@
+package models
+
+import "time"
+
+// OIDCSettings configures single sign-on through an OpenID Connect provider
+type OIDCSettings struct {
+	Enabled      bool     `json:"enabled"`
+	IssuerURL    string   `json:"issuer_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"` // never returned; leave empty on update to keep the current one
+	RedirectURL  string   `json:"redirect_url"`            // must point at /api/auth/oidc/callback on this server
+	Scopes       []string `json:"scopes"`
+	ButtonLabel  string   `json:"button_label,omitempty"`
+
+	// AutoCreateUsers creates an account the first time someone signs in through the provider
+	AutoCreateUsers bool `json:"auto_create_users"`
+	// LinkByEmail signs people into an existing account with the same verified email.
+	// If the account's email was never verified, its password has to be confirmed first.
+	LinkByEmail bool `json:"link_by_email"`
+	// DisablePasswordLogin turns off password login and registration for everyone but
+	// server owners, who keep it so a broken provider can't lock them out
+	DisablePasswordLogin bool `json:"disable_password_login"`
+
+	HasClientSecret bool `json:"has_client_secret"`
+}
+
+// DefaultOIDCScopes are requested when none are configured
+var DefaultOIDCScopes = []string{"openid", "email", "profile"}
+
+// OIDCLoginState is an authorization request waiting for the provider to redirect back
+type OIDCLoginState struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	DeviceName   string
+	ExpiresAt    time.Time
+}
+
+// OIDCPendingLink is a provider account waiting for the owner of the existing account
+// with the same, unverified, email to confirm their password before they're linked
+type OIDCPendingLink struct {
+	UserID     string
+	Issuer     string
+	Subject    string
+	Email      string
+	DeviceName string
+	ExpiresAt  time.Time
+}
+
+// OIDCLinkRequiredResponse is returned by the callback instead of signing in when the
+// account has to be confirmed with POST /api/auth/oidc/link
+type OIDCLinkRequiredResponse struct {
+	LinkRequired bool      `json:"link_required"`
+	LinkToken    string    `json:"link_token"`
+	Username     string    `json:"username"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type OIDCLinkRequest struct {
+	LinkToken string `json:"link_token"`
+	Password  string `json:"password"`
+}
+
+// AuthMethods tells login screens which ways of signing in are available
+type AuthMethods struct {
+	PasswordLogin bool           `json:"password_login"`
+	OIDC          *OIDCLoginInfo `json:"oidc,omitempty"`
+}
+
+type OIDCLoginInfo struct {
+	ButtonLabel string `json:"button_label"`
+	LoginURL    string `json:"login_url"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// OnboardingSettings controls what a newly registered user is set up with
+type OnboardingSettings struct {
+	DefaultChannels []string `json:"default_channels"` // channel IDs new users auto-join
+	WelcomeMessage  string   `json:"welcome_message"`  // Markdown sent from Smackbot; {{display_name}} and {{username}} are filled in
+	StarterBoards   []string `json:"starter_boards"`   // kanban board IDs new users are added to
+	StarterApps     []string `json:"starter_apps"`     // app IDs new users are added to
+}
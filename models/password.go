@@ -0,0 +1,73 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// PasswordPolicy is the set of strength rules new passwords must meet
+type PasswordPolicy struct {
+	MinLength        int  `json:"min_length"`
+	RequireUppercase bool `json:"require_uppercase"`
+	RequireLowercase bool `json:"require_lowercase"`
+	RequireNumber    bool `json:"require_number"`
+	RequireSymbol    bool `json:"require_symbol"`
+	DisallowUsername bool `json:"disallow_username"` // reject passwords that contain the username
+}
+
+// MaxPasswordLength is the longest password accepted; bcrypt ignores anything past 72 bytes
+const MaxPasswordLength = 72
+
+// DefaultPasswordPolicy is used until an admin configures one
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{MinLength: 6}
+}
+
+// Check returns an error describing the first rule the password breaks
+func (p PasswordPolicy) Check(password, username string) error {
+	if len(password) < p.MinLength {
+		return fmt.Errorf("Password must be at least %d characters", p.MinLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return fmt.Errorf("Password must be at most %d bytes", MaxPasswordLength)
+	}
+
+	var hasUpper, hasLower, hasNumber, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasNumber = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	switch {
+	case p.RequireUppercase && !hasUpper:
+		return fmt.Errorf("Password must contain an uppercase letter")
+	case p.RequireLowercase && !hasLower:
+		return fmt.Errorf("Password must contain a lowercase letter")
+	case p.RequireNumber && !hasNumber:
+		return fmt.Errorf("Password must contain a number")
+	case p.RequireSymbol && !hasSymbol:
+		return fmt.Errorf("Password must contain a symbol")
+	case p.DisallowUsername && username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)):
+		return fmt.Errorf("Password must not contain your username")
+	}
+	return nil
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
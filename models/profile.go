@@ -0,0 +1,97 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Profile field types
+const (
+	ProfileFieldText   = "text"
+	ProfileFieldLink   = "link"
+	ProfileFieldDate   = "date" // YYYY-MM-DD
+	ProfileFieldSelect = "select"
+)
+
+func IsValidProfileFieldType(fieldType string) bool {
+	return fieldType == ProfileFieldText || fieldType == ProfileFieldLink || fieldType == ProfileFieldDate || fieldType == ProfileFieldSelect
+}
+
+// Profile field visibility decides who can see a user's value
+const (
+	ProfileFieldVisibilityEveryone = "everyone"
+	ProfileFieldVisibilityAdmins   = "admins" // admins and the user themselves
+)
+
+// ProfileField is a custom profile field defined by an admin
+type ProfileField struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Options    []string  `json:"options,omitempty"` // choices for select fields
+	Visibility string    `json:"visibility"`
+	Position   int       `json:"position"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ValidateValue checks a user's value against the field's type
+func (f *ProfileField) ValidateValue(value string) error {
+	if len([]rune(value)) > MaxProfileFieldLength {
+		return fmt.Errorf("%s is too long", f.Name)
+	}
+	if value == "" {
+		return nil
+	}
+	switch f.Type {
+	case ProfileFieldLink:
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s must be an http or https link", f.Name)
+		}
+	case ProfileFieldDate:
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return fmt.Errorf("%s must be a date like 2024-01-31", f.Name)
+		}
+	case ProfileFieldSelect:
+		for _, option := range f.Options {
+			if value == option {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s must be one of its options", f.Name)
+	}
+	return nil
+}
+
+type ProfileFieldRequest struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Options    []string `json:"options,omitempty"`
+	Visibility string   `json:"visibility,omitempty"` // defaults to everyone
+	Position   int      `json:"position"`
+}
+
+// ProfileFieldValue is a user's value for a custom profile field
+type ProfileFieldValue struct {
+	FieldID    string `json:"field_id"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	Visibility string `json:"visibility"`
+}
+
+// UpdateUserProfileRequest edits the current user's profile. Fields that are left
+// out aren't changed; custom field values are keyed by field ID, and an empty
+// value clears one.
+type UpdateUserProfileRequest struct {
+	DisplayName *string           `json:"display_name,omitempty"`
+	Title       *string           `json:"title,omitempty"`
+	Pronouns    *string           `json:"pronouns,omitempty"`
+	Phone       *string           `json:"phone,omitempty"`
+	Timezone    *string           `json:"timezone,omitempty"`
+	Fields      map[string]string `json:"fields,omitempty"`
+}
+
+// MaxProfileFieldLength is the longest value allowed for profile text
+const MaxProfileFieldLength = 200
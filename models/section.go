@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// ChannelSection is a user-defined group of channels in the sidebar
+type ChannelSection struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	Name       string    `json:"name"`
+	Position   int       `json:"position"`
+	Collapsed  bool      `json:"collapsed"`
+	ChannelIDs []string  `json:"channel_ids"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type CreateSectionRequest struct {
+	Name string `json:"name"`
+}
+
+type UpdateSectionRequest struct {
+	Name      *string `json:"name,omitempty"`
+	Collapsed *bool   `json:"collapsed,omitempty"`
+}
+
+type ReorderSectionsRequest struct {
+	SectionIDs []string `json:"section_ids"`
+}
+
+// SetSectionChannelsRequest lists a section's channels in display order
+type SetSectionChannelsRequest struct {
+	ChannelIDs []string `json:"channel_ids"`
+}
+
+const (
+	WSTypeSectionsUpdated = "sections_updated"
+)
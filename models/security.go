@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// LoginThrottle counts recent failed logins for an account or an IP address
+type LoginThrottle struct {
+	Key          string
+	Failures     int
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
+
+// LoginThrottlePolicy decides how long someone has to wait after failed logins.
+// After FreeAttempts failures each further attempt must wait twice as long as the
+// last, up to MaxDelay. With LockoutAfter set, that many failures lock the key
+// for LockoutDuration.
+type LoginThrottlePolicy struct {
+	FreeAttempts    int
+	MaxDelay        time.Duration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+}
+
+// LoginFailureWindow is how long failures are remembered after the most recent one
+const LoginFailureWindow = time.Hour
+
+var (
+	AccountLoginPolicy = LoginThrottlePolicy{FreeAttempts: 3, MaxDelay: 5 * time.Minute, LockoutAfter: 10, LockoutDuration: 30 * time.Minute}
+	IPLoginPolicy      = LoginThrottlePolicy{FreeAttempts: 10, MaxDelay: 15 * time.Minute}
+)
+
+// RetryAfter returns how long to wait before another attempt is allowed, or 0
+func (p LoginThrottlePolicy) RetryAfter(t *LoginThrottle, now time.Time) time.Duration {
+	if t == nil {
+		return 0
+	}
+	if t.LockedUntil != nil && now.Before(*t.LockedUntil) {
+		return t.LockedUntil.Sub(now)
+	}
+	if t.Failures < p.FreeAttempts || now.Sub(t.LastFailedAt) > LoginFailureWindow {
+		return 0
+	}
+
+	delay := p.MaxDelay
+	if shift := t.Failures - p.FreeAttempts; shift < 30 {
+		delay = min(time.Second<<shift, p.MaxDelay)
+	}
+	if wait := t.LastFailedAt.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Security event types
+const (
+	SecurityEventLoginFailed     = "login_failed"
+	SecurityEventLoginBlocked    = "login_blocked" // attempt refused during backoff or lockout
+	SecurityEventAccountLocked   = "account_locked"
+	SecurityEventAccountUnlocked = "account_unlocked"
+	SecurityEventAccountDeleted  = "account_deleted"
+)
+
+// SecurityEvent is an entry in the security log
+type SecurityEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	UserID    string    `json:"user_id,omitempty"`
+	ActorID   string    `json:"actor_id,omitempty"` // the admin who acted, for admin actions
+	IPAddress string    `json:"ip_address,omitempty"`
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
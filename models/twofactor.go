@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// TwoFactorStatus describes a user's two-factor setup
+type TwoFactorStatus struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+	Required               bool       `json:"required"` // the server requires 2FA for everyone
+}
+
+// TwoFactorEnrollment is returned when starting enrollment; the secret is shown once
+// and must be confirmed with a code before 2FA is switched on
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// LoginChallenge is returned from login instead of tokens when a second factor is needed.
+// With EnrollmentRequired the server requires 2FA and the user must set it up first.
+type LoginChallenge struct {
+	TwoFactorRequired  bool      `json:"two_factor_required"`
+	EnrollmentRequired bool      `json:"enrollment_required,omitempty"`
+	ChallengeToken     string    `json:"challenge_token"`
+	ExpiresAt          time.Time `json:"expires_at"`
+}
+
+// PendingLogin is a password-verified login waiting for a second factor
+type PendingLogin struct {
+	UserID     string
+	DeviceName string
+	Attempts   int
+	ExpiresAt  time.Time
+}
+
+// MaxLoginChallengeAttempts is how many wrong codes a login challenge allows
+const MaxLoginChallengeAttempts = 5
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"` // a TOTP code or, where allowed, a recovery code
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type LoginChallengeRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code,omitempty"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorSettings struct {
+	Required bool `json:"required"`
+}
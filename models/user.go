@@ -3,35 +3,174 @@ package models
 import "time"
 
 type User struct {
-	ID           string    `json:"id"`
-	Username     string    `json:"username"`
-	DisplayName  string    `json:"display_name"`
-	PasswordHash string    `json:"-"`
-	AvatarURL    string    `json:"avatar_url,omitempty"`
-	Status       string    `json:"status"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID              string              `json:"id"`
+	Username        string              `json:"username"`
+	DisplayName     string              `json:"display_name"`
+	PasswordHash    string              `json:"-"`
+	Email           string              `json:"-"`
+	EmailVerifiedAt *time.Time          `json:"-"`
+	AvatarURL       string              `json:"avatar_url,omitempty"`
+	Title           string              `json:"title,omitempty"`
+	Pronouns        string              `json:"pronouns,omitempty"`
+	Phone           string              `json:"phone,omitempty"`
+	Timezone        string              `json:"timezone,omitempty"`
+	Status          string              `json:"status"`
+	CustomStatus    *CustomStatus       `json:"custom_status,omitempty"`
+	LastSeenAt      *time.Time          `json:"last_seen_at,omitempty"`
+	DoNotDisturb    bool                `json:"do_not_disturb,omitempty"`
+	ProfileFields   []ProfileFieldValue `json:"profile_fields,omitempty"`
+	Role            string              `json:"role,omitempty"`
+	DeactivatedAt   *time.Time          `json:"deactivated_at,omitempty"`
+	DeletedAt       *time.Time          `json:"deleted_at,omitempty"`
+	CreatedAt       time.Time           `json:"created_at"`
 }
 
 type UserResponse struct {
-	ID          string    `json:"id"`
-	Username    string    `json:"username"`
-	DisplayName string    `json:"display_name"`
-	AvatarURL   string    `json:"avatar_url,omitempty"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID            string              `json:"id"`
+	Username      string              `json:"username"`
+	DisplayName   string              `json:"display_name"`
+	AvatarURL     string              `json:"avatar_url,omitempty"`
+	Title         string              `json:"title,omitempty"`
+	Pronouns      string              `json:"pronouns,omitempty"`
+	Phone         string              `json:"phone,omitempty"`
+	Timezone      string              `json:"timezone,omitempty"`
+	Status        string              `json:"status"`
+	CustomStatus  *CustomStatus       `json:"custom_status,omitempty"`
+	LastSeenAt    *time.Time          `json:"last_seen_at,omitempty"`
+	DoNotDisturb  bool                `json:"do_not_disturb,omitempty"`
+	ProfileFields []ProfileFieldValue `json:"profile_fields,omitempty"`
+	Role          string              `json:"role,omitempty"`
+	DeactivatedAt *time.Time          `json:"deactivated_at,omitempty"`
+	DeletedAt     *time.Time          `json:"deleted_at,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
 }
 
+// CustomStatus is a status message a user sets, shown alongside their presence.
+// It's cleared automatically once ExpiresAt passes.
+type CustomStatus struct {
+	Emoji     string     `json:"emoji,omitempty"`
+	Text      string     `json:"text,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// MaxCustomStatusLength is the longest custom status text allowed, in characters
+const MaxCustomStatusLength = 100
+
+// UserStatusChanged is broadcast when a user's status or custom status changes
+type UserStatusChanged struct {
+	UserID       string        `json:"user_id"`
+	Status       string        `json:"status"`
+	CustomStatus *CustomStatus `json:"custom_status"`
+	DoNotDisturb bool          `json:"do_not_disturb"`
+}
+
+// IdleTimeout is how long a user can go without activity on any of their
+// connections before they're shown as away
+const IdleTimeout = 10 * time.Minute
+
+const (
+	WSTypeUserStatusChanged = "user_status_changed"
+	WSTypeActivity          = "activity" // client heartbeat sent while the user is active
+)
+
+// ToResponse returns what everyone can see about the user. The phone number is left
+// out; see ToResponseFor.
 func (u *User) ToResponse() UserResponse {
+	resp := u.ToPrivateResponse()
+	resp.Phone = ""
+	return resp
+}
+
+// ToResponseFor returns what viewer can see about the user: the phone number is only
+// shown to the user themselves and to admins
+func (u *User) ToResponseFor(viewer *User) UserResponse {
+	if viewer != nil && (viewer.ID == u.ID || viewer.IsAdmin()) {
+		return u.ToPrivateResponse()
+	}
+	return u.ToResponse()
+}
+
+// ToPrivateResponse returns everything about the user, for the user themselves and admins
+func (u *User) ToPrivateResponse() UserResponse {
 	return UserResponse{
-		ID:          u.ID,
-		Username:    u.Username,
-		DisplayName: u.DisplayName,
-		AvatarURL:   u.AvatarURL,
-		Status:      u.Status,
-		CreatedAt:   u.CreatedAt,
+		ID:            u.ID,
+		Username:      u.Username,
+		DisplayName:   u.DisplayName,
+		AvatarURL:     u.AvatarURL,
+		Title:         u.Title,
+		Pronouns:      u.Pronouns,
+		Phone:         u.Phone,
+		Timezone:      u.Timezone,
+		Status:        u.Status,
+		CustomStatus:  u.CustomStatus,
+		LastSeenAt:    u.LastSeenAt,
+		DoNotDisturb:  u.DoNotDisturb,
+		ProfileFields: u.ProfileFields,
+		Role:          u.Role,
+		DeactivatedAt: u.DeactivatedAt,
+		DeletedAt:     u.DeletedAt,
+		CreatedAt:     u.CreatedAt,
 	}
 }
 
+// Location returns the user's timezone, or UTC if they haven't set one
+func (u *User) Location() *time.Location {
+	if loc, err := time.LoadLocation(u.Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// IsBot reports whether the user is a bot, Smackbot or a webhook, none of which
+// have a password. Only users loaded with their password hash can be told apart.
+func (u *User) IsBot() bool {
+	return u.PasswordHash == ""
+}
+
+// IsDeleted reports whether the user deleted their account
+func (u *User) IsDeleted() bool {
+	return u.DeletedAt != nil
+}
+
+// IsAdmin reports whether the user can use the admin API
+func (u *User) IsAdmin() bool {
+	return u.Role == ServerRoleOwner || u.Role == ServerRoleAdmin
+}
+
+// IsDeactivated reports whether an admin has deactivated the account
+func (u *User) IsDeactivated() bool {
+	return u.DeactivatedAt != nil
+}
+
+// Server roles
+const (
+	ServerRoleOwner  = "owner"
+	ServerRoleAdmin  = "admin"
+	ServerRoleMember = "member"
+	ServerRoleGuest  = "guest" // can only take part in channels they're added or invited to
+)
+
+// IsValidServerRole reports whether role is a known server role
+func IsValidServerRole(role string) bool {
+	return role == ServerRoleOwner || role == ServerRoleAdmin || role == ServerRoleMember || role == ServerRoleGuest
+}
+
+type UpdateUserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// AdminPasswordResetRequest issues a reset code for a user. With DeliverByDM the code
+// is sent from Smackbot instead of being returned.
+type AdminPasswordResetRequest struct {
+	DeliverByDM      bool `json:"deliver_by_dm"`
+	ExpiresInMinutes int  `json:"expires_in_minutes,omitempty"`
+}
+
+type AdminPasswordResetResponse struct {
+	Token     string    `json:"token,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
 type UserPreference struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
@@ -46,14 +185,68 @@ type RegisterRequest struct {
 	Username    string `json:"username"`
 	DisplayName string `json:"display_name"`
 	Password    string `json:"password"`
+	Email       string `json:"email,omitempty"`       // required when registration is limited to email domains
+	EmailCode   string `json:"email_code,omitempty"`  // the code sent to Email, when registration is limited to email domains
+	InviteCode  string `json:"invite_code,omitempty"` // required when registration is invite-only
+	DeviceName  string `json:"device_name,omitempty"`
+}
+
+// EmailVerificationRequest asks for a code to be sent to an email address
+type EmailVerificationRequest struct {
+	Email string `json:"email"`
 }
 
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	DeviceName string `json:"device_name,omitempty"`
 }
 
+// AuthResponse carries a short-lived access token in Token and a refresh token
+// that can be exchanged for a new pair at /api/auth/refresh
 type AuthResponse struct {
-	Token string       `json:"token"`
-	User  UserResponse `json:"user"`
+	Token            string       `json:"token"`
+	ExpiresAt        time.Time    `json:"expires_at"`
+	RefreshToken     string       `json:"refresh_token"`
+	RefreshExpiresAt time.Time    `json:"refresh_expires_at"`
+	SessionID        string       `json:"session_id"`
+	User             UserResponse `json:"user"`
+
+	// RecoveryCodes is set when signing in also finished two-factor enrollment
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// User directory kinds
+const (
+	UserKindHumans = "humans"
+	UserKindBots   = "bots" // bots, Smackbot and webhooks
+)
+
+type UserDirectoryQuery struct {
+	Query       string // prefix of a username or of a word in a display name
+	Kind        string // "" for everyone
+	OnlineOnly  bool
+	Deactivated bool // list only deactivated accounts instead of active ones
+	Cursor      string
+	Limit       int
+}
+
+// DirectoryUser is a user as listed in the user directory
+type DirectoryUser struct {
+	UserResponse
+	IsBot bool `json:"is_bot"`
+}
+
+type UserDirectoryPage struct {
+	Users      []DirectoryUser `json:"users"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// UserSuggestion is the lightweight form of a user returned for mention autocomplete
+type UserSuggestion struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	IsBot       bool   `json:"is_bot,omitempty"`
 }
@@ -0,0 +1,181 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"smack-server/oidc/oidctest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID    = "smack"
+	testRedirectURL = "https://smack.example.com/api/auth/oidc/callback"
+)
+
+func newTestProvider(t *testing.T) (*oidctest.Issuer, *Provider) {
+	t.Helper()
+	issuer := oidctest.NewIssuer(testClientID)
+	t.Cleanup(issuer.Close)
+
+	provider, err := Discover(context.Background(), issuer.URL)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	return issuer, provider
+}
+
+func TestDiscover(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+
+	if provider.Issuer != issuer.URL {
+		t.Errorf("Issuer = %q, want %q", provider.Issuer, issuer.URL)
+	}
+	if provider.TokenEndpoint != issuer.URL+"/token" || provider.JWKSURI != issuer.URL+"/jwks" {
+		t.Errorf("unexpected endpoints: %+v", provider)
+	}
+
+	// A trailing slash on the configured issuer still matches
+	if _, err := Discover(context.Background(), issuer.URL+"/"); err != nil {
+		t.Errorf("Discover with trailing slash: %v", err)
+	}
+}
+
+func TestDiscoverRejectsBadDocuments(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  func(url string) map[string]string
+	}{
+		{"issuer mismatch", func(url string) map[string]string {
+			return map[string]string{"issuer": "https://evil.example.com", "authorization_endpoint": url + "/a", "token_endpoint": url + "/t", "jwks_uri": url + "/k"}
+		}},
+		{"missing token endpoint", func(url string) map[string]string {
+			return map[string]string{"issuer": url, "authorization_endpoint": url + "/a", "jwks_uri": url + "/k"}
+		}},
+	}
+	for _, tt := range tests {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(tt.doc(server.URL))
+		}))
+		if _, err := Discover(context.Background(), server.URL); err == nil {
+			t.Errorf("%s: Discover succeeded", tt.name)
+		}
+		server.Close()
+	}
+}
+
+func TestExchangeAndVerify(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatalf("NewPKCE: %v", err)
+	}
+	code := issuer.Authorize(jwt.MapClaims{
+		"sub":            "user-1",
+		"nonce":          "n-123",
+		"email":          "ada@example.com",
+		"email_verified": true,
+		"name":           "Ada",
+	}, challenge)
+
+	raw, err := provider.Exchange(context.Background(), testClientID, "secret", testRedirectURL, code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	claims, err := provider.Verify(context.Background(), raw, testClientID, "n-123")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "ada@example.com" || !claims.EmailVerified || claims.Name != "Ada" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	// Codes can only be redeemed once
+	if _, err := provider.Exchange(context.Background(), testClientID, "secret", testRedirectURL, code, verifier); err == nil {
+		t.Error("Exchange redeemed the same code twice")
+	}
+}
+
+func TestExchangeRequiresPKCEVerifier(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+
+	_, challenge, _ := NewPKCE()
+	otherVerifier, _, _ := NewPKCE()
+	code := issuer.Authorize(jwt.MapClaims{"sub": "user-1"}, challenge)
+
+	if _, err := provider.Exchange(context.Background(), testClientID, "", testRedirectURL, code, otherVerifier); err == nil {
+		t.Error("Exchange succeeded with the wrong code verifier")
+	}
+}
+
+func TestNewPKCEChallenge(t *testing.T) {
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatalf("NewPKCE: %v", err)
+	}
+	if len(verifier) < 43 || strings.ContainsAny(verifier+challenge, "+/=") {
+		t.Errorf("verifier %q or challenge %q isn't unpadded base64url of the right length", verifier, challenge)
+	}
+
+	u := (&Provider{AuthorizationEndpoint: "https://idp.example.com/auth"}).AuthCodeURL(testClientID, testRedirectURL, []string{"openid"}, "s", "n", challenge)
+	if !strings.Contains(u, "code_challenge="+challenge) || !strings.Contains(u, "code_challenge_method=S256") {
+		t.Errorf("AuthCodeURL is missing the PKCE challenge: %s", u)
+	}
+}
+
+func TestVerifyRejectsBadTokens(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := jwt.MapClaims{"sub": "user-1", "nonce": "n-123"}
+	with := func(k string, v interface{}) jwt.MapClaims {
+		claims := jwt.MapClaims{}
+		for key, value := range valid {
+			claims[key] = value
+		}
+		claims[k] = v
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+	}{
+		{"nonce mismatch", issuer.SignIDToken(valid, issuer.KeyID, issuer.Key), "n-other"},
+		{"missing nonce", issuer.SignIDToken(with("nonce", ""), issuer.KeyID, issuer.Key), "n-123"},
+		{"wrong audience", issuer.SignIDToken(with("aud", "someone-else"), issuer.KeyID, issuer.Key), "n-123"},
+		{"wrong issuer", issuer.SignIDToken(with("iss", "https://evil.example.com"), issuer.KeyID, issuer.Key), "n-123"},
+		{"expired", issuer.SignIDToken(with("exp", time.Now().Add(-time.Hour).Unix()), issuer.KeyID, issuer.Key), "n-123"},
+		{"missing subject", issuer.SignIDToken(with("sub", ""), issuer.KeyID, issuer.Key), "n-123"},
+		{"unknown kid", issuer.SignIDToken(valid, "unknown-key", otherKey), "n-123"},
+		{"wrong key for kid", issuer.SignIDToken(valid, issuer.KeyID, otherKey), "n-123"},
+		{"unsigned", func() string {
+			claims := with("iss", issuer.URL)
+			claims["aud"], claims["exp"] = testClientID, time.Now().Add(time.Hour).Unix()
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+			return s
+		}(), "n-123"},
+	}
+	for _, tt := range tests {
+		if _, err := provider.Verify(context.Background(), tt.token, testClientID, tt.nonce); err == nil {
+			t.Errorf("%s: Verify succeeded", tt.name)
+		}
+	}
+
+	if _, err := provider.Verify(context.Background(), issuer.SignIDToken(valid, issuer.KeyID, issuer.Key), testClientID, "n-123"); err != nil {
+		t.Errorf("Verify rejected a valid token: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+// Package oidctest runs a minimal OpenID Connect issuer for tests. It serves a
+// discovery document, a JWKS and a token endpoint that checks PKCE, and signs ID
+// tokens with a key generated when it starts.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Issuer is a mock provider. Codes are handed out with Authorize, standing in for
+// someone signing in at the authorization endpoint.
+type Issuer struct {
+	*httptest.Server
+	Key      *rsa.PrivateKey
+	KeyID    string
+	ClientID string
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+type grant struct {
+	claims    jwt.MapClaims
+	challenge string
+}
+
+func NewIssuer(clientID string) *Issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	i := &Issuer{Key: key, KeyID: "test-key", ClientID: clientID, grants: make(map[string]grant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", i.discovery)
+	mux.HandleFunc("GET /jwks", i.jwks)
+	mux.HandleFunc("POST /token", i.token)
+	i.Server = httptest.NewServer(mux)
+	return i
+}
+
+// Authorize returns a code that the token endpoint exchanges for an ID token with
+// claims, once given the verifier for challenge. Standard claims the caller leaves
+// out (iss, aud, exp, iat) are filled in.
+func (i *Issuer) Authorize(claims jwt.MapClaims, challenge string) string {
+	code := base64.RawURLEncoding.EncodeToString(randomBytes(16))
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.grants[code] = grant{claims: claims, challenge: challenge}
+	return code
+}
+
+// SignIDToken signs claims with the given key ID and key, filling in standard claims
+// the caller leaves out
+func (i *Issuer) SignIDToken(claims jwt.MapClaims, kid string, key *rsa.PrivateKey) string {
+	full := jwt.MapClaims{
+		"iss": i.URL,
+		"aud": i.ClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, full)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{
+		"issuer":                 i.URL,
+		"authorization_endpoint": i.URL + "/authorize",
+		"token_endpoint":         i.URL + "/token",
+		"jwks_uri":               i.URL + "/jwks",
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := i.Key.PublicKey
+	writeJSON(w, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": i.KeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// token redeems a code once, checking the client and the PKCE verifier
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	if user, _, ok := r.BasicAuth(); ok {
+		clientID = user
+	}
+	if clientID != i.ClientID {
+		tokenError(w, "invalid_client")
+		return
+	}
+
+	i.mu.Lock()
+	g, ok := i.grants[r.PostForm.Get("code")]
+	delete(i.grants, r.PostForm.Get("code"))
+	i.mu.Unlock()
+	if !ok {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	writeJSON(w, map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     i.SignIDToken(g.claims, i.KeyID, i.Key),
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,36 @@
+package store
+
+import (
+	"smack-server/models"
+	"testing"
+)
+
+func TestTransferChannelOwnershipDemotesPreviousOwner(t *testing.T) {
+	s := newTestStore(t)
+	owner, err := s.CreateUser("alice", "Alice", "secret123")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	member, err := s.CreateUser("bob", "Bob", "secret123")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	channel, err := s.CreateChannel("plans", "", owner.ID, false)
+	if err != nil {
+		t.Fatalf("CreateChannel: %v", err)
+	}
+	if err := s.JoinChannel(channel.ID, member.ID); err != nil {
+		t.Fatalf("JoinChannel: %v", err)
+	}
+
+	if err := s.TransferChannelOwnership(channel.ID, member.ID); err != nil {
+		t.Fatalf("TransferChannelOwnership: %v", err)
+	}
+
+	if role, _ := s.GetChannelMemberRole(channel.ID, member.ID); role != models.ChannelRoleOwner {
+		t.Errorf("new owner's role = %q, want %q", role, models.ChannelRoleOwner)
+	}
+	if role, _ := s.GetChannelMemberRole(channel.ID, owner.ID); role != models.ChannelRoleAdmin {
+		t.Errorf("previous owner's role = %q, want %q", role, models.ChannelRoleAdmin)
+	}
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"path/filepath"
+	"smack-server/models"
+	"testing"
+)
+
+func TestMutedChannelsMigrateToNotificationLevels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := New(path)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	user, err := s.CreateUser("alice", "Alice", "secret123")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	channel, err := s.CreateChannel("plans", "", user.ID, false)
+	if err != nil {
+		t.Fatalf("CreateChannel: %v", err)
+	}
+
+	// A database from before notification levels, with a muted channel
+	_, err = s.db.Exec(`
+		CREATE TABLE muted_channels (
+			user_id TEXT REFERENCES users(id),
+			channel_id TEXT REFERENCES channels(id),
+			muted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, channel_id)
+		)
+	`)
+	if err != nil {
+		t.Fatalf("creating muted_channels: %v", err)
+	}
+	if _, err := s.db.Exec("INSERT INTO muted_channels (user_id, channel_id) VALUES (?, ?)", user.ID, channel.ID); err != nil {
+		t.Fatalf("muting channel: %v", err)
+	}
+	s.Close()
+
+	s, err = New(path)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer s.Close()
+
+	if level := s.GetNotificationLevel(user.ID, channel.ID, nil); level != models.NotificationLevelNothing {
+		t.Errorf("level after migration = %q, want %q", level, models.NotificationLevelNothing)
+	}
+	var count int
+	s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='muted_channels'`).Scan(&count)
+	if count != 0 {
+		t.Error("muted_channels wasn't dropped")
+	}
+}
+
+func TestRunOnceSkipsAppliedMigrations(t *testing.T) {
+	s := newTestStore(t)
+	for i := 0; i < 2; i++ {
+		s.runOnce("test_setting", `INSERT INTO server_settings (key, value) VALUES ('test', '1') ON CONFLICT(key) DO UPDATE SET value = value + 1`)
+	}
+	if value, _ := s.GetServerSetting("test"); value != "1" {
+		t.Errorf("migration ran again: value = %q, want %q", value, "1")
+	}
+}
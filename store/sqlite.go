@@ -1,13 +1,24 @@
 package store
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
 	"smack-server/models"
+	"smack-server/totp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -15,6 +26,36 @@ type Store struct {
 	db *sql.DB
 }
 
+// ErrInvalidCursor is returned when a pagination cursor can't be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrUserBlocked is returned when one of the people in a direct message has blocked another
+var ErrUserBlocked = errors.New("user is blocked")
+
+// ErrInviteUnusable is returned when an invite is revoked, expired or used up
+var ErrInviteUnusable = errors.New("invite is no longer valid")
+
+// ErrSessionInvalid is returned when a refresh token doesn't belong to an active session
+var ErrSessionInvalid = errors.New("session is invalid or expired")
+
+// ErrResetTokenInvalid is returned when a password reset token is unknown, used or expired
+var ErrResetTokenInvalid = errors.New("reset token is invalid or expired")
+
+// ErrInvalidTwoFactorCode is returned when a TOTP or recovery code doesn't match
+var ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+
+// ErrEmailCodeInvalid is returned when an email verification code doesn't match, has
+// expired or has had too many wrong guesses
+var ErrEmailCodeInvalid = errors.New("email verification code is invalid or expired")
+
+// ErrEmailCodeTooSoon is returned when a new email verification code is asked for
+// right after the last one was sent
+var ErrEmailCodeTooSoon = errors.New("email verification code was sent recently")
+
+// ErrRefreshTokenReused is returned when an already-rotated refresh token is presented
+// again. The session is revoked, since the token has likely been stolen.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
 func New(dbPath string) (*Store, error) {
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
@@ -47,12 +88,18 @@ func (s *Store) init() error {
 		description TEXT,
 		is_direct BOOLEAN DEFAULT FALSE,
 		created_by TEXT REFERENCES users(id),
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		archived_at DATETIME,
+		archived_by TEXT,
+		dm_key TEXT,
+		topic TEXT,
+		join_leave_messages BOOLEAN DEFAULT TRUE
 	);
 
 	CREATE TABLE IF NOT EXISTS channel_members (
 		channel_id TEXT REFERENCES channels(id),
 		user_id TEXT REFERENCES users(id),
+		role TEXT DEFAULT 'member',
 		joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		last_read_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		PRIMARY KEY (channel_id, user_id)
@@ -65,6 +112,7 @@ func (s *Store) init() error {
 		content TEXT NOT NULL,
 		html_content TEXT,
 		widget_size TEXT,
+		type TEXT,
 		thread_id TEXT REFERENCES messages(id),
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
@@ -86,13 +134,25 @@ func (s *Store) init() error {
 	CREATE INDEX IF NOT EXISTS idx_reminders_user ON reminders(user_id);
 	CREATE INDEX IF NOT EXISTS idx_reminders_time ON reminders(remind_at);
 
-	CREATE TABLE IF NOT EXISTS muted_channels (
-		user_id TEXT REFERENCES users(id),
-		channel_id TEXT REFERENCES channels(id),
-		muted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	-- Per-channel notification levels (replaces muted_channels)
+	CREATE TABLE IF NOT EXISTS channel_notification_settings (
+		user_id TEXT NOT NULL REFERENCES users(id),
+		channel_id TEXT NOT NULL REFERENCES channels(id),
+		level TEXT NOT NULL DEFAULT 'all',
+		muted_until DATETIME,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		PRIMARY KEY (user_id, channel_id)
 	);
 
+	-- Per-thread notification overrides
+	CREATE TABLE IF NOT EXISTS thread_notification_settings (
+		user_id TEXT NOT NULL REFERENCES users(id),
+		message_id TEXT NOT NULL REFERENCES messages(id),
+		level TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, message_id)
+	);
+
 	CREATE TABLE IF NOT EXISTS bots (
 		id TEXT PRIMARY KEY,
 		name TEXT UNIQUE NOT NULL,
@@ -134,6 +194,304 @@ func (s *Store) init() error {
 	CREATE INDEX IF NOT EXISTS idx_webhooks_channel ON webhooks(channel_id);
 	CREATE INDEX IF NOT EXISTS idx_webhooks_token ON webhooks(token);
 
+	-- Pinned messages
+	CREATE TABLE IF NOT EXISTS channel_pins (
+		channel_id TEXT NOT NULL REFERENCES channels(id),
+		message_id TEXT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+		pinned_by TEXT NOT NULL REFERENCES users(id),
+		pinned_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (channel_id, message_id)
+	);
+
+	-- Channel metadata change history
+	CREATE TABLE IF NOT EXISTS channel_changes (
+		id TEXT PRIMARY KEY,
+		channel_id TEXT NOT NULL REFERENCES channels(id),
+		user_id TEXT NOT NULL REFERENCES users(id),
+		field TEXT NOT NULL,
+		old_value TEXT,
+		new_value TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_channel_changes_channel ON channel_changes(channel_id);
+
+	-- Per-user sidebar sections
+	CREATE TABLE IF NOT EXISTS channel_sections (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		name TEXT NOT NULL,
+		position INTEGER NOT NULL DEFAULT 0,
+		collapsed BOOLEAN DEFAULT FALSE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_channel_sections_user ON channel_sections(user_id);
+
+	-- Channel placement within a user's sections (a channel is in at most one section per user)
+	CREATE TABLE IF NOT EXISTS channel_section_items (
+		user_id TEXT NOT NULL REFERENCES users(id),
+		channel_id TEXT NOT NULL REFERENCES channels(id),
+		section_id TEXT NOT NULL REFERENCES channel_sections(id),
+		position INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, channel_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_channel_section_items_section ON channel_section_items(section_id);
+
+	-- Channel invite links
+	CREATE TABLE IF NOT EXISTS channel_invites (
+		id TEXT PRIMARY KEY,
+		channel_id TEXT NOT NULL REFERENCES channels(id),
+		token TEXT UNIQUE NOT NULL,
+		created_by TEXT NOT NULL REFERENCES users(id),
+		role TEXT NOT NULL DEFAULT 'member',
+		max_uses INTEGER,
+		use_count INTEGER NOT NULL DEFAULT 0,
+		expires_at DATETIME,
+		revoked_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_channel_invites_channel ON channel_invites(channel_id);
+
+	CREATE TABLE IF NOT EXISTS channel_invite_uses (
+		invite_id TEXT NOT NULL REFERENCES channel_invites(id),
+		user_id TEXT NOT NULL REFERENCES users(id),
+		used_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (invite_id, user_id)
+	);
+
+	-- Signed-in sessions; refresh tokens are stored hashed
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		refresh_token_hash TEXT UNIQUE NOT NULL,
+		previous_token_hash TEXT,
+		device_name TEXT,
+		user_agent TEXT,
+		ip_address TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+	CREATE INDEX IF NOT EXISTS idx_sessions_previous_token ON sessions(previous_token_hash);
+
+	-- One-time password reset tokens, stored hashed
+	CREATE TABLE IF NOT EXISTS password_reset_tokens (
+		token_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		created_by TEXT,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user ON password_reset_tokens(user_id);
+
+	-- TOTP two-factor secrets; enabled_at is set once enrollment is confirmed
+	CREATE TABLE IF NOT EXISTS user_totp (
+		user_id TEXT PRIMARY KEY REFERENCES users(id),
+		secret TEXT NOT NULL,
+		enabled_at DATETIME,
+		last_used_step INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Two-factor recovery codes, stored as bcrypt hashes
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		code_hash TEXT NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id);
+
+	-- Logins waiting for a second factor, stored hashed
+	CREATE TABLE IF NOT EXISTS login_challenges (
+		token_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		device_name TEXT,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Links between users and their accounts at an OpenID Connect provider
+	CREATE TABLE IF NOT EXISTS user_identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		email TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_login_at DATETIME,
+		PRIMARY KEY (issuer, subject)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id);
+
+	-- OIDC authorization requests waiting for the provider's redirect
+	CREATE TABLE IF NOT EXISTS oidc_login_states (
+		state TEXT PRIMARY KEY,
+		nonce TEXT NOT NULL,
+		code_verifier TEXT NOT NULL,
+		device_name TEXT,
+		expires_at DATETIME NOT NULL
+	);
+
+	-- Provider accounts waiting for a password to confirm linking them to an account
+	CREATE TABLE IF NOT EXISTS oidc_pending_links (
+		token_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		email TEXT NOT NULL,
+		device_name TEXT,
+		expires_at DATETIME NOT NULL
+	);
+
+	-- Recent failed logins per account and per IP address
+	CREATE TABLE IF NOT EXISTS login_throttles (
+		key TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL DEFAULT 0,
+		last_failed_at DATETIME NOT NULL,
+		locked_until DATETIME
+	);
+
+	-- Security event log
+	CREATE TABLE IF NOT EXISTS security_events (
+		id TEXT PRIMARY KEY,
+		type TEXT NOT NULL,
+		user_id TEXT,
+		actor_id TEXT,
+		ip_address TEXT,
+		details TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_security_events_user ON security_events(user_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_security_events_created ON security_events(created_at);
+
+	-- Invite codes that let people register when registration isn't open
+	CREATE TABLE IF NOT EXISTS registration_invites (
+		id TEXT PRIMARY KEY,
+		code TEXT UNIQUE NOT NULL,
+		note TEXT,
+		created_by TEXT NOT NULL REFERENCES users(id),
+		role TEXT NOT NULL DEFAULT 'member',
+		max_uses INTEGER,
+		use_count INTEGER NOT NULL DEFAULT 0,
+		expires_at DATETIME,
+		revoked_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS registration_invite_uses (
+		invite_id TEXT NOT NULL REFERENCES registration_invites(id),
+		user_id TEXT NOT NULL REFERENCES users(id),
+		used_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (invite_id, user_id)
+	);
+
+	-- Personal access tokens for scripts and integrations
+	CREATE TABLE IF NOT EXISTS personal_access_tokens (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		name TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		token_prefix TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		expires_at DATETIME,
+		last_used_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user ON personal_access_tokens(user_id);
+
+	-- Custom profile fields defined by admins, and each user's values
+	CREATE TABLE IF NOT EXISTS profile_fields (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		options TEXT,
+		visibility TEXT NOT NULL DEFAULT 'everyone',
+		position INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS user_profile_values (
+		user_id TEXT NOT NULL REFERENCES users(id),
+		field_id TEXT NOT NULL REFERENCES profile_fields(id),
+		value TEXT NOT NULL,
+		PRIMARY KEY (user_id, field_id)
+	);
+
+	-- People each user has blocked
+	CREATE TABLE IF NOT EXISTS user_blocks (
+		blocker_id TEXT NOT NULL REFERENCES users(id),
+		blocked_id TEXT NOT NULL REFERENCES users(id),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (blocker_id, blocked_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_user_blocks_blocked ON user_blocks(blocked_id);
+
+	-- Do-not-disturb schedules and snoozes
+	CREATE TABLE IF NOT EXISTS dnd_settings (
+		user_id TEXT PRIMARY KEY REFERENCES users(id),
+		schedule TEXT,
+		snooze_until DATETIME,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Pushes held back while a user has do not disturb on
+	CREATE TABLE IF NOT EXISTS held_notifications (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_held_notifications_user ON held_notifications(user_id, created_at);
+
+	-- Personal data exports
+	CREATE TABLE IF NOT EXISTS data_exports (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		status TEXT NOT NULL DEFAULT 'pending',
+		size INTEGER NOT NULL DEFAULT 0,
+		error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		completed_at DATETIME,
+		expires_at DATETIME
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_data_exports_user ON data_exports(user_id, created_at);
+
+	-- Codes sent to prove an email address before registering with it
+	CREATE TABLE IF NOT EXISTS email_verifications (
+		email TEXT PRIMARY KEY,
+		code_hash TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Access token signing keys
+	CREATE TABLE IF NOT EXISTS signing_keys (
+		id TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		retired_at DATETIME
+	);
+
 	-- Kanban boards
 	CREATE TABLE IF NOT EXISTS kanban_boards (
 		id TEXT PRIMARY KEY,
@@ -296,6 +654,12 @@ func (s *Store) init() error {
 		value TEXT NOT NULL,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
+
+	-- Data migrations that have already run
+	CREATE TABLE IF NOT EXISTS data_migrations (
+		name TEXT PRIMARY KEY,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
 	`
 
 	_, err := s.db.Exec(schema)
@@ -325,6 +689,14 @@ func (s *Store) init() error {
 		`)
 	}
 
+	// Generate the first access token signing key
+	s.db.QueryRow("SELECT COUNT(*) FROM signing_keys WHERE retired_at IS NULL").Scan(&count)
+	if count == 0 {
+		if _, keyErr := s.RotateSigningKey(); keyErr != nil {
+			return keyErr
+		}
+	}
+
 	return err
 }
 
@@ -354,6 +726,191 @@ func (s *Store) runMigrations() {
 	if count == 0 {
 		s.db.Exec(`ALTER TABLE kanban_boards ADD COLUMN icon TEXT`)
 	}
+
+	// Add role column to channel_members and make existing channel creators owners
+	s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('channel_members') WHERE name='role'`).Scan(&count)
+	if count == 0 {
+		s.db.Exec(`ALTER TABLE channel_members ADD COLUMN role TEXT DEFAULT 'member'`)
+		s.db.Exec(`
+			UPDATE channel_members SET role = 'owner'
+			WHERE user_id = (SELECT created_by FROM channels WHERE channels.id = channel_members.channel_id)
+		`)
+	}
+
+	// Add archive columns to channels table if they don't exist
+	s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('channels') WHERE name='archived_at'`).Scan(&count)
+	if count == 0 {
+		s.db.Exec(`ALTER TABLE channels ADD COLUMN archived_at DATETIME`)
+		s.db.Exec(`ALTER TABLE channels ADD COLUMN archived_by TEXT`)
+	}
+
+	// Add dm_key column to channels and key existing direct messages by their member set
+	s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('channels') WHERE name='dm_key'`).Scan(&count)
+	if count == 0 {
+		s.db.Exec(`ALTER TABLE channels ADD COLUMN dm_key TEXT`)
+		s.backfillDMKeys()
+	}
+
+	// Each member set has a single DM. Older duplicates, created before dm_key was
+	// unique, keep their messages but stop being returned for the member set.
+	s.db.Exec(`DROP INDEX IF EXISTS idx_channels_dm_key`)
+	s.db.Exec(`
+		UPDATE channels SET dm_key = NULL
+		WHERE dm_key IS NOT NULL AND rowid NOT IN (SELECT MIN(rowid) FROM channels WHERE dm_key IS NOT NULL GROUP BY dm_key)
+	`)
+	s.db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_channels_dm_key_unique ON channels(dm_key) WHERE dm_key IS NOT NULL`)
+
+	// Add topic column to channels table if it doesn't exist
+	s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('channels') WHERE name='topic'`).Scan(&count)
+	if count == 0 {
+		s.db.Exec(`ALTER TABLE channels ADD COLUMN topic TEXT`)
+	}
+
+	// Add type column to messages table if it doesn't exist
+	s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('messages') WHERE name='type'`).Scan(&count)
+	if count == 0 {
+		s.db.Exec(`ALTER TABLE messages ADD COLUMN type TEXT`)
+	}
+
+	// Add join_leave_messages column to channels table if it doesn't exist
+	s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('channels') WHERE name='join_leave_messages'`).Scan(&count)
+	if count == 0 {
+		s.db.Exec(`ALTER TABLE channels ADD COLUMN join_leave_messages BOOLEAN DEFAULT TRUE`)
+	}
+
+	// Add server role and deactivation columns to users table if they don't exist
+	s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users') WHERE name='role'`).Scan(&count)
+	if count == 0 {
+		s.db.Exec(`ALTER TABLE users ADD COLUMN role TEXT DEFAULT 'member'`)
+		s.db.Exec(`ALTER TABLE users ADD COLUMN deactivated_at DATETIME`)
+
+		// The earliest registered person owns an existing server
+		s.db.Exec(`
+			UPDATE users SET role = 'owner'
+			WHERE id = (SELECT id FROM users WHERE password_hash != '' ORDER BY created_at LIMIT 1)
+		`)
+	}
+
+	// Add email column to users table if it doesn't exist
+	s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users') WHERE name='email'`).Scan(&count)
+	if count == 0 {
+		s.db.Exec(`ALTER TABLE users ADD COLUMN email TEXT`)
+	}
+	s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_users_email ON users(email COLLATE NOCASE)`)
+
+	// Split presence from the status a user picks, and add custom status columns
+	s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users') WHERE name='presence'`).Scan(&count)
+	if count == 0 {
+		s.db.Exec(`ALTER TABLE users ADD COLUMN presence TEXT DEFAULT 'online'`)
+		s.db.Exec(`ALTER TABLE users ADD COLUMN manual_status TEXT DEFAULT ''`)
+		s.db.Exec(`ALTER TABLE users ADD COLUMN status_emoji TEXT`)
+		s.db.Exec(`ALTER TABLE users ADD COLUMN status_text TEXT`)
+		s.db.Exec(`ALTER TABLE users ADD COLUMN status_expires_at DATETIME`)
+		s.db.Exec(`
+			UPDATE users SET
+				presence = CASE status WHEN 'offline' THEN 'offline' ELSE 'online' END,
+				manual_status = CASE WHEN status IN ('away', 'dnd') THEN status ELSE '' END
+		`)
+	}
+
+	// Add last_seen_at column to users table if it doesn't exist
+	s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users') WHERE name='last_seen_at'`).Scan(&count)
+	if count == 0 {
+		s.db.Exec(`ALTER TABLE users ADD COLUMN last_seen_at DATETIME`)
+	}
+
+	// Add profile columns to users table if they don't exist
+	s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users') WHERE name='timezone'`).Scan(&count)
+	if count == 0 {
+		s.db.Exec(`ALTER TABLE users ADD COLUMN title TEXT`)
+		s.db.Exec(`ALTER TABLE users ADD COLUMN pronouns TEXT`)
+		s.db.Exec(`ALTER TABLE users ADD COLUMN phone TEXT`)
+		s.db.Exec(`ALTER TABLE users ADD COLUMN timezone TEXT`)
+	}
+
+	// Add deleted_at column to users table if it doesn't exist
+	s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users') WHERE name='deleted_at'`).Scan(&count)
+	if count == 0 {
+		s.db.Exec(`ALTER TABLE users ADD COLUMN deleted_at DATETIME`)
+	}
+
+	// Add email_verified_at column to users table if it doesn't exist
+	s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users') WHERE name='email_verified_at'`).Scan(&count)
+	if count == 0 {
+		s.db.Exec(`ALTER TABLE users ADD COLUMN email_verified_at DATETIME`)
+	}
+
+	// Drop sidebar section items for channels their user has left or that were archived
+	s.runOnce("section_items_left_channels", `
+		DELETE FROM channel_section_items
+		WHERE NOT EXISTS (SELECT 1 FROM channel_members m WHERE m.channel_id = channel_section_items.channel_id AND m.user_id = channel_section_items.user_id)
+		OR channel_id IN (SELECT id FROM channels WHERE archived_at IS NOT NULL)
+	`)
+
+	// DMs have no roles, so every participant is an owner
+	s.runOnce("dm_members_owners", `
+		UPDATE channel_members SET role = 'owner'
+		WHERE role != 'owner' AND channel_id IN (SELECT id FROM channels WHERE is_direct = TRUE)
+	`)
+
+	// Move boolean channel mutes over to notification levels and drop the old table
+	s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='muted_channels'`).Scan(&count)
+	if count > 0 {
+		_, err := s.db.Exec(`
+			INSERT OR IGNORE INTO channel_notification_settings (user_id, channel_id, level, updated_at)
+			SELECT user_id, channel_id, 'nothing', muted_at FROM muted_channels
+		`)
+		if err == nil {
+			s.db.Exec(`DROP TABLE muted_channels`)
+		}
+	}
+}
+
+// runOnce runs a data migration that has no schema change to check for, recording it
+// so it's skipped on later starts
+func (s *Store) runOnce(name, query string) {
+	var count int
+	s.db.QueryRow(`SELECT COUNT(*) FROM data_migrations WHERE name = ?`, name).Scan(&count)
+	if count > 0 {
+		return
+	}
+	if _, err := s.db.Exec(query); err != nil {
+		return
+	}
+	s.db.Exec(`INSERT INTO data_migrations (name) VALUES (?)`, name)
+}
+
+// backfillDMKeys sets dm_key on direct message channels created before group DMs existed.
+// Bot DM channels are tracked in bot_channels and are left unkeyed.
+func (s *Store) backfillDMKeys() {
+	rows, err := s.db.Query(`
+		SELECT id FROM channels
+		WHERE is_direct = TRUE AND dm_key IS NULL
+		AND id NOT IN (SELECT channel_id FROM bot_channels)
+	`)
+	if err != nil {
+		return
+	}
+	var channelIDs []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			channelIDs = append(channelIDs, id)
+		}
+	}
+	rows.Close()
+
+	for _, channelID := range channelIDs {
+		members, err := s.GetChannelMembers(channelID)
+		if err != nil || len(members) == 0 {
+			continue
+		}
+		memberIDs := make([]string, len(members))
+		for i, m := range members {
+			memberIDs[i] = m.ID
+		}
+		s.db.Exec("UPDATE channels SET dm_key = ? WHERE id = ?", dmKey(memberIDs), channelID)
+	}
 }
 
 // GetSmackbot returns the Smackbot system user
@@ -370,29 +927,26 @@ func (s *Store) GetOrCreateSmackbotDM(userID string) (*models.Channel, error) {
 	}
 
 	// Check if DM channel already exists
-	var channelID string
-	err = s.db.QueryRow(`
-		SELECT c.id FROM channels c
-		JOIN channel_members cm1 ON c.id = cm1.channel_id AND cm1.user_id = ?
-		JOIN channel_members cm2 ON c.id = cm2.channel_id AND cm2.user_id = ?
-		WHERE c.is_direct = TRUE
-	`, smackbot.ID, userID).Scan(&channelID)
-
-	if err == nil {
-		return s.GetChannel(channelID)
+	memberIDs := []string{smackbot.ID, userID}
+	if channel, err := s.getDMChannelByKey(dmKey(memberIDs)); err == nil {
+		return channel, nil
 	}
 
 	// Create new DM channel with Smackbot
-	channelName := "dm-smackbot-" + userID[:8]
-	channel, err := s.CreateChannel(channelName, "", smackbot.ID, true)
+	return s.createDMChannel("dm-smackbot-"+userID[:8], smackbot.ID, memberIDs)
+}
+
+// SendSmackbotDM posts a message from Smackbot in its DM with the user
+func (s *Store) SendSmackbotDM(userID, content string) (*models.Message, error) {
+	smackbot, err := s.GetSmackbot()
 	if err != nil {
 		return nil, err
 	}
-
-	// Add the user to the channel
-	s.JoinChannel(channel.ID, userID)
-
-	return channel, nil
+	dm, err := s.GetOrCreateSmackbotDM(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.CreateMessage(dm.ID, smackbot.ID, content, nil)
 }
 
 func (s *Store) Close() error {
@@ -401,6 +955,7 @@ func (s *Store) Close() error {
 
 // User operations
 
+// CreateUser registers a person. The first person to register becomes the server owner.
 func (s *Store) CreateUser(username, displayName, password string) (*models.User, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -417,77 +972,319 @@ func (s *Store) CreateUser(username, displayName, password string) (*models.User
 	}
 
 	_, err = s.db.Exec(`
-		INSERT INTO users (id, username, display_name, password_hash, status, created_at)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO users (id, username, display_name, password_hash, status, role, created_at)
+		VALUES (?, ?, ?, ?, ?, CASE WHEN EXISTS (SELECT 1 FROM users WHERE role = 'owner') THEN 'member' ELSE 'owner' END, ?)
 	`, user.ID, user.Username, user.DisplayName, user.PasswordHash, user.Status, user.CreatedAt)
 
 	if err != nil {
 		return nil, err
 	}
 
-	// Auto-join the general channel
-	var generalID string
-	s.db.QueryRow("SELECT id FROM channels WHERE name = 'general'").Scan(&generalID)
-	if generalID != "" {
-		s.JoinChannel(generalID, user.ID)
-	}
-
+	s.db.QueryRow("SELECT role FROM users WHERE id = ?", user.ID).Scan(&user.Role)
 	return user, nil
 }
 
-func (s *Store) GetUserByUsername(username string) (*models.User, error) {
-	user := &models.User{}
-	err := s.db.QueryRow(`
-		SELECT id, username, display_name, password_hash, COALESCE(avatar_url, ''), status, created_at
-		FROM users WHERE username = ?
-	`, username).Scan(&user.ID, &user.Username, &user.DisplayName, &user.PasswordHash, &user.AvatarURL, &user.Status, &user.CreatedAt)
+const userColumns = `id, username, display_name, password_hash, COALESCE(email, ''), COALESCE(avatar_url, ''),
+	COALESCE(title, ''), COALESCE(pronouns, ''), COALESCE(phone, ''), COALESCE(timezone, ''), status,
+	COALESCE(status_emoji, ''), COALESCE(status_text, ''), status_expires_at, last_seen_at, COALESCE(role, 'member'), deactivated_at, deleted_at, email_verified_at, created_at`
 
+func scanUser(row interface{ Scan(...interface{}) error }) (*models.User, error) {
+	user := &models.User{}
+	var deactivatedAt, deletedAt, emailVerifiedAt, statusExpiresAt, lastSeenAt sql.NullTime
+	var statusEmoji, statusText string
+	err := row.Scan(&user.ID, &user.Username, &user.DisplayName, &user.PasswordHash, &user.Email, &user.AvatarURL,
+		&user.Title, &user.Pronouns, &user.Phone, &user.Timezone, &user.Status,
+		&statusEmoji, &statusText, &statusExpiresAt, &lastSeenAt, &user.Role, &deactivatedAt, &deletedAt, &emailVerifiedAt, &user.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
-	return user, nil
-}
+	if deactivatedAt.Valid {
+		user.DeactivatedAt = &deactivatedAt.Time
+	}
+	if deletedAt.Valid {
+		user.DeletedAt = &deletedAt.Time
+	}
+	if emailVerifiedAt.Valid {
+		user.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+	if lastSeenAt.Valid {
+		user.LastSeenAt = &lastSeenAt.Time
+	}
+	user.CustomStatus = customStatus(statusEmoji, statusText, statusExpiresAt)
+	return user, nil
+}
+
+// customStatus builds a custom status from its columns, or nil if none is set or it
+// has expired but not been cleared yet
+func customStatus(emoji, text string, expiresAt sql.NullTime) *models.CustomStatus {
+	if emoji == "" && text == "" {
+		return nil
+	}
+	if expiresAt.Valid && !expiresAt.Time.After(time.Now()) {
+		return nil
+	}
+	status := &models.CustomStatus{Emoji: emoji, Text: text}
+	if expiresAt.Valid {
+		status.ExpiresAt = &expiresAt.Time
+	}
+	return status
+}
+
+func (s *Store) GetUserByUsername(username string) (*models.User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = ?`, username))
+}
 
 func (s *Store) GetUserByID(id string) (*models.User, error) {
-	user := &models.User{}
-	err := s.db.QueryRow(`
-		SELECT id, username, display_name, password_hash, COALESCE(avatar_url, ''), status, created_at
-		FROM users WHERE id = ?
-	`, id).Scan(&user.ID, &user.Username, &user.DisplayName, &user.PasswordHash, &user.AvatarURL, &user.Status, &user.CreatedAt)
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// GetUserByEmail finds a user by email, ignoring case
+func (s *Store) GetUserByEmail(email string) (*models.User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = ? COLLATE NOCASE`, email))
+}
 
+func (s *Store) GetAllUsers() ([]models.User, error) {
+	rows, err := s.db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY username`)
 	if err != nil {
 		return nil, err
 	}
-	return user, nil
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, nil
 }
 
-func (s *Store) GetAllUsers() ([]models.User, error) {
+// userPrefixMatch matches users whose username, or any word of their display name,
+// starts with the pattern's prefix
+const userPrefixMatch = `(LOWER(username) LIKE ? ESCAPE '\' OR LOWER(display_name) LIKE ? ESCAPE '\' OR LOWER(display_name) LIKE ? ESCAPE '\')`
+
+func userPrefixArgs(prefix string) []interface{} {
+	p := escapeLike(strings.ToLower(strings.TrimPrefix(strings.TrimSpace(prefix), "@")))
+	return []interface{}{p + "%", p + "%", "% " + p + "%"}
+}
+
+// GetUserDirectory lists users by username with prefix search, filters and keyset
+// pagination. Pass the returned NextCursor back in query.Cursor for the next page.
+func (s *Store) GetUserDirectory(query models.UserDirectoryQuery) (*models.UserDirectoryPage, error) {
+	where := []string{}
+	args := []interface{}{}
+
+	if query.Deactivated {
+		where = append(where, "deactivated_at IS NOT NULL")
+	} else {
+		where = append(where, "deactivated_at IS NULL")
+	}
+	switch query.Kind {
+	case models.UserKindHumans:
+		where = append(where, "password_hash != ''")
+	case models.UserKindBots:
+		where = append(where, "password_hash = ''")
+	}
+	if query.OnlineOnly {
+		where = append(where, "status != 'offline'")
+	}
+	if strings.TrimSpace(query.Query) != "" {
+		where = append(where, userPrefixMatch)
+		args = append(args, userPrefixArgs(query.Query)...)
+	}
+	if query.Cursor != "" {
+		cursor, err := decodeDirectoryCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, "(username, id) > (?, ?)")
+		args = append(args, cursor.Value, cursor.ID)
+	}
+	args = append(args, query.Limit+1)
+
 	rows, err := s.db.Query(`
-		SELECT id, username, display_name, COALESCE(avatar_url, ''), status, created_at
-		FROM users ORDER BY username
-	`)
+		SELECT `+userColumns+` FROM users
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY username, id LIMIT ?
+	`, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var users []models.User
+	page := &models.UserDirectoryPage{Users: []models.DirectoryUser{}}
 	for rows.Next() {
-		var u models.User
-		err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL, &u.Status, &u.CreatedAt)
+		if len(page.Users) == query.Limit {
+			last := page.Users[len(page.Users)-1]
+			page.NextCursor = encodeDirectoryCursor(directoryCursor{Value: last.Username, ID: last.ID})
+			break
+		}
+
+		u, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
-		users = append(users, u)
+		page.Users = append(page.Users, models.DirectoryUser{UserResponse: u.ToResponse(), IsBot: u.IsBot()})
 	}
-	return users, nil
+	return page, nil
+}
+
+// SuggestUsers returns active users matching a mention prefix. Members of channelID,
+// if given, come first, then people before bots.
+func (s *Store) SuggestUsers(prefix, channelID string, limit int) ([]models.UserSuggestion, error) {
+	args := append(userPrefixArgs(prefix), channelID, limit)
+
+	rows, err := s.db.Query(`
+		SELECT id, username, display_name, COALESCE(avatar_url, ''), password_hash = ''
+		FROM users
+		WHERE deactivated_at IS NULL AND `+userPrefixMatch+`
+		ORDER BY EXISTS (SELECT 1 FROM channel_members cm WHERE cm.channel_id = ? AND cm.user_id = users.id) DESC,
+			password_hash = '', username
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []models.UserSuggestion{}
+	for rows.Next() {
+		var u models.UserSuggestion
+		if err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL, &u.IsBot); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, u)
+	}
+	return suggestions, nil
+}
+
+// SetUserEmail changes a user's email, which is no longer verified if it changed
+func (s *Store) SetUserEmail(userID, email string) error {
+	_, err := s.db.Exec(`
+		UPDATE users SET
+			email_verified_at = CASE WHEN email = ? COLLATE NOCASE THEN email_verified_at ELSE NULL END,
+			email = ?
+		WHERE id = ?
+	`, email, email, userID)
+	return err
+}
+
+// MarkEmailVerified records that the user has proven they own their email address
+func (s *Store) MarkEmailVerified(userID string) error {
+	_, err := s.db.Exec("UPDATE users SET email_verified_at = ? WHERE id = ?", time.Now(), userID)
+	return err
+}
+
+func (s *Store) SetUserRole(userID, role string) error {
+	_, err := s.db.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID)
+	return err
+}
+
+// CountActiveOwners returns how many server owners haven't been deactivated
+func (s *Store) CountActiveOwners() (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE role = 'owner' AND deactivated_at IS NULL").Scan(&count)
+	return count, err
+}
+
+// DeactivateUser blocks a user from signing in and revokes their sessions,
+// returning the revoked session IDs
+func (s *Store) DeactivateUser(userID string) ([]string, error) {
+	if _, err := s.db.Exec("UPDATE users SET deactivated_at = ?, status = 'offline', presence = 'offline' WHERE id = ? AND deactivated_at IS NULL", time.Now(), userID); err != nil {
+		return nil, err
+	}
+	return s.RevokeUserSessions(userID, "")
+}
+
+func (s *Store) ReactivateUser(userID string) error {
+	_, err := s.db.Exec("UPDATE users SET deactivated_at = NULL WHERE id = ?", userID)
+	return err
+}
+
+func (s *Store) UpdateUserPassword(userID, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", string(hash), userID)
+	return err
 }
 
+// effectiveStatus works out the status others see: offline while the user isn't
+// connected, otherwise whatever they picked, otherwise away while idle, otherwise online
+const effectiveStatus = `CASE WHEN presence = 'offline' THEN 'offline'
+	WHEN COALESCE(manual_status, '') != '' THEN manual_status
+	WHEN presence = 'away' THEN 'away' ELSE 'online' END`
+
+// UpdateUserStatus sets the status a user picked; "online" clears it. It's kept
+// across connects and disconnects.
 func (s *Store) UpdateUserStatus(userID, status string) error {
-	_, err := s.db.Exec("UPDATE users SET status = ? WHERE id = ?", status, userID)
+	if status == "online" {
+		status = ""
+	}
+	if _, err := s.db.Exec("UPDATE users SET manual_status = ? WHERE id = ?", status, userID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("UPDATE users SET status = "+effectiveStatus+" WHERE id = ?", userID)
+	return err
+}
+
+// SetUserPresence records whether a user is connected and active ("online"),
+// connected but idle ("away") or not connected ("offline") without touching the
+// status they picked
+func (s *Store) SetUserPresence(userID, presence string) error {
+	if _, err := s.db.Exec("UPDATE users SET presence = ? WHERE id = ?", presence, userID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("UPDATE users SET status = "+effectiveStatus+" WHERE id = ?", userID)
+	return err
+}
+
+// TouchLastSeen records that a user was active. It writes at most once a minute.
+func (s *Store) TouchLastSeen(userID string) error {
+	now := time.Now()
+	_, err := s.db.Exec("UPDATE users SET last_seen_at = ? WHERE id = ? AND (last_seen_at IS NULL OR last_seen_at < ?)",
+		now, userID, now.Add(-time.Minute))
+	return err
+}
+
+func (s *Store) SetCustomStatus(userID string, status *models.CustomStatus) error {
+	_, err := s.db.Exec("UPDATE users SET status_emoji = ?, status_text = ?, status_expires_at = ? WHERE id = ?",
+		status.Emoji, status.Text, status.ExpiresAt, userID)
+	return err
+}
+
+func (s *Store) ClearCustomStatus(userID string) error {
+	_, err := s.db.Exec("UPDATE users SET status_emoji = NULL, status_text = NULL, status_expires_at = NULL WHERE id = ?", userID)
 	return err
 }
 
+// ClearExpiredCustomStatuses clears custom statuses whose expiry has passed and
+// returns the IDs of the users they belonged to
+func (s *Store) ClearExpiredCustomStatuses() ([]string, error) {
+	rows, err := s.db.Query(`
+		UPDATE users SET status_emoji = NULL, status_text = NULL, status_expires_at = NULL
+		WHERE status_expires_at IS NOT NULL AND status_expires_at <= ?
+		RETURNING id
+	`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
+
 func (s *Store) UpdateUserAvatar(userID, avatarURL string) error {
 	_, err := s.db.Exec("UPDATE users SET avatar_url = ? WHERE id = ?", avatarURL, userID)
 	return err
@@ -498,6 +1295,20 @@ func (s *Store) UpdateUserDisplayName(userID, displayName string) error {
 	return err
 }
 
+// UpdateUserProfile sets a user's title, pronouns, phone and timezone; nil
+// values are left unchanged
+func (s *Store) UpdateUserProfile(userID string, title, pronouns, phone, timezone *string) error {
+	_, err := s.db.Exec(`
+		UPDATE users SET
+			title = COALESCE(?, title),
+			pronouns = COALESCE(?, pronouns),
+			phone = COALESCE(?, phone),
+			timezone = COALESCE(?, timezone)
+		WHERE id = ?
+	`, title, pronouns, phone, timezone, userID)
+	return err
+}
+
 func (s *Store) ValidatePassword(user *models.User, password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 	return err == nil
@@ -513,6 +1324,8 @@ func (s *Store) CreateChannel(name, description, createdBy string, isDirect bool
 		IsDirect:    isDirect,
 		CreatedBy:   createdBy,
 		CreatedAt:   time.Now(),
+
+		JoinLeaveMessages: true,
 	}
 
 	_, err := s.db.Exec(`
@@ -524,44 +1337,146 @@ func (s *Store) CreateChannel(name, description, createdBy string, isDirect bool
 		return nil, err
 	}
 
-	// Creator auto-joins
-	s.JoinChannel(channel.ID, createdBy)
+	// Creator auto-joins as owner
+	s.JoinChannelWithRole(channel.ID, createdBy, models.ChannelRoleOwner)
 
 	return channel, nil
 }
 
 func (s *Store) GetChannel(id string) (*models.Channel, error) {
 	channel := &models.Channel{}
+	var archivedAt sql.NullTime
 	err := s.db.QueryRow(`
-		SELECT id, name, COALESCE(description, ''), is_direct, created_by, created_at
+		SELECT id, name, COALESCE(description, ''), COALESCE(topic, ''), is_direct, created_by, created_at, archived_at,
+			   COALESCE(join_leave_messages, TRUE)
 		FROM channels WHERE id = ?
-	`, id).Scan(&channel.ID, &channel.Name, &channel.Description, &channel.IsDirect, &channel.CreatedBy, &channel.CreatedAt)
+	`, id).Scan(&channel.ID, &channel.Name, &channel.Description, &channel.Topic, &channel.IsDirect, &channel.CreatedBy, &channel.CreatedAt, &archivedAt,
+		&channel.JoinLeaveMessages)
 
 	if err != nil {
 		return nil, err
 	}
+	if archivedAt.Valid {
+		channel.ArchivedAt = &archivedAt.Time
+	}
 	return channel, nil
 }
 
-func (s *Store) UpdateChannel(id, name, description string) error {
+// ArchiveChannel makes a channel read-only and takes it out of everyone's sidebar sections
+func (s *Store) ArchiveChannel(id, userID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE channels SET archived_at = ?, archived_by = ? WHERE id = ?", time.Now(), userID, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM channel_section_items WHERE channel_id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) UnarchiveChannel(id string) error {
+	_, err := s.db.Exec("UPDATE channels SET archived_at = NULL, archived_by = NULL WHERE id = ?", id)
+	return err
+}
+
+func (s *Store) IsChannelArchived(id string) bool {
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM channels WHERE id = ? AND archived_at IS NOT NULL", id).Scan(&count)
+	return count > 0
+}
+
+func (s *Store) UpdateChannel(id, name, description, topic string) error {
 	_, err := s.db.Exec(`
-		UPDATE channels SET name = ?, description = ? WHERE id = ?
-	`, name, description, id)
+		UPDATE channels SET name = ?, description = ?, topic = ? WHERE id = ?
+	`, name, description, topic, id)
+	return err
+}
+
+// SetChannelJoinLeaveMessages turns the system messages for members joining and leaving on or off
+func (s *Store) SetChannelJoinLeaveMessages(id string, enabled bool) error {
+	_, err := s.db.Exec("UPDATE channels SET join_leave_messages = ? WHERE id = ?", enabled, id)
 	return err
 }
 
-func (s *Store) GetChannelsForUser(userID string) ([]models.ChannelWithUnread, error) {
+// RecordChannelChange adds an entry to a channel's metadata history
+func (s *Store) RecordChannelChange(channelID, userID, field, oldValue, newValue string) (*models.ChannelChange, error) {
+	change := &models.ChannelChange{
+		ID:        uuid.New().String(),
+		ChannelID: channelID,
+		UserID:    userID,
+		Field:     field,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		CreatedAt: time.Now(),
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO channel_changes (id, channel_id, user_id, field, old_value, new_value, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, change.ID, change.ChannelID, change.UserID, change.Field, change.OldValue, change.NewValue, change.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// GetChannelChanges returns a channel's metadata history, newest first
+func (s *Store) GetChannelChanges(channelID string, limit int) ([]models.ChannelChange, error) {
 	rows, err := s.db.Query(`
-		SELECT c.id, c.name, COALESCE(c.description, ''), c.is_direct, c.created_by, c.created_at,
+		SELECT cc.id, cc.channel_id, cc.user_id, cc.field, COALESCE(cc.old_value, ''), COALESCE(cc.new_value, ''), cc.created_at,
+			   u.id, u.username, u.display_name, COALESCE(u.avatar_url, ''), u.status, u.created_at
+		FROM channel_changes cc
+		JOIN users u ON cc.user_id = u.id
+		WHERE cc.channel_id = ?
+		ORDER BY cc.created_at DESC
+		LIMIT ?
+	`, channelID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []models.ChannelChange
+	for rows.Next() {
+		var c models.ChannelChange
+		var user models.User
+		err := rows.Scan(&c.ID, &c.ChannelID, &c.UserID, &c.Field, &c.OldValue, &c.NewValue, &c.CreatedAt,
+			&user.ID, &user.Username, &user.DisplayName, &user.AvatarURL, &user.Status, &user.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		userResp := user.ToResponse()
+		c.User = &userResp
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// GetChannelsForUser returns the channels a user belongs to. Archived channels are
+// left out unless includeArchived is set.
+func (s *Store) GetChannelsForUser(userID string, includeArchived bool) ([]models.ChannelWithUnread, error) {
+	query := `
+		SELECT c.id, c.name, COALESCE(c.description, ''), COALESCE(c.topic, ''), c.is_direct, c.created_by, c.created_at, c.archived_at,
 			   (SELECT COUNT(*) FROM messages m
 			    WHERE m.channel_id = c.id
 			    AND m.thread_id IS NULL
-			    AND m.created_at > COALESCE(cm.last_read_at, '1970-01-01')) as unread_count
+			    AND m.created_at > COALESCE(cm.last_read_at, '1970-01-01')) as unread_count,
+			   csi.section_id, csi.position
 		FROM channels c
 		JOIN channel_members cm ON c.id = cm.channel_id
-		WHERE cm.user_id = ?
-		ORDER BY c.name
-	`, userID)
+		LEFT JOIN channel_section_items csi ON csi.channel_id = c.id AND csi.user_id = cm.user_id
+		WHERE cm.user_id = ?`
+	if !includeArchived {
+		query += ` AND c.archived_at IS NULL`
+	}
+	query += ` ORDER BY c.name`
+
+	rows, err := s.db.Query(query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -570,19 +1485,34 @@ func (s *Store) GetChannelsForUser(userID string) ([]models.ChannelWithUnread, e
 	var channels []models.ChannelWithUnread
 	for rows.Next() {
 		var c models.ChannelWithUnread
-		err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.IsDirect, &c.CreatedBy, &c.CreatedAt, &c.UnreadCount)
+		var archivedAt sql.NullTime
+		var sectionID sql.NullString
+		var sectionPosition sql.NullInt64
+		err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.Topic, &c.IsDirect, &c.CreatedBy, &c.CreatedAt, &archivedAt, &c.UnreadCount,
+			&sectionID, &sectionPosition)
 		if err != nil {
 			return nil, err
 		}
+		if archivedAt.Valid {
+			c.ArchivedAt = &archivedAt.Time
+		}
+		if sectionID.Valid {
+			position := int(sectionPosition.Int64)
+			c.SectionID = &sectionID.String
+			c.SectionPosition = &position
+		}
 		channels = append(channels, c)
 	}
 
-	// For DM channels, replace the name with the other participant's display name
+	// For DM channels, name the channel after the other participants and list them
 	for i, c := range channels {
 		if c.IsDirect {
-			otherUser, err := s.GetDMOtherParticipant(c.ID, userID)
-			if err == nil && otherUser != nil {
-				channels[i].Name = otherUser.DisplayName
+			participants, err := s.GetDMParticipants(c.ID, userID)
+			if err == nil && len(participants) > 0 {
+				channels[i].Name = dmDisplayName(participants)
+				for _, p := range participants {
+					channels[i].Members = append(channels[i].Members, p.ToResponse())
+				}
 			}
 		}
 	}
@@ -590,109 +1520,446 @@ func (s *Store) GetChannelsForUser(userID string) ([]models.ChannelWithUnread, e
 	return channels, nil
 }
 
-// GetDMOtherParticipant returns the other user in a DM channel
-func (s *Store) GetDMOtherParticipant(channelID, currentUserID string) (*models.User, error) {
-	var user models.User
-	err := s.db.QueryRow(`
+// GetDMParticipants returns the users in a DM channel other than the current user
+func (s *Store) GetDMParticipants(channelID, currentUserID string) ([]models.User, error) {
+	rows, err := s.db.Query(`
 		SELECT u.id, u.username, u.display_name, COALESCE(u.avatar_url, ''), u.status, u.created_at
 		FROM users u
 		JOIN channel_members cm ON u.id = cm.user_id
 		WHERE cm.channel_id = ? AND u.id != ?
-		LIMIT 1
-	`, channelID, currentUserID).Scan(&user.ID, &user.Username, &user.DisplayName, &user.AvatarURL, &user.Status, &user.CreatedAt)
+		ORDER BY u.display_name
+	`, channelID, currentUserID)
 	if err != nil {
 		return nil, err
 	}
-	return &user, nil
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL, &u.Status, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// dmDisplayName builds a DM channel name from its participants, e.g. "Alice, Bob"
+func dmDisplayName(participants []models.User) string {
+	names := make([]string, len(participants))
+	for i, p := range participants {
+		names[i] = p.DisplayName
+	}
+	return strings.Join(names, ", ")
 }
 
 func (s *Store) GetPublicChannels() ([]models.Channel, error) {
-	rows, err := s.db.Query(`
-		SELECT id, name, COALESCE(description, ''), is_direct, created_by, created_at
+	return s.queryChannels(`
+		SELECT id, name, COALESCE(description, ''), COALESCE(topic, ''), is_direct, created_by, created_at, archived_at,
+			   COALESCE(join_leave_messages, TRUE)
 		FROM channels WHERE is_direct = FALSE
 		ORDER BY name
 	`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+}
 
-	var channels []models.Channel
-	for rows.Next() {
-		var c models.Channel
-		err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.IsDirect, &c.CreatedBy, &c.CreatedAt)
+// directoryCursor marks the last row of a directory page: its sort key and channel ID
+type directoryCursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// GetChannelDirectory lists non-direct channels for browsing, with search, sorting and
+// keyset pagination. Pass the returned NextCursor back in query.Cursor for the next page.
+func (s *Store) GetChannelDirectory(userID string, query models.ChannelDirectoryQuery) (*models.ChannelDirectoryPage, error) {
+	// Each sort is a key column plus the channel ID as a tie-breaker
+	sortKey, desc := "name", false
+	switch query.Sort {
+	case models.DirectorySortMembers:
+		sortKey, desc = "member_count", true
+	case models.DirectorySortActivity:
+		sortKey, desc = "activity_at", true
+	case models.DirectorySortCreated:
+		sortKey, desc = "created_at", true
+	}
+
+	where := []string{"c.is_direct = FALSE"}
+	args := []interface{}{userID}
+	if !query.IncludeArchived {
+		where = append(where, "c.archived_at IS NULL")
+	}
+	if q := strings.TrimSpace(query.Query); q != "" {
+		pattern := "%" + escapeLike(strings.ToLower(q)) + "%"
+		where = append(where, `(LOWER(c.name) LIKE ? ESCAPE '\' OR LOWER(COALESCE(c.description, '')) LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+
+	stmt := `
+		WITH directory AS (
+			SELECT c.id, c.name, COALESCE(c.description, '') AS description, COALESCE(c.topic, '') AS topic,
+				   c.is_direct, c.created_by, c.created_at, c.archived_at,
+				   COALESCE(c.join_leave_messages, TRUE) AS join_leave_messages,
+				   (SELECT COUNT(*) FROM channel_members cm WHERE cm.channel_id = c.id) AS member_count,
+				   (SELECT MAX(m.created_at) FROM messages m WHERE m.channel_id = c.id) AS last_activity_at,
+				   COALESCE((SELECT MAX(m.created_at) FROM messages m WHERE m.channel_id = c.id), c.created_at) AS activity_at,
+				   EXISTS (SELECT 1 FROM channel_members cm WHERE cm.channel_id = c.id AND cm.user_id = ?) AS is_member
+			FROM channels c
+			WHERE ` + strings.Join(where, " AND ") + `
+		)
+		SELECT id, name, description, topic, is_direct, created_by, created_at, archived_at, join_leave_messages,
+			   member_count, last_activity_at, CAST(` + sortKey + ` AS TEXT), is_member
+		FROM directory`
+
+	op, order := ">", "ASC"
+	if desc {
+		op, order = "<", "DESC"
+	}
+	if query.Cursor != "" {
+		cursor, err := decodeDirectoryCursor(query.Cursor)
 		if err != nil {
 			return nil, err
 		}
-		channels = append(channels, c)
+		var value interface{} = cursor.Value
+		if sortKey == "member_count" {
+			n, err := strconv.Atoi(cursor.Value)
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			value = n
+		}
+		stmt += ` WHERE (` + sortKey + `, id) ` + op + ` (?, ?)`
+		args = append(args, value, cursor.ID)
 	}
-	return channels, nil
-}
-
-func (s *Store) JoinChannel(channelID, userID string) error {
-	_, err := s.db.Exec(`
-		INSERT OR IGNORE INTO channel_members (channel_id, user_id, joined_at, last_read_at)
-		VALUES (?, ?, ?, ?)
-	`, channelID, userID, time.Now(), time.Now())
-	return err
-}
+	stmt += ` ORDER BY ` + sortKey + ` ` + order + `, id ` + order + ` LIMIT ?`
+	args = append(args, query.Limit+1)
 
-func (s *Store) GetChannelMembers(channelID string) ([]models.User, error) {
-	rows, err := s.db.Query(`
-		SELECT u.id, u.username, u.display_name, COALESCE(u.avatar_url, ''), u.status, u.created_at
-		FROM users u
-		JOIN channel_members cm ON u.id = cm.user_id
-		WHERE cm.channel_id = ?
-	`, channelID)
+	rows, err := s.db.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var users []models.User
+	page := &models.ChannelDirectoryPage{Channels: []models.DirectoryChannel{}}
+	var lastKey string
 	for rows.Next() {
-		var u models.User
-		err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL, &u.Status, &u.CreatedAt)
+		if len(page.Channels) == query.Limit {
+			last := page.Channels[len(page.Channels)-1]
+			page.NextCursor = encodeDirectoryCursor(directoryCursor{Value: lastKey, ID: last.ID})
+			break
+		}
+
+		var c models.DirectoryChannel
+		var archivedAt sql.NullTime
+		var lastActivity sql.NullString
+		err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.Topic, &c.IsDirect, &c.CreatedBy, &c.CreatedAt, &archivedAt,
+			&c.JoinLeaveMessages, &c.MemberCount, &lastActivity, &lastKey, &c.IsMember)
 		if err != nil {
 			return nil, err
 		}
-		users = append(users, u)
+		if archivedAt.Valid {
+			c.ArchivedAt = &archivedAt.Time
+		}
+		if lastActivity.Valid {
+			if t, ok := parseSQLiteTime(lastActivity.String); ok {
+				c.LastActivityAt = &t
+			}
+		}
+		page.Channels = append(page.Channels, c)
 	}
-	return users, nil
+	return page, nil
 }
 
-func (s *Store) GetOrCreateDMChannel(user1ID, user2ID string) (*models.Channel, error) {
-	// Check if DM channel already exists
-	var channelID string
+func encodeDirectoryCursor(c directoryCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeDirectoryCursor(cursor string) (directoryCursor, error) {
+	var c directoryCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || json.Unmarshal(data, &c) != nil || c.ID == "" {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// parseSQLiteTime parses a timestamp returned as text, e.g. from MAX() over a DATETIME column
+func parseSQLiteTime(value string) (time.Time, bool) {
+	value = strings.TrimSuffix(value, "Z")
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(format, value, time.UTC); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// GetArchivedChannels returns all archived non-direct channels, most recently archived first
+func (s *Store) GetArchivedChannels() ([]models.Channel, error) {
+	return s.queryChannels(`
+		SELECT id, name, COALESCE(description, ''), COALESCE(topic, ''), is_direct, created_by, created_at, archived_at,
+			   COALESCE(join_leave_messages, TRUE)
+		FROM channels WHERE is_direct = FALSE AND archived_at IS NOT NULL
+		ORDER BY archived_at DESC
+	`)
+}
+
+func (s *Store) queryChannels(query string, args ...interface{}) ([]models.Channel, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channels []models.Channel
+	for rows.Next() {
+		var c models.Channel
+		var archivedAt sql.NullTime
+		err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.Topic, &c.IsDirect, &c.CreatedBy, &c.CreatedAt, &archivedAt, &c.JoinLeaveMessages)
+		if err != nil {
+			return nil, err
+		}
+		if archivedAt.Valid {
+			c.ArchivedAt = &archivedAt.Time
+		}
+		channels = append(channels, c)
+	}
+	return channels, nil
+}
+
+func (s *Store) JoinChannel(channelID, userID string) error {
+	return s.JoinChannelWithRole(channelID, userID, models.ChannelRoleMember)
+}
+
+// JoinChannelWithRole adds a user to a channel with the given role. Existing members keep their role.
+func (s *Store) JoinChannelWithRole(channelID, userID, role string) error {
+	_, err := s.db.Exec(`
+		INSERT OR IGNORE INTO channel_members (channel_id, user_id, role, joined_at, last_read_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, channelID, userID, role, time.Now(), time.Now())
+	return err
+}
+
+func (s *Store) IsChannelMember(channelID, userID string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM channel_members WHERE channel_id = ? AND user_id = ?", channelID, userID).Scan(&count)
+	return count > 0, err
+}
+
+func (s *Store) GetChannelMemberRole(channelID, userID string) (string, error) {
+	var role string
 	err := s.db.QueryRow(`
-		SELECT c.id FROM channels c
-		JOIN channel_members cm1 ON c.id = cm1.channel_id AND cm1.user_id = ?
-		JOIN channel_members cm2 ON c.id = cm2.channel_id AND cm2.user_id = ?
-		WHERE c.is_direct = TRUE
-	`, user1ID, user2ID).Scan(&channelID)
+		SELECT COALESCE(role, 'member') FROM channel_members WHERE channel_id = ? AND user_id = ?
+	`, channelID, userID).Scan(&role)
+	return role, err
+}
 
-	if err == nil {
-		return s.GetChannel(channelID)
+func (s *Store) SetChannelMemberRole(channelID, userID, role string) error {
+	_, err := s.db.Exec("UPDATE channel_members SET role = ? WHERE channel_id = ? AND user_id = ?", role, channelID, userID)
+	return err
+}
+
+// TransferChannelOwnership makes a member the channel's only owner. Whoever owned it
+// before steps down to admin.
+func (s *Store) TransferChannelOwnership(channelID, userID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE channel_members SET role = ? WHERE channel_id = ? AND role = ? AND user_id != ?
+	`, models.ChannelRoleAdmin, channelID, models.ChannelRoleOwner, userID)
+	if err != nil {
+		return err
 	}
 
-	// Create new DM channel
-	user2, _ := s.GetUserByID(user2ID)
-	channelName := "dm-" + user1ID[:8] + "-" + user2ID[:8]
+	_, err = tx.Exec("UPDATE channel_members SET role = ? WHERE channel_id = ? AND user_id = ?", models.ChannelRoleOwner, channelID, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetChannelMembersWithRoles returns the members of a channel along with their channel roles
+func (s *Store) GetChannelMembersWithRoles(channelID string) ([]models.ChannelMemberResponse, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, u.display_name, COALESCE(u.avatar_url, ''), u.status,
+			COALESCE(u.status_emoji, ''), COALESCE(u.status_text, ''), u.status_expires_at, u.created_at,
+			COALESCE(cm.role, 'member')
+		FROM users u
+		JOIN channel_members cm ON u.id = cm.user_id
+		WHERE cm.channel_id = ?
+		ORDER BY cm.joined_at
+	`, channelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []models.ChannelMemberResponse
+	for rows.Next() {
+		var u models.User
+		var role, statusEmoji, statusText string
+		var statusExpiresAt sql.NullTime
+		err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL, &u.Status,
+			&statusEmoji, &statusText, &statusExpiresAt, &u.CreatedAt, &role)
+		if err != nil {
+			return nil, err
+		}
+		u.CustomStatus = customStatus(statusEmoji, statusText, statusExpiresAt)
+		members = append(members, models.ChannelMemberResponse{UserResponse: u.ToResponse(), Role: role})
+	}
+	return members, nil
+}
+
+func (s *Store) GetChannelMembers(channelID string) ([]models.User, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, u.display_name, COALESCE(u.avatar_url, ''), u.status, u.created_at
+		FROM users u
+		JOIN channel_members cm ON u.id = cm.user_id
+		WHERE cm.channel_id = ?
+	`, channelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL, &u.Status, &u.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+func (s *Store) GetOrCreateDMChannel(user1ID, user2ID string) (*models.Channel, error) {
+	return s.GetOrCreateGroupDM(user1ID, []string{user2ID})
+}
+
+// GetOrCreateGroupDM returns the direct message channel for the creator and the given
+// users, creating it if needed. The same set of members always maps to the same channel.
+func (s *Store) GetOrCreateGroupDM(createdBy string, userIDs []string) (*models.Channel, error) {
+	memberIDs := uniqueIDs(append([]string{createdBy}, userIDs...))
+	if len(memberIDs) < 2 {
+		return nil, fmt.Errorf("a direct message needs at least two members")
+	}
+	if len(memberIDs) > models.MaxGroupDMMembers {
+		return nil, fmt.Errorf("a direct message can have at most %d members", models.MaxGroupDMMembers)
+	}
+	for _, id := range memberIDs {
+		if id != createdBy && s.IsBlockedBetween(createdBy, id) {
+			return nil, ErrUserBlocked
+		}
+	}
+
+	channel, err := s.getDMChannelByKey(dmKey(memberIDs))
+	if err != nil {
+		// Create new DM channel
+		shortIDs := make([]string, len(memberIDs))
+		for i, id := range memberIDs {
+			shortIDs[i] = id[:8]
+		}
+		channel, err = s.createDMChannel("dm-"+strings.Join(shortIDs, "-"), createdBy, memberIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Set display name to the other participants' names
+	if participants, err := s.GetDMParticipants(channel.ID, createdBy); err == nil && len(participants) > 0 {
+		channel.Name = dmDisplayName(participants)
+	}
 
-	channel, err := s.CreateChannel(channelName, "", user1ID, true)
+	return channel, nil
+}
+
+// GetDMMemberIDs returns the member set of a keyed DM channel
+func (s *Store) GetDMMemberIDs(channelID string) ([]string, error) {
+	var key sql.NullString
+	err := s.db.QueryRow("SELECT dm_key FROM channels WHERE id = ? AND is_direct = TRUE", channelID).Scan(&key)
+	if err != nil {
+		return nil, err
+	}
+	if !key.Valid || key.String == "" {
+		return nil, sql.ErrNoRows
+	}
+	return strings.Split(key.String, ","), nil
+}
+
+func (s *Store) getDMChannelByKey(key string) (*models.Channel, error) {
+	var channelID string
+	err := s.db.QueryRow("SELECT id FROM channels WHERE is_direct = TRUE AND dm_key = ?", key).Scan(&channelID)
 	if err != nil {
 		return nil, err
 	}
+	return s.GetChannel(channelID)
+}
+
+// createDMChannel creates the DM for a member set. If another request created it
+// first, that channel is returned instead.
+func (s *Store) createDMChannel(name, createdBy string, memberIDs []string) (*models.Channel, error) {
+	channel := &models.Channel{
+		ID:        uuid.New().String(),
+		Name:      name,
+		IsDirect:  true,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+
+		JoinLeaveMessages: true,
+	}
+	key := dmKey(memberIDs)
 
-	// Set display name to other user's name
-	channel.Name = user2.DisplayName
+	result, err := s.db.Exec(`
+		INSERT INTO channels (id, name, description, is_direct, created_by, created_at, dm_key)
+		VALUES (?, ?, '', TRUE, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, channel.ID, channel.Name, channel.CreatedBy, channel.CreatedAt, key)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return s.getDMChannelByKey(key)
+	}
 
-	// Add second user
-	s.JoinChannel(channel.ID, user2ID)
+	// DMs have no roles: every participant can pin and clear
+	for _, id := range memberIDs {
+		s.JoinChannelWithRole(channel.ID, id, models.ChannelRoleOwner)
+	}
 
 	return channel, nil
 }
 
+// dmKey is the sorted, comma-separated member set that identifies a DM channel
+func dmKey(memberIDs []string) string {
+	ids := uniqueIDs(memberIDs)
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	var result []string
+	for _, id := range ids {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
 // Message operations
 
 func (s *Store) CreateMessage(channelID, userID, content string, threadID *string) (*models.Message, error) {
@@ -722,6 +1989,28 @@ func (s *Store) CreateMessageWithHTML(channelID, userID, content string, htmlCon
 	return msg, nil
 }
 
+// CreateSystemMessage posts an automated notice (renames, topic changes, ...) attributed to the acting user
+func (s *Store) CreateSystemMessage(channelID, userID, content string) (*models.Message, error) {
+	msg := &models.Message{
+		ID:        uuid.New().String(),
+		ChannelID: channelID,
+		UserID:    userID,
+		Content:   content,
+		Type:      models.MessageTypeSystem,
+		CreatedAt: time.Now(),
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO messages (id, channel_id, user_id, content, type, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, msg.ID, msg.ChannelID, msg.UserID, msg.Content, msg.Type, msg.CreatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
 func (s *Store) UpdateMessageContent(messageID, content string) error {
 	_, err := s.db.Exec(`UPDATE messages SET content = ? WHERE id = ?`, content, messageID)
 	return err
@@ -738,7 +2027,7 @@ func (s *Store) GetChannelMessagesBefore(channelID string, limit int, before *ti
 
 	if before != nil {
 		rows, err = s.db.Query(`
-			SELECT m.id, m.channel_id, m.user_id, m.content, m.html_content, m.widget_size, m.thread_id, m.created_at,
+			SELECT m.id, m.channel_id, m.user_id, m.content, m.html_content, m.widget_size, m.thread_id, m.created_at, COALESCE(m.type, ''),
 				   u.id, u.username, u.display_name, COALESCE(u.avatar_url, ''), u.status, u.created_at,
 				   (SELECT COUNT(*) FROM messages WHERE thread_id = m.id) as reply_count,
 				   (SELECT MAX(created_at) FROM messages WHERE thread_id = m.id) as latest_reply
@@ -750,7 +2039,7 @@ func (s *Store) GetChannelMessagesBefore(channelID string, limit int, before *ti
 		`, channelID, before.Format("2006-01-02 15:04:05.999999"), limit)
 	} else {
 		rows, err = s.db.Query(`
-			SELECT m.id, m.channel_id, m.user_id, m.content, m.html_content, m.widget_size, m.thread_id, m.created_at,
+			SELECT m.id, m.channel_id, m.user_id, m.content, m.html_content, m.widget_size, m.thread_id, m.created_at, COALESCE(m.type, ''),
 				   u.id, u.username, u.display_name, COALESCE(u.avatar_url, ''), u.status, u.created_at,
 				   (SELECT COUNT(*) FROM messages WHERE thread_id = m.id) as reply_count,
 				   (SELECT MAX(created_at) FROM messages WHERE thread_id = m.id) as latest_reply
@@ -776,7 +2065,7 @@ func (s *Store) GetChannelMessagesBefore(channelID string, limit int, before *ti
 		var latestReplyStr sql.NullString
 
 		err := rows.Scan(
-			&msg.ID, &msg.ChannelID, &msg.UserID, &msg.Content, &htmlContent, &widgetSize, &threadID, &msg.CreatedAt,
+			&msg.ID, &msg.ChannelID, &msg.UserID, &msg.Content, &htmlContent, &widgetSize, &threadID, &msg.CreatedAt, &msg.Type,
 			&user.ID, &user.Username, &user.DisplayName, &user.AvatarURL, &user.Status, &user.CreatedAt,
 			&msg.ReplyCount, &latestReplyStr,
 		)
@@ -814,7 +2103,7 @@ func (s *Store) GetChannelMessagesBefore(channelID string, limit int, before *ti
 func (s *Store) GetThreadMessages(threadID string) ([]models.MessageWithUser, error) {
 	// First get the parent message
 	rows, err := s.db.Query(`
-		SELECT m.id, m.channel_id, m.user_id, m.content, m.html_content, m.widget_size, m.thread_id, m.created_at,
+		SELECT m.id, m.channel_id, m.user_id, m.content, m.html_content, m.widget_size, m.thread_id, m.created_at, COALESCE(m.type, ''),
 			   u.id, u.username, u.display_name, COALESCE(u.avatar_url, ''), u.status, u.created_at
 		FROM messages m
 		JOIN users u ON m.user_id = u.id
@@ -835,7 +2124,7 @@ func (s *Store) GetThreadMessages(threadID string) ([]models.MessageWithUser, er
 		var tid sql.NullString
 
 		err := rows.Scan(
-			&msg.ID, &msg.ChannelID, &msg.UserID, &msg.Content, &htmlContent, &widgetSize, &tid, &msg.CreatedAt,
+			&msg.ID, &msg.ChannelID, &msg.UserID, &msg.Content, &htmlContent, &widgetSize, &tid, &msg.CreatedAt, &msg.Type,
 			&user.ID, &user.Username, &user.DisplayName, &user.AvatarURL, &user.Status, &user.CreatedAt,
 		)
 		if err != nil {
@@ -865,9 +2154,9 @@ func (s *Store) GetMessage(id string) (*models.Message, error) {
 	var threadID sql.NullString
 
 	err := s.db.QueryRow(`
-		SELECT id, channel_id, user_id, content, html_content, widget_size, thread_id, created_at
+		SELECT id, channel_id, user_id, content, html_content, widget_size, thread_id, created_at, COALESCE(type, '')
 		FROM messages WHERE id = ?
-	`, id).Scan(&msg.ID, &msg.ChannelID, &msg.UserID, &msg.Content, &htmlContent, &widgetSize, &threadID, &msg.CreatedAt)
+	`, id).Scan(&msg.ID, &msg.ChannelID, &msg.UserID, &msg.Content, &htmlContent, &widgetSize, &threadID, &msg.CreatedAt, &msg.Type)
 
 	if err != nil {
 		return nil, err
@@ -887,6 +2176,10 @@ func (s *Store) GetMessage(id string) (*models.Message, error) {
 }
 
 func (s *Store) DeleteMessage(id string) error {
+	// Unpin the message if it was pinned
+	s.db.Exec("DELETE FROM channel_pins WHERE message_id = ?", id)
+	s.db.Exec("DELETE FROM thread_notification_settings WHERE message_id = ?", id)
+
 	// First delete any replies to this message
 	_, err := s.db.Exec("DELETE FROM messages WHERE thread_id = ?", id)
 	if err != nil {
@@ -900,6 +2193,8 @@ func (s *Store) DeleteMessage(id string) error {
 
 // ClearChannelMessages deletes all messages in a channel
 func (s *Store) ClearChannelMessages(channelID string) error {
+	s.db.Exec("DELETE FROM channel_pins WHERE channel_id = ?", channelID)
+	s.db.Exec("DELETE FROM thread_notification_settings WHERE message_id IN (SELECT id FROM messages WHERE channel_id = ?)", channelID)
 	_, err := s.db.Exec("DELETE FROM messages WHERE channel_id = ?", channelID)
 	return err
 }
@@ -913,6 +2208,69 @@ func (s *Store) MarkChannelAsRead(channelID, userID string) error {
 	return err
 }
 
+// Pinned message operations
+
+func (s *Store) PinMessage(channelID, messageID, userID string) error {
+	_, err := s.db.Exec(`
+		INSERT OR IGNORE INTO channel_pins (channel_id, message_id, pinned_by, pinned_at)
+		VALUES (?, ?, ?, ?)
+	`, channelID, messageID, userID, time.Now())
+	return err
+}
+
+func (s *Store) UnpinMessage(channelID, messageID string) error {
+	_, err := s.db.Exec("DELETE FROM channel_pins WHERE channel_id = ? AND message_id = ?", channelID, messageID)
+	return err
+}
+
+func (s *Store) GetPinnedMessages(channelID string) ([]models.PinnedMessage, error) {
+	rows, err := s.db.Query(`
+		SELECT m.id, m.channel_id, m.user_id, m.content, m.html_content, m.widget_size, m.thread_id, m.created_at, COALESCE(m.type, ''),
+			   u.id, u.username, u.display_name, COALESCE(u.avatar_url, ''), u.status, u.created_at,
+			   p.pinned_by, p.pinned_at
+		FROM channel_pins p
+		JOIN messages m ON p.message_id = m.id
+		JOIN users u ON m.user_id = u.id
+		WHERE p.channel_id = ?
+		ORDER BY p.pinned_at DESC
+	`, channelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pins []models.PinnedMessage
+	for rows.Next() {
+		var pin models.PinnedMessage
+		var user models.User
+		var htmlContent sql.NullString
+		var widgetSize sql.NullString
+		var threadID sql.NullString
+
+		err := rows.Scan(
+			&pin.ID, &pin.ChannelID, &pin.UserID, &pin.Content, &htmlContent, &widgetSize, &threadID, &pin.CreatedAt, &pin.Type,
+			&user.ID, &user.Username, &user.DisplayName, &user.AvatarURL, &user.Status, &user.CreatedAt,
+			&pin.PinnedBy, &pin.PinnedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if htmlContent.Valid {
+			pin.HTMLContent = &htmlContent.String
+		}
+		if widgetSize.Valid {
+			pin.WidgetSize = &widgetSize.String
+		}
+		if threadID.Valid {
+			pin.ThreadID = &threadID.String
+		}
+		pin.User = user.ToResponse()
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
 // Reminder operations
 
 func (s *Store) CreateReminder(userID, channelID, message string, remindAt time.Time) (*models.Reminder, error) {
@@ -926,48 +2284,2266 @@ func (s *Store) CreateReminder(userID, channelID, message string, remindAt time.
 		Completed: false,
 	}
 
-	_, err := s.db.Exec(`
-		INSERT INTO reminders (id, user_id, channel_id, message, remind_at, created_at, completed)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, reminder.ID, reminder.UserID, reminder.ChannelID, reminder.Message, reminder.RemindAt, reminder.CreatedAt, reminder.Completed)
-
+	_, err := s.db.Exec(`
+		INSERT INTO reminders (id, user_id, channel_id, message, remind_at, created_at, completed)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, reminder.ID, reminder.UserID, reminder.ChannelID, reminder.Message, reminder.RemindAt, reminder.CreatedAt, reminder.Completed)
+
+	if err != nil {
+		return nil, err
+	}
+	return reminder, nil
+}
+
+func (s *Store) GetRemindersForUser(userID string) ([]models.Reminder, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, channel_id, message, remind_at, created_at, completed
+		FROM reminders
+		WHERE user_id = ? AND completed = FALSE
+		ORDER BY remind_at ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reminders []models.Reminder
+	for rows.Next() {
+		var r models.Reminder
+		err := rows.Scan(&r.ID, &r.UserID, &r.ChannelID, &r.Message, &r.RemindAt, &r.CreatedAt, &r.Completed)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, r)
+	}
+	return reminders, nil
+}
+
+func (s *Store) GetDueReminders() ([]models.Reminder, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, channel_id, message, remind_at, created_at, completed
+		FROM reminders
+		WHERE completed = FALSE AND remind_at <= ?
+		ORDER BY remind_at ASC
+	`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reminders []models.Reminder
+	for rows.Next() {
+		var r models.Reminder
+		err := rows.Scan(&r.ID, &r.UserID, &r.ChannelID, &r.Message, &r.RemindAt, &r.CreatedAt, &r.Completed)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, r)
+	}
+	return reminders, nil
+}
+
+func (s *Store) MarkReminderComplete(id string) error {
+	_, err := s.db.Exec("UPDATE reminders SET completed = TRUE WHERE id = ?", id)
+	return err
+}
+
+func (s *Store) DeleteReminder(id, userID string) error {
+	_, err := s.db.Exec("DELETE FROM reminders WHERE id = ? AND user_id = ?", id, userID)
+	return err
+}
+
+// Channel section operations
+
+func (s *Store) CreateChannelSection(userID, name string) (*models.ChannelSection, error) {
+	var maxPos int
+	s.db.QueryRow("SELECT COALESCE(MAX(position), -1) FROM channel_sections WHERE user_id = ?", userID).Scan(&maxPos)
+
+	section := &models.ChannelSection{
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		Name:       name,
+		Position:   maxPos + 1,
+		ChannelIDs: []string{},
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO channel_sections (id, user_id, name, position, collapsed, created_at, updated_at)
+		VALUES (?, ?, ?, ?, FALSE, ?, ?)
+	`, section.ID, section.UserID, section.Name, section.Position, section.CreatedAt, section.UpdatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+	return section, nil
+}
+
+func (s *Store) GetChannelSection(id string) (*models.ChannelSection, error) {
+	section := &models.ChannelSection{}
+	err := s.db.QueryRow(`
+		SELECT id, user_id, name, position, collapsed, created_at, updated_at
+		FROM channel_sections WHERE id = ?
+	`, id).Scan(&section.ID, &section.UserID, &section.Name, &section.Position, &section.Collapsed, &section.CreatedAt, &section.UpdatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	section.ChannelIDs, err = s.getSectionChannelIDs(id)
+	if err != nil {
+		return nil, err
+	}
+	return section, nil
+}
+
+// GetChannelSections returns a user's sections in sidebar order, each with its channels in order
+func (s *Store) GetChannelSections(userID string) ([]models.ChannelSection, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, name, position, collapsed, created_at, updated_at
+		FROM channel_sections WHERE user_id = ?
+		ORDER BY position
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var sections []models.ChannelSection
+	for rows.Next() {
+		var sec models.ChannelSection
+		err := rows.Scan(&sec.ID, &sec.UserID, &sec.Name, &sec.Position, &sec.Collapsed, &sec.CreatedAt, &sec.UpdatedAt)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sections = append(sections, sec)
+	}
+	rows.Close()
+
+	for i := range sections {
+		sections[i].ChannelIDs, err = s.getSectionChannelIDs(sections[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sections, nil
+}
+
+func (s *Store) getSectionChannelIDs(sectionID string) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT channel_id FROM channel_section_items
+		WHERE section_id = ?
+		ORDER BY position
+	`, sectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channelIDs := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		channelIDs = append(channelIDs, id)
+	}
+	return channelIDs, nil
+}
+
+func (s *Store) UpdateChannelSection(id, name string, collapsed bool) error {
+	_, err := s.db.Exec(`
+		UPDATE channel_sections SET name = ?, collapsed = ?, updated_at = ? WHERE id = ?
+	`, name, collapsed, time.Now(), id)
+	return err
+}
+
+// DeleteChannelSection removes a section; its channels go back to the default list
+func (s *Store) DeleteChannelSection(id string) error {
+	_, err := s.db.Exec("DELETE FROM channel_section_items WHERE section_id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("DELETE FROM channel_sections WHERE id = ?", id)
+	return err
+}
+
+func (s *Store) ReorderChannelSections(userID string, sectionIDs []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, sectionID := range sectionIDs {
+		_, err := tx.Exec("UPDATE channel_sections SET position = ?, updated_at = ? WHERE id = ? AND user_id = ?", i, time.Now(), sectionID, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetSectionChannels replaces the channels in a section with channelIDs, in that order.
+// Channels listed here are moved out of any other section the user has them in.
+func (s *Store) SetSectionChannels(userID, sectionID string, channelIDs []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM channel_section_items WHERE section_id = ?", sectionID)
+	if err != nil {
+		return err
+	}
+
+	for i, channelID := range channelIDs {
+		_, err := tx.Exec(`
+			INSERT OR REPLACE INTO channel_section_items (user_id, channel_id, section_id, position)
+			VALUES (?, ?, ?, ?)
+		`, userID, channelID, sectionID, i)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE channel_sections SET updated_at = ? WHERE id = ?", time.Now(), sectionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Channel invite operations
+
+func (s *Store) CreateChannelInvite(channelID, createdBy, role string, maxUses *int, expiresAt *time.Time) (*models.ChannelInvite, error) {
+	invite := &models.ChannelInvite{
+		ID:        uuid.New().String(),
+		ChannelID: channelID,
+		Token:     strings.ReplaceAll(uuid.New().String(), "-", ""),
+		CreatedBy: createdBy,
+		Role:      role,
+		MaxUses:   maxUses,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO channel_invites (id, channel_id, token, created_by, role, max_uses, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, invite.ID, invite.ChannelID, invite.Token, invite.CreatedBy, invite.Role, invite.MaxUses, invite.ExpiresAt, invite.CreatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+const channelInviteColumns = `id, channel_id, token, created_by, role, max_uses, use_count, expires_at, revoked_at, created_at`
+
+func scanChannelInvite(row interface{ Scan(...interface{}) error }) (*models.ChannelInvite, error) {
+	invite := &models.ChannelInvite{}
+	var maxUses sql.NullInt64
+	var expiresAt, revokedAt sql.NullTime
+	err := row.Scan(&invite.ID, &invite.ChannelID, &invite.Token, &invite.CreatedBy, &invite.Role,
+		&maxUses, &invite.UseCount, &expiresAt, &revokedAt, &invite.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if maxUses.Valid {
+		n := int(maxUses.Int64)
+		invite.MaxUses = &n
+	}
+	if expiresAt.Valid {
+		invite.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		invite.RevokedAt = &revokedAt.Time
+	}
+	return invite, nil
+}
+
+func (s *Store) GetChannelInvite(id string) (*models.ChannelInvite, error) {
+	return scanChannelInvite(s.db.QueryRow(`SELECT `+channelInviteColumns+` FROM channel_invites WHERE id = ?`, id))
+}
+
+func (s *Store) GetChannelInviteByToken(token string) (*models.ChannelInvite, error) {
+	return scanChannelInvite(s.db.QueryRow(`SELECT `+channelInviteColumns+` FROM channel_invites WHERE token = ?`, token))
+}
+
+// GetChannelInvites returns a channel's invites, newest first, with who used each one
+func (s *Store) GetChannelInvites(channelID string) ([]models.ChannelInviteWithUses, error) {
+	rows, err := s.db.Query(`SELECT `+channelInviteColumns+` FROM channel_invites WHERE channel_id = ? ORDER BY created_at DESC`, channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	var invites []models.ChannelInviteWithUses
+	for rows.Next() {
+		invite, err := scanChannelInvite(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		invites = append(invites, models.ChannelInviteWithUses{ChannelInvite: *invite})
+	}
+	rows.Close()
+
+	for i := range invites {
+		invites[i].Uses, err = s.getChannelInviteUses(invites[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return invites, nil
+}
+
+func (s *Store) getChannelInviteUses(inviteID string) ([]models.ChannelInviteUse, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, u.display_name, COALESCE(u.avatar_url, ''), u.status, u.created_at, iu.used_at
+		FROM channel_invite_uses iu
+		JOIN users u ON iu.user_id = u.id
+		WHERE iu.invite_id = ?
+		ORDER BY iu.used_at
+	`, inviteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uses := []models.ChannelInviteUse{}
+	for rows.Next() {
+		var use models.ChannelInviteUse
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.DisplayName, &user.AvatarURL, &user.Status, &user.CreatedAt, &use.UsedAt); err != nil {
+			return nil, err
+		}
+		use.User = user.ToResponse()
+		uses = append(uses, use)
+	}
+	return uses, nil
+}
+
+func (s *Store) RevokeChannelInvite(id string) error {
+	_, err := s.db.Exec("UPDATE channel_invites SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now(), id)
+	return err
+}
+
+// RedeemChannelInvite adds the user to the invite's channel with the invite's role and
+// records the use. joined is false if the user was already a member, in which case
+// the invite isn't consumed.
+func (s *Store) RedeemChannelInvite(token, userID string) (invite *models.ChannelInvite, joined bool, err error) {
+	invite, err = s.GetChannelInviteByToken(token)
+	if err != nil {
+		return nil, false, err
+	}
+	if !invite.IsUsable() {
+		return invite, false, ErrInviteUnusable
+	}
+
+	if isMember, _ := s.IsChannelMember(invite.ChannelID, userID); isMember {
+		return invite, false, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	// Claim a use atomically so concurrent redemptions can't exceed max_uses
+	result, err := tx.Exec(`
+		UPDATE channel_invites SET use_count = use_count + 1
+		WHERE id = ? AND revoked_at IS NULL AND (max_uses IS NULL OR use_count < max_uses)
+	`, invite.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return invite, false, ErrInviteUnusable
+	}
+
+	now := time.Now()
+	if _, err := tx.Exec(`
+		INSERT OR IGNORE INTO channel_invite_uses (invite_id, user_id, used_at) VALUES (?, ?, ?)
+	`, invite.ID, userID, now); err != nil {
+		return nil, false, err
+	}
+	if _, err := tx.Exec(`
+		INSERT OR IGNORE INTO channel_members (channel_id, user_id, role, joined_at, last_read_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, invite.ChannelID, userID, invite.Role, now, now); err != nil {
+		return nil, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	invite.UseCount++
+	return invite, true, nil
+}
+
+// ClaimChannelInvite takes one use of a channel invite before an account is created
+// through it, atomically so concurrent registrations can't exceed max_uses
+func (s *Store) ClaimChannelInvite(token string) (*models.ChannelInvite, error) {
+	invite, err := s.GetChannelInviteByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if !invite.IsUsable() {
+		return invite, ErrInviteUnusable
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE channel_invites SET use_count = use_count + 1
+		WHERE id = ? AND revoked_at IS NULL AND (max_uses IS NULL OR use_count < max_uses)
+	`, invite.ID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return invite, ErrInviteUnusable
+	}
+	invite.UseCount++
+	return invite, nil
+}
+
+// ReleaseChannelInvite gives back a claimed use when the account couldn't be created
+func (s *Store) ReleaseChannelInvite(id string) error {
+	_, err := s.db.Exec("UPDATE channel_invites SET use_count = use_count - 1 WHERE id = ? AND use_count > 0", id)
+	return err
+}
+
+// JoinClaimedChannelInvite adds a user to the channel of an invite claimed for them
+// and records the use
+func (s *Store) JoinClaimedChannelInvite(invite *models.ChannelInvite, userID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec(`
+		INSERT OR IGNORE INTO channel_invite_uses (invite_id, user_id, used_at) VALUES (?, ?, ?)
+	`, invite.ID, userID, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT OR IGNORE INTO channel_members (channel_id, user_id, role, joined_at, last_read_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, invite.ChannelID, userID, invite.Role, now, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Session operations
+
+// SessionTTL is how long a session stays signed in without its refresh token being used
+const SessionTTL = 30 * 24 * time.Hour
+
+// signingKeyRetention is how long a retired signing key is kept so tokens it signed
+// before a rotation keep verifying until they expire
+const signingKeyRetention = 24 * time.Hour
+
+// newSecret returns n random bytes, hex encoded
+func newSecret(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession starts a session for a user and returns it with its first refresh token
+func (s *Store) CreateSession(userID, deviceName, userAgent, ipAddress string) (*models.Session, string, error) {
+	refreshToken, err := newSecret(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		DeviceName: deviceName,
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(SessionTTL),
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO sessions (id, user_id, refresh_token_hash, device_name, user_agent, ip_address, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, session.ID, session.UserID, hashToken(refreshToken), session.DeviceName, session.UserAgent, session.IPAddress,
+		session.CreatedAt, session.LastUsedAt, session.ExpiresAt)
+
+	if err != nil {
+		return nil, "", err
+	}
+	return session, refreshToken, nil
+}
+
+const sessionColumns = `id, user_id, COALESCE(device_name, ''), COALESCE(user_agent, ''), COALESCE(ip_address, ''), created_at, last_used_at, expires_at, revoked_at`
+
+func scanSession(row interface{ Scan(...interface{}) error }) (*models.Session, error) {
+	session := &models.Session{}
+	var revokedAt sql.NullTime
+	err := row.Scan(&session.ID, &session.UserID, &session.DeviceName, &session.UserAgent, &session.IPAddress,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+	return session, nil
+}
+
+func (s *Store) GetSession(id string) (*models.Session, error) {
+	return scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+}
+
+// IsSessionActive reports whether a session exists and hasn't been revoked or expired
+func (s *Store) IsSessionActive(id string) bool {
+	session, err := s.GetSession(id)
+	return err == nil && session.IsActive()
+}
+
+// GetActiveSessions returns a user's signed-in sessions, most recently used first
+func (s *Store) GetActiveSessions(userID string) ([]models.Session, error) {
+	rows, err := s.db.Query(`
+		SELECT `+sessionColumns+` FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_used_at DESC
+	`, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, nil
+}
+
+// RefreshSession exchanges a refresh token for a new one, extending the session. If the
+// token was already rotated away, the session is revoked and ErrRefreshTokenReused is
+// returned along with the session so its connections can be closed.
+func (s *Store) RefreshSession(refreshToken string) (*models.Session, string, error) {
+	hash := hashToken(refreshToken)
+
+	session, err := scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE refresh_token_hash = ?`, hash))
+	if err == sql.ErrNoRows {
+		reused, err := scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE previous_token_hash = ?`, hash))
+		if err != nil {
+			return nil, "", ErrSessionInvalid
+		}
+		if err := s.RevokeSession(reused.ID); err != nil {
+			return nil, "", err
+		}
+		return reused, "", ErrRefreshTokenReused
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if !session.IsActive() {
+		return nil, "", ErrSessionInvalid
+	}
+
+	newToken, err := newSecret(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Only rotate if the token is still current, so two concurrent refreshes can't both succeed
+	now := time.Now()
+	result, err := s.db.Exec(`
+		UPDATE sessions SET refresh_token_hash = ?, previous_token_hash = ?, last_used_at = ?, expires_at = ?
+		WHERE id = ? AND refresh_token_hash = ?
+	`, hashToken(newToken), hash, now, now.Add(SessionTTL), session.ID, hash)
+	if err != nil {
+		return nil, "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, "", ErrSessionInvalid
+	}
+
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(SessionTTL)
+	return session, newToken, nil
+}
+
+func (s *Store) RevokeSession(id string) error {
+	_, err := s.db.Exec("UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now(), id)
+	return err
+}
+
+// RevokeUserSessions signs a user out everywhere except exceptID (which may be empty),
+// returning the IDs of the sessions that were revoked
+func (s *Store) RevokeUserSessions(userID, exceptID string) ([]string, error) {
+	sessions, err := s.GetActiveSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked []string
+	for _, session := range sessions {
+		if session.ID == exceptID {
+			continue
+		}
+		if err := s.RevokeSession(session.ID); err != nil {
+			return revoked, err
+		}
+		revoked = append(revoked, session.ID)
+	}
+	return revoked, nil
+}
+
+// Login throttling operations
+
+// GetLoginThrottle returns the failure count for a key, or nil if it has none
+func (s *Store) GetLoginThrottle(key string) (*models.LoginThrottle, error) {
+	t := &models.LoginThrottle{Key: key}
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRow("SELECT failures, last_failed_at, locked_until FROM login_throttles WHERE key = ?", key).
+		Scan(&t.Failures, &t.LastFailedAt, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		t.LockedUntil = &lockedUntil.Time
+	}
+	return t, nil
+}
+
+// RecordLoginFailure counts a failed login against a key. Failures are forgotten once
+// they're older than the failure window or a lockout has ended. locked is true if
+// this failure started a lockout.
+func (s *Store) RecordLoginFailure(key string, policy models.LoginThrottlePolicy) (t *models.LoginThrottle, locked bool, err error) {
+	now := time.Now()
+	stale := now.Add(-models.LoginFailureWindow)
+
+	// Done in SQL so concurrent failures are all counted
+	_, err = s.db.Exec(`
+		INSERT INTO login_throttles (key, failures, last_failed_at) VALUES (?, 1, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = CASE WHEN last_failed_at < ? OR locked_until <= ? THEN 1 ELSE failures + 1 END,
+			locked_until = CASE WHEN last_failed_at < ? OR locked_until <= ? THEN NULL ELSE locked_until END,
+			last_failed_at = excluded.last_failed_at
+	`, key, now, stale, now, stale, now)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if policy.LockoutAfter > 0 {
+		result, err := s.db.Exec(`
+			UPDATE login_throttles SET locked_until = ?
+			WHERE key = ? AND locked_until IS NULL AND failures >= ?
+		`, now.Add(policy.LockoutDuration), key, policy.LockoutAfter)
+		if err != nil {
+			return nil, false, err
+		}
+		n, _ := result.RowsAffected()
+		locked = n > 0
+	}
+
+	t, err = s.GetLoginThrottle(key)
+	return t, locked, err
+}
+
+func (s *Store) ClearLoginFailures(key string) error {
+	_, err := s.db.Exec("DELETE FROM login_throttles WHERE key = ?", key)
+	return err
+}
+
+// Security event operations
+
+func (s *Store) LogSecurityEvent(event models.SecurityEvent) error {
+	event.ID = uuid.New().String()
+	event.CreatedAt = time.Now()
+
+	_, err := s.db.Exec(`
+		INSERT INTO security_events (id, type, user_id, actor_id, ip_address, details, created_at)
+		VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?)
+	`, event.ID, event.Type, event.UserID, event.ActorID, event.IPAddress, event.Details, event.CreatedAt)
+	return err
+}
+
+// GetSecurityEvents returns security events, newest first. userID and eventType
+// filter the results when set, and before pages back through older events.
+func (s *Store) GetSecurityEvents(userID, eventType string, before *time.Time, limit int) ([]models.SecurityEvent, error) {
+	where := []string{"1 = 1"}
+	var args []interface{}
+	if userID != "" {
+		where = append(where, "user_id = ?")
+		args = append(args, userID)
+	}
+	if eventType != "" {
+		where = append(where, "type = ?")
+		args = append(args, eventType)
+	}
+	if before != nil {
+		where = append(where, "created_at < ?")
+		args = append(args, *before)
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.Query(`
+		SELECT id, type, COALESCE(user_id, ''), COALESCE(actor_id, ''), COALESCE(ip_address, ''), COALESCE(details, ''), created_at
+		FROM security_events
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY created_at DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.SecurityEvent{}
+	for rows.Next() {
+		var e models.SecurityEvent
+		if err := rows.Scan(&e.ID, &e.Type, &e.UserID, &e.ActorID, &e.IPAddress, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// Registration invite operations
+
+func (s *Store) CreateRegistrationInvite(createdBy, note, role string, maxUses *int, expiresAt *time.Time) (*models.RegistrationInvite, error) {
+	raw, err := newSecret(5)
+	if err != nil {
+		return nil, err
+	}
+
+	invite := &models.RegistrationInvite{
+		ID:        uuid.New().String(),
+		Code:      raw[:5] + "-" + raw[5:],
+		Note:      note,
+		CreatedBy: createdBy,
+		Role:      role,
+		MaxUses:   maxUses,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO registration_invites (id, code, note, created_by, role, max_uses, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, invite.ID, invite.Code, invite.Note, invite.CreatedBy, invite.Role, invite.MaxUses, invite.ExpiresAt, invite.CreatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+const registrationInviteColumns = `id, code, COALESCE(note, ''), created_by, role, max_uses, use_count, expires_at, revoked_at, created_at`
+
+func scanRegistrationInvite(row interface{ Scan(...interface{}) error }) (*models.RegistrationInvite, error) {
+	invite := &models.RegistrationInvite{}
+	var maxUses sql.NullInt64
+	var expiresAt, revokedAt sql.NullTime
+	err := row.Scan(&invite.ID, &invite.Code, &invite.Note, &invite.CreatedBy, &invite.Role,
+		&maxUses, &invite.UseCount, &expiresAt, &revokedAt, &invite.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if maxUses.Valid {
+		n := int(maxUses.Int64)
+		invite.MaxUses = &n
+	}
+	if expiresAt.Valid {
+		invite.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		invite.RevokedAt = &revokedAt.Time
+	}
+	return invite, nil
+}
+
+func (s *Store) GetRegistrationInvite(id string) (*models.RegistrationInvite, error) {
+	return scanRegistrationInvite(s.db.QueryRow(`SELECT `+registrationInviteColumns+` FROM registration_invites WHERE id = ?`, id))
+}
+
+// GetRegistrationInviteByCode looks up an invite code, ignoring case and surrounding spaces
+func (s *Store) GetRegistrationInviteByCode(code string) (*models.RegistrationInvite, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return scanRegistrationInvite(s.db.QueryRow(`SELECT `+registrationInviteColumns+` FROM registration_invites WHERE code = ?`, code))
+}
+
+// GetRegistrationInvites returns every invite code, newest first, with who used each one
+func (s *Store) GetRegistrationInvites() ([]models.RegistrationInviteWithUses, error) {
+	rows, err := s.db.Query(`SELECT ` + registrationInviteColumns + ` FROM registration_invites ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+
+	var invites []models.RegistrationInviteWithUses
+	for rows.Next() {
+		invite, err := scanRegistrationInvite(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		invites = append(invites, models.RegistrationInviteWithUses{RegistrationInvite: *invite})
+	}
+	rows.Close()
+
+	for i := range invites {
+		invites[i].Uses, err = s.getRegistrationInviteUses(invites[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return invites, nil
+}
+
+func (s *Store) getRegistrationInviteUses(inviteID string) ([]models.RegistrationInviteUse, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, u.display_name, COALESCE(u.avatar_url, ''), u.status, u.created_at, iu.used_at
+		FROM registration_invite_uses iu
+		JOIN users u ON iu.user_id = u.id
+		WHERE iu.invite_id = ?
+		ORDER BY iu.used_at
+	`, inviteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uses := []models.RegistrationInviteUse{}
+	for rows.Next() {
+		var use models.RegistrationInviteUse
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.DisplayName, &user.AvatarURL, &user.Status, &user.CreatedAt, &use.UsedAt); err != nil {
+			return nil, err
+		}
+		use.User = user.ToResponse()
+		uses = append(uses, use)
+	}
+	return uses, nil
+}
+
+func (s *Store) RevokeRegistrationInvite(id string) error {
+	_, err := s.db.Exec("UPDATE registration_invites SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now(), id)
+	return err
+}
+
+// ClaimRegistrationInvite takes one use of an invite code before an account is created,
+// atomically so concurrent registrations can't exceed max_uses
+func (s *Store) ClaimRegistrationInvite(code string) (*models.RegistrationInvite, error) {
+	invite, err := s.GetRegistrationInviteByCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if !invite.IsUsable() {
+		return invite, ErrInviteUnusable
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE registration_invites SET use_count = use_count + 1
+		WHERE id = ? AND revoked_at IS NULL AND (max_uses IS NULL OR use_count < max_uses)
+	`, invite.ID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return invite, ErrInviteUnusable
+	}
+	invite.UseCount++
+	return invite, nil
+}
+
+// ReleaseRegistrationInvite gives back a claimed use when the account couldn't be created
+func (s *Store) ReleaseRegistrationInvite(id string) error {
+	_, err := s.db.Exec("UPDATE registration_invites SET use_count = use_count - 1 WHERE id = ? AND use_count > 0", id)
+	return err
+}
+
+func (s *Store) RecordRegistrationInviteUse(inviteID, userID string) error {
+	_, err := s.db.Exec(`
+		INSERT OR IGNORE INTO registration_invite_uses (invite_id, user_id, used_at) VALUES (?, ?, ?)
+	`, inviteID, userID, time.Now())
+	return err
+}
+
+// Personal access token operations
+
+// accessTokenTouchInterval limits how often a token's last-used time is written
+const accessTokenTouchInterval = time.Minute
+
+// CreateAccessToken issues a personal access token and returns it with the token itself,
+// which isn't stored and can't be retrieved again
+func (s *Store) CreateAccessToken(userID, name string, scopes []string, expiresAt *time.Time) (*models.PersonalAccessToken, string, error) {
+	secret, err := newSecret(20)
+	if err != nil {
+		return nil, "", err
+	}
+	token := models.AccessTokenPrefix + secret
+
+	pat := &models.PersonalAccessToken{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Name:        name,
+		TokenPrefix: token[:len(models.AccessTokenPrefix)+8],
+		Scopes:      scopes,
+		ExpiresAt:   expiresAt,
+		CreatedAt:   time.Now(),
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO personal_access_tokens (id, user_id, name, token_hash, token_prefix, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, pat.ID, pat.UserID, pat.Name, hashToken(token), pat.TokenPrefix, strings.Join(scopes, ","), pat.ExpiresAt, pat.CreatedAt)
+
+	if err != nil {
+		return nil, "", err
+	}
+	return pat, token, nil
+}
+
+const accessTokenColumns = `id, user_id, name, token_prefix, scopes, expires_at, last_used_at, created_at`
+
+func scanAccessToken(row interface{ Scan(...interface{}) error }) (*models.PersonalAccessToken, error) {
+	pat := &models.PersonalAccessToken{}
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&pat.ID, &pat.UserID, &pat.Name, &pat.TokenPrefix, &scopes, &expiresAt, &lastUsedAt, &pat.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	pat.Scopes = []string{}
+	if scopes != "" {
+		pat.Scopes = strings.Split(scopes, ",")
+	}
+	if expiresAt.Valid {
+		pat.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		pat.LastUsedAt = &lastUsedAt.Time
+	}
+	return pat, nil
+}
+
+func (s *Store) GetAccessTokens(userID string) ([]models.PersonalAccessToken, error) {
+	rows, err := s.db.Query(`SELECT `+accessTokenColumns+` FROM personal_access_tokens WHERE user_id = ? ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.PersonalAccessToken
+	for rows.Next() {
+		pat, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *pat)
+	}
+	return tokens, nil
+}
+
+// AuthenticateAccessToken looks up an unexpired token belonging to an active user
+// and records that it was used
+func (s *Store) AuthenticateAccessToken(token string) (*models.PersonalAccessToken, error) {
+	now := time.Now()
+	pat, err := scanAccessToken(s.db.QueryRow(`
+		SELECT `+accessTokenColumns+` FROM personal_access_tokens
+		WHERE token_hash = ? AND (expires_at IS NULL OR expires_at > ?)
+		AND user_id IN (SELECT id FROM users WHERE deactivated_at IS NULL)
+	`, hashToken(token), now))
+	if err != nil {
+		return nil, err
+	}
+
+	s.db.Exec(`
+		UPDATE personal_access_tokens SET last_used_at = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)
+	`, now, pat.ID, now.Add(-accessTokenTouchInterval))
+	return pat, nil
+}
+
+// DeleteAccessToken revokes one of a user's tokens
+func (s *Store) DeleteAccessToken(id, userID string) error {
+	result, err := s.db.Exec("DELETE FROM personal_access_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Email verification operations
+
+const (
+	// EmailVerificationTTL is how long an email verification code can be used
+	EmailVerificationTTL = 15 * time.Minute
+	// emailVerificationResendDelay is how long to wait before sending another code
+	emailVerificationResendDelay = time.Minute
+	// maxEmailVerificationAttempts is how many wrong codes are allowed before a new one is needed
+	maxEmailVerificationAttempts = 5
+)
+
+// CreateEmailVerification issues a six-digit code proving ownership of an email
+// address, replacing any earlier one
+func (s *Store) CreateEmailVerification(email string) (string, error) {
+	email = strings.ToLower(email)
+	s.db.Exec("DELETE FROM email_verifications WHERE expires_at <= ?", time.Now())
+
+	var createdAt time.Time
+	err := s.db.QueryRow("SELECT created_at FROM email_verifications WHERE email = ?", email).Scan(&createdAt)
+	if err == nil && time.Since(createdAt) < emailVerificationResendDelay {
+		return "", ErrEmailCodeTooSoon
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	now := time.Now()
+	_, err = s.db.Exec(`
+		INSERT INTO email_verifications (email, code_hash, attempts, expires_at, created_at) VALUES (?, ?, 0, ?, ?)
+		ON CONFLICT(email) DO UPDATE SET
+			code_hash = excluded.code_hash, attempts = 0, expires_at = excluded.expires_at, created_at = excluded.created_at
+	`, email, hashToken(code), now.Add(EmailVerificationTTL), now)
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// CheckEmailVerification checks a code sent to an email address. Wrong codes count
+// towards the attempt limit; a matching code stays valid until it's deleted.
+func (s *Store) CheckEmailVerification(email, code string) error {
+	email = strings.ToLower(email)
+
+	// Count the attempt up front so concurrent guesses can't get past the limit
+	result, err := s.db.Exec(`
+		UPDATE email_verifications SET attempts = attempts + 1
+		WHERE email = ? AND expires_at > ? AND attempts < ?
+	`, email, time.Now(), maxEmailVerificationAttempts)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrEmailCodeInvalid
+	}
+
+	var codeHash string
+	if err := s.db.QueryRow("SELECT code_hash FROM email_verifications WHERE email = ?", email).Scan(&codeHash); err != nil {
+		return ErrEmailCodeInvalid
+	}
+	if code == "" || codeHash != hashToken(strings.TrimSpace(code)) {
+		return ErrEmailCodeInvalid
+	}
+
+	// The right code doesn't count against the limit
+	_, err = s.db.Exec("UPDATE email_verifications SET attempts = attempts - 1 WHERE email = ?", email)
+	return err
+}
+
+// DeleteEmailVerification removes an email's code once it's been used
+func (s *Store) DeleteEmailVerification(email string) error {
+	_, err := s.db.Exec("DELETE FROM email_verifications WHERE email = ?", strings.ToLower(email))
+	return err
+}
+
+// Password reset operations
+
+// CreatePasswordReset issues a one-time reset token for a user, replacing any
+// outstanding ones. createdBy is empty when issued from the command line.
+func (s *Store) CreatePasswordReset(userID, createdBy string, ttl time.Duration) (string, time.Time, error) {
+	token, err := newSecret(24)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM password_reset_tokens WHERE user_id = ? AND used_at IS NULL", userID); err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	_, err = tx.Exec(`
+		INSERT INTO password_reset_tokens (token_hash, user_id, created_by, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, hashToken(token), userID, sql.NullString{String: createdBy, Valid: createdBy != ""}, expiresAt, now)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// GetPasswordResetUserID returns the user a still-valid reset token belongs to
+func (s *Store) GetPasswordResetUserID(token string) (string, error) {
+	var userID string
+	err := s.db.QueryRow(`
+		SELECT user_id FROM password_reset_tokens
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+	`, hashToken(token), time.Now()).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrResetTokenInvalid
+	}
+	return userID, err
+}
+
+// ResetPassword sets a new password using a reset token, marking the token used
+func (s *Store) ResetPassword(token, password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var userID string
+	now := time.Now()
+	err = tx.QueryRow(`
+		SELECT user_id FROM password_reset_tokens
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+	`, hashToken(token), now).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrResetTokenInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Claim the token so it can't be used twice
+	result, err := tx.Exec("UPDATE password_reset_tokens SET used_at = ? WHERE token_hash = ? AND used_at IS NULL", now, hashToken(token))
+	if err != nil {
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", ErrResetTokenInvalid
+	}
+
+	if _, err := tx.Exec("UPDATE users SET password_hash = ? WHERE id = ?", string(hash), userID); err != nil {
+		return "", err
+	}
+
+	return userID, tx.Commit()
+}
+
+// Two-factor operations
+
+// loginChallengeTTL is how long a user has to enter their code after their password
+const loginChallengeTTL = 5 * time.Minute
+
+// recoveryCodeCount is how many recovery codes are issued at a time
+const recoveryCodeCount = 10
+
+// StartTOTPEnrollment creates a new pending TOTP secret for a user, replacing any
+// earlier unconfirmed one
+func (s *Store) StartTOTPEnrollment(userID string) (string, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO user_totp (user_id, secret, created_at) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, last_used_step = 0, created_at = excluded.created_at
+		WHERE user_totp.enabled_at IS NULL
+	`, userID, secret, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", errors.New("two-factor authentication is already enabled")
+	}
+	return secret, nil
+}
+
+func (s *Store) IsTwoFactorEnabled(userID string) bool {
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM user_totp WHERE user_id = ? AND enabled_at IS NOT NULL", userID).Scan(&count)
+	return count > 0
+}
+
+func (s *Store) GetTwoFactorStatus(userID string) (*models.TwoFactorStatus, error) {
+	status := &models.TwoFactorStatus{}
+
+	var enabledAt sql.NullTime
+	err := s.db.QueryRow("SELECT enabled_at FROM user_totp WHERE user_id = ?", userID).Scan(&enabledAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if enabledAt.Valid {
+		status.Enabled = true
+		status.EnabledAt = &enabledAt.Time
+	}
+
+	err = s.db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&status.RecoveryCodesRemaining)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// ValidateTOTP checks a code against the user's secret, pending or enabled. Each time
+// step can only be used once, so a code can't be replayed.
+func (s *Store) ValidateTOTP(userID, code string) bool {
+	var secret string
+	if err := s.db.QueryRow("SELECT secret FROM user_totp WHERE user_id = ?", userID).Scan(&secret); err != nil {
+		return false
+	}
+
+	step, ok := totp.Validate(secret, normalizeCode(code), time.Now())
+	if !ok {
+		return false
+	}
+
+	result, err := s.db.Exec("UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?", step, userID, step)
+	if err != nil {
+		return false
+	}
+	n, _ := result.RowsAffected()
+	return n == 1
+}
+
+// ConfirmTOTPEnrollment switches on 2FA once the user proves their authenticator works,
+// returning a fresh set of recovery codes
+func (s *Store) ConfirmTOTPEnrollment(userID, code string) ([]string, error) {
+	if !s.ValidateTOTP(userID, code) {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	if _, err := s.db.Exec("UPDATE user_totp SET enabled_at = ? WHERE user_id = ?", time.Now(), userID); err != nil {
+		return nil, err
+	}
+	return s.RegenerateRecoveryCodes(userID)
+}
+
+func (s *Store) DisableTwoFactor(userID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_totp WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes. The plain codes are only
+// ever returned here.
+func (s *Store) RegenerateRecoveryCodes(userID string) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw, err := newSecret(5)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = raw[:5] + "-" + raw[5:]
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(raw), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = string(hash)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, hash := range hashes {
+		if _, err := tx.Exec(`
+			INSERT INTO recovery_codes (id, user_id, code_hash, created_at) VALUES (?, ?, ?, ?)
+		`, uuid.New().String(), userID, hash, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode consumes a matching unused recovery code
+func (s *Store) UseRecoveryCode(userID, code string) bool {
+	code = normalizeCode(code)
+
+	rows, err := s.db.Query("SELECT id, code_hash FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID)
+	if err != nil {
+		return false
+	}
+	var matchID string
+	for rows.Next() {
+		var id, hash string
+		if rows.Scan(&id, &hash) == nil && bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) == nil {
+			matchID = id
+			break
+		}
+	}
+	rows.Close()
+	if matchID == "" {
+		return false
+	}
+
+	result, err := s.db.Exec("UPDATE recovery_codes SET used_at = ? WHERE id = ? AND used_at IS NULL", time.Now(), matchID)
+	if err != nil {
+		return false
+	}
+	n, _ := result.RowsAffected()
+	return n == 1
+}
+
+// VerifyTwoFactorCode accepts either a current TOTP code or an unused recovery code
+func (s *Store) VerifyTwoFactorCode(userID, code string) bool {
+	if len(normalizeCode(code)) == totp.Digits {
+		return s.ValidateTOTP(userID, code)
+	}
+	return s.UseRecoveryCode(userID, code)
+}
+
+// normalizeCode strips the spaces and dashes people type or paste into codes
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// CreateLoginChallenge records a password-verified login that still needs a second factor
+func (s *Store) CreateLoginChallenge(userID, deviceName string) (string, time.Time, error) {
+	token, err := newSecret(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(loginChallengeTTL)
+	_, err = s.db.Exec(`
+		INSERT INTO login_challenges (token_hash, user_id, device_name, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, hashToken(token), userID, deviceName, expiresAt, now)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// Clear out challenges nobody finished
+	s.db.Exec("DELETE FROM login_challenges WHERE expires_at < ?", now)
+
+	return token, expiresAt, nil
+}
+
+// GetLoginChallenge returns an unexpired login challenge
+func (s *Store) GetLoginChallenge(token string) (*models.PendingLogin, error) {
+	login := &models.PendingLogin{}
+	err := s.db.QueryRow(`
+		SELECT user_id, COALESCE(device_name, ''), attempts, expires_at FROM login_challenges
+		WHERE token_hash = ? AND expires_at > ? AND attempts < ?
+	`, hashToken(token), time.Now(), models.MaxLoginChallengeAttempts).Scan(&login.UserID, &login.DeviceName, &login.Attempts, &login.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return login, nil
+}
+
+// RecordLoginChallengeFailure counts a wrong code against a challenge; once the limit
+// is reached the challenge stops working
+func (s *Store) RecordLoginChallengeFailure(token string) error {
+	_, err := s.db.Exec("UPDATE login_challenges SET attempts = attempts + 1 WHERE token_hash = ?", hashToken(token))
+	return err
+}
+
+func (s *Store) DeleteLoginChallenge(token string) error {
+	_, err := s.db.Exec("DELETE FROM login_challenges WHERE token_hash = ?", hashToken(token))
+	return err
+}
+
+// Single sign-on operations
+
+func (s *Store) SaveOIDCLoginState(state *models.OIDCLoginState) error {
+	_, err := s.db.Exec(`
+		INSERT INTO oidc_login_states (state, nonce, code_verifier, device_name, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, state.State, state.Nonce, state.CodeVerifier, state.DeviceName, state.ExpiresAt)
+
+	// Clear out logins nobody finished
+	s.db.Exec("DELETE FROM oidc_login_states WHERE expires_at < ?", time.Now())
+	return err
+}
+
+// ConsumeOIDCLoginState returns and deletes an unexpired login state, so each
+// authorization response can only be used once
+func (s *Store) ConsumeOIDCLoginState(state string) (*models.OIDCLoginState, error) {
+	login := &models.OIDCLoginState{State: state}
+	err := s.db.QueryRow(`
+		SELECT nonce, code_verifier, COALESCE(device_name, ''), expires_at FROM oidc_login_states
+		WHERE state = ? AND expires_at > ?
+	`, state, time.Now()).Scan(&login.Nonce, &login.CodeVerifier, &login.DeviceName, &login.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.Exec("DELETE FROM oidc_login_states WHERE state = ?", state)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return login, nil
+}
+
+// CreateOIDCPendingLink saves a provider account waiting to be linked, returning the
+// token that confirms it
+func (s *Store) CreateOIDCPendingLink(link *models.OIDCPendingLink) (string, error) {
+	token, err := newSecret(24)
+	if err != nil {
+		return "", err
+	}
+
+	// Clear out links nobody confirmed
+	s.db.Exec("DELETE FROM oidc_pending_links WHERE expires_at < ?", time.Now())
+
+	_, err = s.db.Exec(`
+		INSERT INTO oidc_pending_links (token_hash, user_id, issuer, subject, email, device_name, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, hashToken(token), link.UserID, link.Issuer, link.Subject, link.Email, link.DeviceName, link.ExpiresAt)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeOIDCPendingLink returns and deletes an unexpired pending link, so each can
+// only be tried once
+func (s *Store) ConsumeOIDCPendingLink(token string) (*models.OIDCPendingLink, error) {
+	link := &models.OIDCPendingLink{}
+	err := s.db.QueryRow(`
+		SELECT user_id, issuer, subject, email, COALESCE(device_name, ''), expires_at FROM oidc_pending_links
+		WHERE token_hash = ? AND expires_at > ?
+	`, hashToken(token), time.Now()).Scan(&link.UserID, &link.Issuer, &link.Subject, &link.Email, &link.DeviceName, &link.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.Exec("DELETE FROM oidc_pending_links WHERE token_hash = ?", hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return link, nil
+}
+
+// GetUserByIdentity finds the user linked to a provider account
+func (s *Store) GetUserByIdentity(issuer, subject string) (*models.User, error) {
+	var userID string
+	err := s.db.QueryRow("SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?", issuer, subject).Scan(&userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUserByID(userID)
+}
+
+// LinkUserIdentity links a provider account to a user, or records another login for it
+func (s *Store) LinkUserIdentity(userID, issuer, subject, email string) error {
+	now := time.Now()
+	_, err := s.db.Exec(`
+		INSERT INTO user_identities (issuer, subject, user_id, email, created_at, last_login_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(issuer, subject) DO UPDATE SET email = excluded.email, last_login_at = excluded.last_login_at
+	`, issuer, subject, userID, email, now, now)
+	return err
+}
+
+// Signing key operations
+
+// GetSigningKeys returns the active signing key first, followed by retired keys that
+// may still verify unexpired tokens
+func (s *Store) GetSigningKeys() ([]models.SigningKey, error) {
+	rows, err := s.db.Query(`
+		SELECT id, secret, created_at, retired_at FROM signing_keys
+		ORDER BY retired_at IS NOT NULL, created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		var key models.SigningKey
+		var secret string
+		var retiredAt sql.NullTime
+		if err := rows.Scan(&key.ID, &secret, &key.CreatedAt, &retiredAt); err != nil {
+			return nil, err
+		}
+		if key.Secret, err = hex.DecodeString(secret); err != nil {
+			return nil, err
+		}
+		if retiredAt.Valid {
+			key.RetiredAt = &retiredAt.Time
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// RotateSigningKey generates a new active signing key, retiring the current one and
+// deleting keys retired long enough ago that nothing they signed is still valid
+func (s *Store) RotateSigningKey() (*models.SigningKey, error) {
+	secret, err := newSecret(32)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec("UPDATE signing_keys SET retired_at = ? WHERE retired_at IS NULL", now); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM signing_keys WHERE retired_at < ?", now.Add(-signingKeyRetention)); err != nil {
+		return nil, err
+	}
+
+	key := &models.SigningKey{
+		ID:        uuid.New().String(),
+		CreatedAt: now,
+	}
+	if _, err := tx.Exec("INSERT INTO signing_keys (id, secret, created_at) VALUES (?, ?, ?)", key.ID, secret, key.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	key.Secret, _ = hex.DecodeString(secret)
+	return key, nil
+}
+
+// Notification settings operations
+
+// GetChannelNotificationSettings returns a user's settings for a channel, defaulting to all messages
+func (s *Store) GetChannelNotificationSettings(userID, channelID string) (*models.ChannelNotificationSettings, error) {
+	settings := &models.ChannelNotificationSettings{
+		ChannelID: channelID,
+		Level:     models.NotificationLevelAll,
+	}
+
+	var mutedUntil sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT level, muted_until FROM channel_notification_settings
+		WHERE user_id = ? AND channel_id = ?
+	`, userID, channelID).Scan(&settings.Level, &mutedUntil)
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if mutedUntil.Valid && mutedUntil.Time.After(time.Now()) {
+		settings.MutedUntil = &mutedUntil.Time
+	}
+	return settings, nil
+}
+
+func (s *Store) SetChannelNotificationSettings(userID, channelID, level string, mutedUntil *time.Time) error {
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO channel_notification_settings (user_id, channel_id, level, muted_until, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, userID, channelID, level, mutedUntil, time.Now())
+	return err
+}
+
+// MuteChannel silences a channel indefinitely
+func (s *Store) MuteChannel(userID, channelID string) error {
+	return s.SetChannelNotificationSettings(userID, channelID, models.NotificationLevelNothing, nil)
+}
+
+// UnmuteChannel restores the default level and clears any timed mute
+func (s *Store) UnmuteChannel(userID, channelID string) error {
+	_, err := s.db.Exec("DELETE FROM channel_notification_settings WHERE user_id = ? AND channel_id = ?", userID, channelID)
+	return err
+}
+
+func (s *Store) IsChannelMuted(userID, channelID string) (bool, error) {
+	settings, err := s.GetChannelNotificationSettings(userID, channelID)
+	if err != nil {
+		return false, err
+	}
+	return settings.Level == models.NotificationLevelNothing || settings.MutedUntil != nil, nil
+}
+
+// GetMutedChannels returns the channels a user currently gets no notifications from
+func (s *Store) GetMutedChannels(userID string) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT channel_id FROM channel_notification_settings
+		WHERE user_id = ? AND (level = ? OR muted_until > ?)
+	`, userID, models.NotificationLevelNothing, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channelIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		channelIDs = append(channelIDs, id)
+	}
+	return channelIDs, nil
+}
+
+// GetThreadNotificationLevel returns a user's override for a thread, or "" if there is none
+func (s *Store) GetThreadNotificationLevel(userID, messageID string) (string, error) {
+	var level string
+	err := s.db.QueryRow(`
+		SELECT level FROM thread_notification_settings WHERE user_id = ? AND message_id = ?
+	`, userID, messageID).Scan(&level)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return level, err
+}
+
+func (s *Store) SetThreadNotificationLevel(userID, messageID, level string) error {
+	if level == "" {
+		_, err := s.db.Exec("DELETE FROM thread_notification_settings WHERE user_id = ? AND message_id = ?", userID, messageID)
+		return err
+	}
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO thread_notification_settings (user_id, message_id, level, updated_at)
+		VALUES (?, ?, ?, ?)
+	`, userID, messageID, level, time.Now())
+	return err
+}
+
+// GetNotificationLevel resolves the level that applies to a message for a user.
+// A timed mute silences everything; otherwise a thread override wins over the channel level.
+func (s *Store) GetNotificationLevel(userID, channelID string, threadID *string) string {
+	settings, err := s.GetChannelNotificationSettings(userID, channelID)
+	if err != nil {
+		return models.NotificationLevelAll
+	}
+	if settings.MutedUntil != nil {
+		return models.NotificationLevelNothing
+	}
+	if threadID != nil {
+		if level, err := s.GetThreadNotificationLevel(userID, *threadID); err == nil && level != "" {
+			return level
+		}
+	}
+	return settings.Level
+}
+
+// Profile field operations
+
+const profileFieldColumns = `id, name, type, COALESCE(options, ''), visibility, position, created_at`
+
+func scanProfileField(row interface{ Scan(...interface{}) error }) (*models.ProfileField, error) {
+	field := &models.ProfileField{}
+	var options string
+	err := row.Scan(&field.ID, &field.Name, &field.Type, &options, &field.Visibility, &field.Position, &field.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if options != "" {
+		json.Unmarshal([]byte(options), &field.Options)
+	}
+	return field, nil
+}
+
+func (s *Store) CreateProfileField(req *models.ProfileFieldRequest) (*models.ProfileField, error) {
+	field := &models.ProfileField{
+		ID:         uuid.New().String(),
+		Name:       req.Name,
+		Type:       req.Type,
+		Options:    req.Options,
+		Visibility: req.Visibility,
+		Position:   req.Position,
+		CreatedAt:  time.Now(),
+	}
+	options, _ := json.Marshal(field.Options)
+	_, err := s.db.Exec(`
+		INSERT INTO profile_fields (id, name, type, options, visibility, position, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, field.ID, field.Name, field.Type, string(options), field.Visibility, field.Position, field.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return field, nil
+}
+
+func (s *Store) GetProfileField(id string) (*models.ProfileField, error) {
+	return scanProfileField(s.db.QueryRow(`SELECT `+profileFieldColumns+` FROM profile_fields WHERE id = ?`, id))
+}
+
+func (s *Store) GetProfileFields() ([]models.ProfileField, error) {
+	rows, err := s.db.Query(`SELECT ` + profileFieldColumns + ` FROM profile_fields ORDER BY position, created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fields []models.ProfileField
+	for rows.Next() {
+		field, err := scanProfileField(rows)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, *field)
+	}
+	return fields, nil
+}
+
+func (s *Store) UpdateProfileField(id string, req *models.ProfileFieldRequest) error {
+	options, _ := json.Marshal(req.Options)
+	_, err := s.db.Exec(`
+		UPDATE profile_fields SET name = ?, type = ?, options = ?, visibility = ?, position = ? WHERE id = ?
+	`, req.Name, req.Type, string(options), req.Visibility, req.Position, id)
+	return err
+}
+
+// DeleteProfileField removes a field along with everyone's values for it
+func (s *Store) DeleteProfileField(id string) error {
+	if _, err := s.db.Exec("DELETE FROM user_profile_values WHERE field_id = ?", id); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM profile_fields WHERE id = ?", id)
+	return err
+}
+
+// GetUserProfileValues returns a user's custom field values, in field order
+func (s *Store) GetUserProfileValues(userID string) ([]models.ProfileFieldValue, error) {
+	rows, err := s.db.Query(`
+		SELECT f.id, f.name, f.type, v.value, f.visibility
+		FROM user_profile_values v
+		JOIN profile_fields f ON f.id = v.field_id
+		WHERE v.user_id = ?
+		ORDER BY f.position, f.created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []models.ProfileFieldValue
+	for rows.Next() {
+		var v models.ProfileFieldValue
+		if err := rows.Scan(&v.FieldID, &v.Name, &v.Type, &v.Value, &v.Visibility); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// SetUserProfileValue sets a user's value for a custom field; an empty value clears it
+func (s *Store) SetUserProfileValue(userID, fieldID, value string) error {
+	if value == "" {
+		_, err := s.db.Exec("DELETE FROM user_profile_values WHERE user_id = ? AND field_id = ?", userID, fieldID)
+		return err
+	}
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO user_profile_values (user_id, field_id, value) VALUES (?, ?, ?)
+	`, userID, fieldID, value)
+	return err
+}
+
+// User block operations
+
+func (s *Store) BlockUser(blockerID, blockedID string) error {
+	_, err := s.db.Exec(`
+		INSERT OR IGNORE INTO user_blocks (blocker_id, blocked_id, created_at) VALUES (?, ?, ?)
+	`, blockerID, blockedID, time.Now())
+	return err
+}
+
+func (s *Store) UnblockUser(blockerID, blockedID string) error {
+	_, err := s.db.Exec("DELETE FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?", blockerID, blockedID)
+	return err
+}
+
+// GetBlockedUsers returns everyone a user has blocked, most recent first
+func (s *Store) GetBlockedUsers(blockerID string) ([]models.UserBlock, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, u.display_name, COALESCE(u.avatar_url, ''), u.status, u.created_at, b.created_at
+		FROM user_blocks b
+		JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = ?
+		ORDER BY b.created_at DESC
+	`, blockerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []models.UserBlock
+	for rows.Next() {
+		var u models.User
+		var block models.UserBlock
+		if err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL, &u.Status, &u.CreatedAt, &block.BlockedAt); err != nil {
+			return nil, err
+		}
+		block.User = u.ToResponse()
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// GetBlockedUserIDs returns the IDs of everyone a user has blocked
+func (s *Store) GetBlockedUserIDs(blockerID string) (map[string]bool, error) {
+	return s.queryBlockIDs("SELECT blocked_id FROM user_blocks WHERE blocker_id = ?", blockerID)
+}
+
+// GetBlockerIDs returns the IDs of everyone who has blocked a user
+func (s *Store) GetBlockerIDs(blockedID string) (map[string]bool, error) {
+	return s.queryBlockIDs("SELECT blocker_id FROM user_blocks WHERE blocked_id = ?", blockedID)
+}
+
+func (s *Store) queryBlockIDs(query, userID string) (map[string]bool, error) {
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// IsBlockedBetween reports whether either user has blocked the other
+func (s *Store) IsBlockedBetween(userID, otherID string) bool {
+	var exists bool
+	s.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM user_blocks
+			WHERE (blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)
+		)
+	`, userID, otherID, otherID, userID).Scan(&exists)
+	return exists
+}
+
+// Do not disturb operations
+
+// GetDNDSettings returns a user's do-not-disturb schedule and snooze, and whether
+// their status is set to dnd
+func (s *Store) GetDNDSettings(userID string) (*models.DNDSettings, error) {
+	var manualStatus, timezone string
+	var schedule sql.NullString
+	var snoozeUntil sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT COALESCE(u.manual_status, ''), COALESCE(u.timezone, ''), d.schedule, d.snooze_until
+		FROM users u LEFT JOIN dnd_settings d ON d.user_id = u.id
+		WHERE u.id = ?
+	`, userID).Scan(&manualStatus, &timezone, &schedule, &snoozeUntil)
+	if err != nil {
+		return nil, err
+	}
+	return dndSettings(manualStatus, timezone, schedule, snoozeUntil), nil
+}
+
+// dndSettings builds DND settings from their columns. A schedule without its own
+// timezone follows the user's profile timezone.
+func dndSettings(manualStatus, timezone string, schedule sql.NullString, snoozeUntil sql.NullTime) *models.DNDSettings {
+	settings := &models.DNDSettings{ManualDND: manualStatus == "dnd"}
+	if schedule.Valid && schedule.String != "" {
+		var sched models.DNDSchedule
+		if json.Unmarshal([]byte(schedule.String), &sched) == nil {
+			if sched.Timezone == "" {
+				sched.Timezone = timezone
+			}
+			settings.Schedule = &sched
+		}
+	}
+	if snoozeUntil.Valid && snoozeUntil.Time.After(time.Now()) {
+		settings.SnoozeUntil = &snoozeUntil.Time
+	}
+	return settings
+}
+
+// GetDNDUserIDs returns the users who have do not disturb on right now
+func (s *Store) GetDNDUserIDs() (map[string]bool, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, COALESCE(u.manual_status, ''), COALESCE(u.timezone, ''), d.schedule, d.snooze_until
+		FROM users u LEFT JOIN dnd_settings d ON d.user_id = u.id
+		WHERE u.manual_status = 'dnd' OR d.user_id IS NOT NULL
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	userIDs := make(map[string]bool)
+	for rows.Next() {
+		var id, manualStatus, timezone string
+		var schedule sql.NullString
+		var snoozeUntil sql.NullTime
+		if err := rows.Scan(&id, &manualStatus, &timezone, &schedule, &snoozeUntil); err != nil {
+			return nil, err
+		}
+		if dndSettings(manualStatus, timezone, schedule, snoozeUntil).ActiveAt(now) {
+			userIDs[id] = true
+		}
+	}
+	return userIDs, rows.Err()
+}
+
+// IsDNDActive reports whether a user has do not disturb on right now
+func (s *Store) IsDNDActive(userID string) bool {
+	settings, err := s.GetDNDSettings(userID)
+	if err != nil {
+		return false
+	}
+	return settings.ActiveAt(time.Now())
+}
+
+// SetDNDSchedule sets a user's do-not-disturb schedule; nil removes it
+func (s *Store) SetDNDSchedule(userID string, schedule *models.DNDSchedule) error {
+	var scheduleJSON *string
+	if schedule != nil {
+		data, err := json.Marshal(schedule)
+		if err != nil {
+			return err
+		}
+		str := string(data)
+		scheduleJSON = &str
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO dnd_settings (user_id, schedule, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET schedule = excluded.schedule, updated_at = excluded.updated_at
+	`, userID, scheduleJSON, time.Now())
+	return err
+}
+
+// SetDNDSnooze turns do not disturb on until the given time; nil ends the snooze
+func (s *Store) SetDNDSnooze(userID string, until *time.Time) error {
+	_, err := s.db.Exec(`
+		INSERT INTO dnd_settings (user_id, snooze_until, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET snooze_until = excluded.snooze_until, updated_at = excluded.updated_at
+	`, userID, until, time.Now())
+	return err
+}
+
+// HoldNotification keeps a push back until the user's do not disturb ends
+func (s *Store) HoldNotification(userID, wsType string, payload []byte) error {
+	_, err := s.db.Exec(`
+		INSERT INTO held_notifications (id, user_id, type, payload, created_at) VALUES (?, ?, ?, ?, ?)
+	`, uuid.New().String(), userID, wsType, string(payload), time.Now())
+	return err
+}
+
+// GetUsersWithHeldNotifications returns the users who have pushes waiting
+func (s *Store) GetUsersWithHeldNotifications() ([]string, error) {
+	rows, err := s.db.Query("SELECT DISTINCT user_id FROM held_notifications")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
+
+// TakeHeldNotifications removes and returns a user's held pushes, oldest first
+func (s *Store) TakeHeldNotifications(userID string) ([]models.HeldNotification, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, type, payload, created_at FROM held_notifications
+		WHERE user_id = ? ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var held []models.HeldNotification
+	var ids []string
+	for rows.Next() {
+		var n models.HeldNotification
+		var id, payload string
+		if err := rows.Scan(&id, &n.Type, &payload, &n.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		n.Payload = json.RawMessage(payload)
+		held = append(held, n)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if _, err := tx.Exec("DELETE FROM held_notifications WHERE id = ?", id); err != nil {
+			return nil, err
+		}
+	}
+	return held, tx.Commit()
+}
+
+// Data export operations
+
+const dataExportColumns = `id, user_id, status, size, COALESCE(error, ''), created_at, completed_at, expires_at`
+
+func scanDataExport(row interface{ Scan(...interface{}) error }) (*models.DataExport, error) {
+	export := &models.DataExport{}
+	var completedAt, expiresAt sql.NullTime
+	err := row.Scan(&export.ID, &export.UserID, &export.Status, &export.Size, &export.Error, &export.CreatedAt, &completedAt, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if completedAt.Valid {
+		export.CompletedAt = &completedAt.Time
+	}
+	if expiresAt.Valid {
+		export.ExpiresAt = &expiresAt.Time
+	}
+	return export, nil
+}
+
+func (s *Store) CreateDataExport(userID string) (*models.DataExport, error) {
+	export := &models.DataExport{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Status:    models.DataExportPending,
+		CreatedAt: time.Now(),
+	}
+	_, err := s.db.Exec("INSERT INTO data_exports (id, user_id, status, created_at) VALUES (?, ?, ?, ?)",
+		export.ID, export.UserID, export.Status, export.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+func (s *Store) GetDataExport(id string) (*models.DataExport, error) {
+	return scanDataExport(s.db.QueryRow(`SELECT `+dataExportColumns+` FROM data_exports WHERE id = ?`, id))
+}
+
+// GetDataExports returns a user's exports, newest first
+func (s *Store) GetDataExports(userID string) ([]models.DataExport, error) {
+	rows, err := s.db.Query(`SELECT `+dataExportColumns+` FROM data_exports WHERE user_id = ? ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []models.DataExport
+	for rows.Next() {
+		export, err := scanDataExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, *export)
+	}
+	return exports, nil
+}
+
+// CompleteDataExport marks an export ready to download until it expires
+func (s *Store) CompleteDataExport(id string, size int64) error {
+	now := time.Now()
+	_, err := s.db.Exec("UPDATE data_exports SET status = ?, size = ?, completed_at = ?, expires_at = ? WHERE id = ?",
+		models.DataExportReady, size, now, now.Add(models.DataExportTTL), id)
+	return err
+}
+
+func (s *Store) FailDataExport(id, reason string) error {
+	_, err := s.db.Exec("UPDATE data_exports SET status = ?, error = ?, completed_at = ? WHERE id = ?",
+		models.DataExportFailed, reason, time.Now(), id)
+	return err
+}
+
+// FailInterruptedDataExports fails exports that were still being built when the
+// server last stopped
+func (s *Store) FailInterruptedDataExports() error {
+	_, err := s.db.Exec("UPDATE data_exports SET status = ?, error = ?, completed_at = ? WHERE status = ?",
+		models.DataExportFailed, "The server restarted before the export finished", time.Now(), models.DataExportPending)
+	return err
+}
+
+// DeleteExpiredDataExports removes exports past their expiry, returning their IDs
+// so their files can be removed
+func (s *Store) DeleteExpiredDataExports() ([]string, error) {
+	rows, err := s.db.Query("DELETE FROM data_exports WHERE expires_at <= ? RETURNING id", time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// DeleteDataExports removes all of a user's exports, returning their IDs so their
+// files can be removed
+func (s *Store) DeleteDataExports(userID string) ([]string, error) {
+	rows, err := s.db.Query("DELETE FROM data_exports WHERE user_id = ? RETURNING id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// GetMessagesByUser returns every message a user wrote, oldest first
+func (s *Store) GetMessagesByUser(userID string) ([]models.ExportedMessage, error) {
+	rows, err := s.db.Query(`
+		SELECT m.id, m.channel_id, m.user_id, m.content, m.html_content, m.widget_size, m.thread_id, m.created_at, COALESCE(m.type, ''),
+			COALESCE(c.name, ''), COALESCE(c.is_direct, FALSE)
+		FROM messages m
+		LEFT JOIN channels c ON c.id = m.channel_id
+		WHERE m.user_id = ?
+		ORDER BY m.created_at ASC
+	`, userID)
 	if err != nil {
 		return nil, err
 	}
-	return reminder, nil
+	defer rows.Close()
+
+	var messages []models.ExportedMessage
+	for rows.Next() {
+		var msg models.ExportedMessage
+		var htmlContent, widgetSize, threadID sql.NullString
+		err := rows.Scan(&msg.ID, &msg.ChannelID, &msg.UserID, &msg.Content, &htmlContent, &widgetSize, &threadID, &msg.CreatedAt, &msg.Type,
+			&msg.ChannelName, &msg.IsDirect)
+		if err != nil {
+			return nil, err
+		}
+		if htmlContent.Valid {
+			msg.HTMLContent = &htmlContent.String
+		}
+		if widgetSize.Valid {
+			msg.WidgetSize = &widgetSize.String
+		}
+		if threadID.Valid {
+			msg.ThreadID = &threadID.String
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
 }
 
-func (s *Store) GetRemindersForUser(userID string) ([]models.Reminder, error) {
+// GetReactionsByUser returns every reaction a user added, oldest first
+func (s *Store) GetReactionsByUser(userID string) ([]models.Reaction, error) {
 	rows, err := s.db.Query(`
-		SELECT id, user_id, channel_id, message, remind_at, created_at, completed
-		FROM reminders
-		WHERE user_id = ? AND completed = FALSE
-		ORDER BY remind_at ASC
+		SELECT id, message_id, user_id, emoji, created_at
+		FROM reactions WHERE user_id = ?
+		ORDER BY created_at ASC
 	`, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var reminders []models.Reminder
+	var reactions []models.Reaction
 	for rows.Next() {
-		var r models.Reminder
-		err := rows.Scan(&r.ID, &r.UserID, &r.ChannelID, &r.Message, &r.RemindAt, &r.CreatedAt, &r.Completed)
-		if err != nil {
+		var r models.Reaction
+		if err := rows.Scan(&r.ID, &r.MessageID, &r.UserID, &r.Emoji, &r.CreatedAt); err != nil {
 			return nil, err
 		}
-		reminders = append(reminders, r)
+		reactions = append(reactions, r)
 	}
-	return reminders, nil
+	return reactions, nil
 }
 
-func (s *Store) GetDueReminders() ([]models.Reminder, error) {
+// GetAllRemindersForUser returns a user's reminders, including completed ones
+func (s *Store) GetAllRemindersForUser(userID string) ([]models.Reminder, error) {
 	rows, err := s.db.Query(`
 		SELECT id, user_id, channel_id, message, remind_at, created_at, completed
 		FROM reminders
-		WHERE completed = FALSE AND remind_at <= ?
+		WHERE user_id = ?
 		ORDER BY remind_at ASC
-	`, time.Now())
+	`, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -976,8 +4552,7 @@ func (s *Store) GetDueReminders() ([]models.Reminder, error) {
 	var reminders []models.Reminder
 	for rows.Next() {
 		var r models.Reminder
-		err := rows.Scan(&r.ID, &r.UserID, &r.ChannelID, &r.Message, &r.RemindAt, &r.CreatedAt, &r.Completed)
-		if err != nil {
+		if err := rows.Scan(&r.ID, &r.UserID, &r.ChannelID, &r.Message, &r.RemindAt, &r.CreatedAt, &r.Completed); err != nil {
 			return nil, err
 		}
 		reminders = append(reminders, r)
@@ -985,60 +4560,149 @@ func (s *Store) GetDueReminders() ([]models.Reminder, error) {
 	return reminders, nil
 }
 
-func (s *Store) MarkReminderComplete(id string) error {
-	_, err := s.db.Exec("UPDATE reminders SET completed = TRUE WHERE id = ?", id)
-	return err
-}
-
-func (s *Store) DeleteReminder(id, userID string) error {
-	_, err := s.db.Exec("DELETE FROM reminders WHERE id = ? AND user_id = ?", id, userID)
-	return err
-}
-
-// Muted channels operations
+// GetKanbanActivity returns the cards a user created or is assigned to and the
+// comments they wrote
+func (s *Store) GetKanbanActivity(userID string) (*models.KanbanActivity, error) {
+	activity := &models.KanbanActivity{Comments: []models.KanbanComment{}}
+	var err error
 
-func (s *Store) MuteChannel(userID, channelID string) error {
-	_, err := s.db.Exec(`
-		INSERT OR REPLACE INTO muted_channels (user_id, channel_id, muted_at)
-		VALUES (?, ?, ?)
-	`, userID, channelID, time.Now())
-	return err
-}
+	activity.CardsCreated, err = s.queryKanbanCards("WHERE created_by = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	activity.CardsAssigned, err = s.queryKanbanCards("WHERE id IN (SELECT card_id FROM kanban_card_assignees WHERE user_id = ?)", userID)
+	if err != nil {
+		return nil, err
+	}
 
-func (s *Store) UnmuteChannel(userID, channelID string) error {
-	_, err := s.db.Exec("DELETE FROM muted_channels WHERE user_id = ? AND channel_id = ?", userID, channelID)
-	return err
-}
+	rows, err := s.db.Query(`
+		SELECT id, card_id, user_id, content, created_at, updated_at
+		FROM kanban_comments WHERE user_id = ?
+		ORDER BY created_at ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-func (s *Store) IsChannelMuted(userID, channelID string) (bool, error) {
-	var count int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM muted_channels WHERE user_id = ? AND channel_id = ?", userID, channelID).Scan(&count)
-	return count > 0, err
+	for rows.Next() {
+		var c models.KanbanComment
+		if err := rows.Scan(&c.ID, &c.CardID, &c.UserID, &c.Content, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		activity.Comments = append(activity.Comments, c)
+	}
+	return activity, nil
 }
 
-func (s *Store) GetMutedChannels(userID string) ([]string, error) {
-	rows, err := s.db.Query("SELECT channel_id FROM muted_channels WHERE user_id = ?", userID)
+func (s *Store) queryKanbanCards(where string, args ...interface{}) ([]models.KanbanCard, error) {
+	rows, err := s.db.Query(`
+		SELECT id, column_id, board_id, title, COALESCE(description, ''), position, due_date, created_by, created_at, updated_at
+		FROM kanban_cards `+where+`
+		ORDER BY created_at ASC
+	`, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var channelIDs []string
+	cards := []models.KanbanCard{}
 	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
+		var card models.KanbanCard
+		var dueDate sql.NullTime
+		err := rows.Scan(&card.ID, &card.ColumnID, &card.BoardID, &card.Title, &card.Description, &card.Position, &dueDate, &card.CreatedBy, &card.CreatedAt, &card.UpdatedAt)
+		if err != nil {
 			return nil, err
 		}
-		channelIDs = append(channelIDs, id)
+		if dueDate.Valid {
+			card.DueDate = &dueDate.Time
+		}
+		cards = append(cards, card)
 	}
-	return channelIDs, nil
+	return cards, nil
+}
+
+// Account deletion operations
+
+// DeleteUserAccount anonymizes a user who deleted their account. Their messages,
+// reactions, kanban cards and comments stay but are shown as a deleted user; their
+// credentials, sessions, profile, settings and personal data are removed, and the
+// account can't sign in again. Revoke their sessions first to disconnect them.
+func (s *Store) DeleteUserAccount(userID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	// "!" is never a valid bcrypt hash, so no password matches it
+	_, err = tx.Exec(`
+		UPDATE users SET
+			username = ?, display_name = ?, password_hash = '!', email = NULL, email_verified_at = NULL, avatar_url = NULL,
+			title = NULL, pronouns = NULL, phone = NULL, timezone = NULL,
+			status_emoji = NULL, status_text = NULL, status_expires_at = NULL,
+			manual_status = '', presence = 'offline', status = 'offline', role = ?,
+			deactivated_at = COALESCE(deactivated_at, ?), deleted_at = ?
+		WHERE id = ?
+	`, "deleted-"+strings.ReplaceAll(userID, "-", "")[:12], models.DeletedUserDisplayName, models.ServerRoleMember, now, now, userID)
+	if err != nil {
+		return err
+	}
+
+	statements := []string{
+		"DELETE FROM sessions WHERE user_id = ?",
+		"DELETE FROM password_reset_tokens WHERE user_id = ?",
+		"DELETE FROM user_totp WHERE user_id = ?",
+		"DELETE FROM recovery_codes WHERE user_id = ?",
+		"DELETE FROM login_challenges WHERE user_id = ?",
+		"DELETE FROM user_identities WHERE user_id = ?",
+		"DELETE FROM personal_access_tokens WHERE user_id = ?",
+		"DELETE FROM user_profile_values WHERE user_id = ?",
+		"DELETE FROM user_blocks WHERE blocker_id = ? OR blocked_id = ?",
+		"DELETE FROM dnd_settings WHERE user_id = ?",
+		"DELETE FROM held_notifications WHERE user_id = ?",
+		"DELETE FROM user_preferences WHERE user_id = ?",
+		"DELETE FROM reminders WHERE user_id = ?",
+		"DELETE FROM channel_notification_settings WHERE user_id = ?",
+		"DELETE FROM thread_notification_settings WHERE user_id = ?",
+		"DELETE FROM channel_section_items WHERE user_id = ?",
+		"DELETE FROM channel_sections WHERE user_id = ?",
+		"DELETE FROM custom_commands WHERE created_by = ? AND is_global = FALSE",
+		"DELETE FROM kanban_card_assignees WHERE user_id = ?",
+		"DELETE FROM kanban_board_members WHERE user_id = ?",
+		"DELETE FROM app_members WHERE user_id = ?",
+		"DELETE FROM channel_members WHERE user_id = ?",
+	}
+	for _, stmt := range statements {
+		args := []interface{}{userID}
+		if strings.Count(stmt, "?") == 2 {
+			args = append(args, userID)
+		}
+		if _, err := tx.Exec(stmt, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 // Leave channel operation
 
+// LeaveChannel removes a member from a channel and from their sidebar sections
 func (s *Store) LeaveChannel(channelID, userID string) error {
-	_, err := s.db.Exec("DELETE FROM channel_members WHERE channel_id = ? AND user_id = ?", channelID, userID)
-	return err
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM channel_members WHERE channel_id = ? AND user_id = ?", channelID, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM channel_section_items WHERE channel_id = ? AND user_id = ?", channelID, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // Bot operations
@@ -2463,6 +6127,35 @@ func (s *Store) GetCommandsForUser(userID string) ([]models.CustomCommand, error
 	return commands, nil
 }
 
+// GetCommandsCreatedBy returns the commands a user created, including disabled ones
+func (s *Store) GetCommandsCreatedBy(userID string) ([]models.CustomCommand, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, description, url, method, headers, body_template, is_global, created_by, response_mode, enabled, created_at, updated_at
+		FROM custom_commands
+		WHERE created_by = ?
+		ORDER BY name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var commands []models.CustomCommand
+	for rows.Next() {
+		var cmd models.CustomCommand
+		var description, headers, bodyTemplate sql.NullString
+		err := rows.Scan(&cmd.ID, &cmd.Name, &description, &cmd.URL, &cmd.Method, &headers, &bodyTemplate, &cmd.IsGlobal, &cmd.CreatedBy, &cmd.ResponseMode, &cmd.Enabled, &cmd.CreatedAt, &cmd.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Description = description.String
+		cmd.Headers = headers.String
+		cmd.BodyTemplate = bodyTemplate.String
+		commands = append(commands, cmd)
+	}
+	return commands, nil
+}
+
 func (s *Store) UpdateCommand(id string, req *models.UpdateCommandRequest) error {
 	// Build dynamic update query
 	var updates []string
@@ -2578,3 +6271,196 @@ func (s *Store) SetServerSetting(key, value string) error {
 	`, key, value)
 	return err
 }
+
+// Password policy
+
+const passwordPolicySettingKey = "password_policy"
+
+// GetPasswordPolicy returns the configured password rules, or the defaults if none are saved
+func (s *Store) GetPasswordPolicy() (models.PasswordPolicy, error) {
+	policy := models.DefaultPasswordPolicy()
+
+	value, err := s.GetServerSetting(passwordPolicySettingKey)
+	if err == sql.ErrNoRows {
+		return policy, nil
+	}
+	if err != nil {
+		return policy, err
+	}
+
+	err = json.Unmarshal([]byte(value), &policy)
+	return policy, err
+}
+
+func (s *Store) SetPasswordPolicy(policy models.PasswordPolicy) error {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return s.SetServerSetting(passwordPolicySettingKey, string(data))
+}
+
+// Two-factor requirement
+
+const requireTwoFactorSettingKey = "require_2fa"
+
+// IsTwoFactorRequired reports whether every user must set up 2FA to sign in
+func (s *Store) IsTwoFactorRequired() bool {
+	value, err := s.GetServerSetting(requireTwoFactorSettingKey)
+	return err == nil && value == "true"
+}
+
+func (s *Store) SetTwoFactorRequired(required bool) error {
+	return s.SetServerSetting(requireTwoFactorSettingKey, strconv.FormatBool(required))
+}
+
+// Registration settings
+
+const registrationSettingKey = "registration"
+
+func (s *Store) GetRegistrationSettings() (*models.RegistrationSettings, error) {
+	settings := models.DefaultRegistrationSettings
+
+	value, err := s.GetServerSetting(registrationSettingKey)
+	if err == sql.ErrNoRows {
+		return &settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(value), &settings); err != nil {
+		return nil, err
+	}
+	if settings.AllowedDomains == nil {
+		settings.AllowedDomains = []string{}
+	}
+	return &settings, nil
+}
+
+func (s *Store) SetRegistrationSettings(settings *models.RegistrationSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return s.SetServerSetting(registrationSettingKey, string(data))
+}
+
+// Single sign-on settings
+
+const oidcSettingKey = "oidc"
+
+// GetOIDCSettings returns the single sign-on configuration, including the client secret
+func (s *Store) GetOIDCSettings() (*models.OIDCSettings, error) {
+	settings := &models.OIDCSettings{}
+
+	value, err := s.GetServerSetting(oidcSettingKey)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(value), settings); err != nil {
+		return nil, err
+	}
+	if len(settings.Scopes) == 0 {
+		settings.Scopes = models.DefaultOIDCScopes
+	}
+	return settings, nil
+}
+
+func (s *Store) SetOIDCSettings(settings *models.OIDCSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return s.SetServerSetting(oidcSettingKey, string(data))
+}
+
+// Onboarding
+
+const onboardingSettingKey = "onboarding"
+
+// GetOnboardingSettings returns the onboarding configuration. Until an admin saves one,
+// new users just join #general.
+func (s *Store) GetOnboardingSettings() (*models.OnboardingSettings, error) {
+	settings := &models.OnboardingSettings{
+		DefaultChannels: []string{},
+		StarterBoards:   []string{},
+		StarterApps:     []string{},
+	}
+
+	value, err := s.GetServerSetting(onboardingSettingKey)
+	if err == sql.ErrNoRows {
+		var generalID string
+		s.db.QueryRow("SELECT id FROM channels WHERE name = 'general'").Scan(&generalID)
+		if generalID != "" {
+			settings.DefaultChannels = append(settings.DefaultChannels, generalID)
+		}
+		return settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(value), settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (s *Store) SetOnboardingSettings(settings *models.OnboardingSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return s.SetServerSetting(onboardingSettingKey, string(data))
+}
+
+// OnboardUser sets up a newly registered user: joins the default channels, adds them to
+// starter boards and apps, and sends the welcome DM from Smackbot if one is configured.
+// Channels, boards or apps that no longer exist are skipped.
+func (s *Store) OnboardUser(userID string) error {
+	settings, err := s.GetOnboardingSettings()
+	if err != nil {
+		return err
+	}
+
+	for _, channelID := range settings.DefaultChannels {
+		channel, err := s.GetChannel(channelID)
+		if err != nil || channel.IsDirect || channel.ArchivedAt != nil {
+			continue
+		}
+		s.JoinChannel(channelID, userID)
+	}
+
+	for _, boardID := range settings.StarterBoards {
+		if _, err := s.GetBoard(boardID); err == nil {
+			s.AddBoardMember(boardID, userID, "member")
+		}
+	}
+
+	for _, appID := range settings.StarterApps {
+		if _, err := s.GetApp(appID); err == nil {
+			s.AddAppMember(appID, userID, "member")
+		}
+	}
+
+	if strings.TrimSpace(settings.WelcomeMessage) == "" {
+		return nil
+	}
+
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	content := strings.NewReplacer(
+		"{{display_name}}", user.DisplayName,
+		"{{username}}", user.Username,
+	).Replace(settings.WelcomeMessage)
+	_, err = s.SendSmackbotDM(userID, content)
+	return err
+}
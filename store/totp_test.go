@@ -0,0 +1,72 @@
+package store
+
+import (
+	"path/filepath"
+	"smack-server/totp"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s
+}
+
+func TestValidateTOTPRejectsReplay(t *testing.T) {
+	s := newTestStore(t)
+	user, err := s.CreateUser("alice", "Alice", "secret123")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	secret, err := s.StartTOTPEnrollment(user.ID)
+	if err != nil {
+		t.Fatalf("StartTOTPEnrollment: %v", err)
+	}
+
+	step := totp.Step(time.Now())
+	code, err := totp.CodeAt(secret, step)
+	if err != nil {
+		t.Fatalf("CodeAt: %v", err)
+	}
+	if !s.ValidateTOTP(user.ID, code) {
+		t.Fatal("ValidateTOTP rejected a fresh code")
+	}
+	if s.ValidateTOTP(user.ID, code) {
+		t.Error("ValidateTOTP accepted the same code twice")
+	}
+
+	// A code from an earlier step in the window is a replay too once a later one was used
+	previous, _ := totp.CodeAt(secret, step-1)
+	if s.ValidateTOTP(user.ID, previous) {
+		t.Error("ValidateTOTP accepted a code older than the last one used")
+	}
+
+	next, _ := totp.CodeAt(secret, step+1)
+	if !s.ValidateTOTP(user.ID, next) {
+		t.Error("ValidateTOTP rejected a code from a later step")
+	}
+}
+
+func TestValidateTOTPWrongCode(t *testing.T) {
+	s := newTestStore(t)
+	user, err := s.CreateUser("bob", "Bob", "secret123")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if s.ValidateTOTP(user.ID, "123456") {
+		t.Error("ValidateTOTP succeeded without an enrollment")
+	}
+
+	secret, err := s.StartTOTPEnrollment(user.ID)
+	if err != nil {
+		t.Fatalf("StartTOTPEnrollment: %v", err)
+	}
+	stale, _ := totp.CodeAt(secret, totp.Step(time.Now())-5)
+	if s.ValidateTOTP(user.ID, stale) {
+		t.Error("ValidateTOTP accepted a code outside the window")
+	}
+}
@@ -0,0 +1,106 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// The shared secret used by the RFC 4226 and RFC 6238 SHA-1 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeAtHOTPVectors(t *testing.T) {
+	// RFC 4226 appendix D
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		got, err := CodeAt(rfcSecret, int64(counter))
+		if err != nil {
+			t.Fatalf("CodeAt(%d): %v", counter, err)
+		}
+		if got != code {
+			t.Errorf("CodeAt(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestCodeAtTOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B (SHA-1), truncated to the last six of the eight digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := CodeAt(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("CodeAt at %d: %v", tt.unix, err)
+		}
+		if got != tt.code {
+			t.Errorf("CodeAt at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestCodeAtLowercaseSecret(t *testing.T) {
+	lower := []byte(rfcSecret)
+	for i, c := range lower {
+		if c >= 'A' && c <= 'Z' {
+			lower[i] = c + 'a' - 'A'
+		}
+	}
+	got, err := CodeAt(string(lower), 1)
+	if err != nil || got != "287082" {
+		t.Errorf("CodeAt with lowercase secret = %s, %v, want 287082", got, err)
+	}
+}
+
+func TestCodeAtInvalidSecret(t *testing.T) {
+	if _, err := CodeAt("not base32!", 1); err == nil {
+		t.Error("CodeAt with an invalid secret succeeded")
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	tests := []struct {
+		name  string
+		step  int64
+		valid bool
+	}{
+		{"current step", step, true},
+		{"previous step", step - 1, true},
+		{"next step", step + 1, true},
+		{"two steps back", step - 2, false},
+		{"two steps ahead", step + 2, false},
+	}
+	for _, tt := range tests {
+		code, err := CodeAt(rfcSecret, tt.step)
+		if err != nil {
+			t.Fatalf("%s: CodeAt: %v", tt.name, err)
+		}
+		matched, ok := Validate(rfcSecret, code, now)
+		if ok != tt.valid {
+			t.Errorf("%s: Validate = %v, want %v", tt.name, ok, tt.valid)
+		}
+		if ok && matched != tt.step {
+			t.Errorf("%s: Validate matched step %d, want %d", tt.name, matched, tt.step)
+		}
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate(%q) succeeded", code)
+		}
+	}
+}